@@ -89,7 +89,64 @@ func NewUDPSunny(uniqueId, Type,PID int, Body []byte, LocalAddress, RemoteAddres
 }
 var __lock sync.Mutex
 var scriptLog = make([]any, 0, 1000)
+var __logHook func(msg ...interface{})
+func _____internal_______setLogHook(f func(msg ...interface{})) {
+	__logHook = f
+}
+var __mcpToolHook func(name, description string, inputSchema map[string]interface{}, handler func(args map[string]interface{}) (interface{}, error)) string
+type __mcpToolRegistration struct {
+	name, description string
+	inputSchema map[string]interface{}
+	handler func(args map[string]interface{}) (interface{}, error)
+}
+var __mcpToolPending []__mcpToolRegistration
+func _____internal_______setMCPToolHook(f func(name, description string, inputSchema map[string]interface{}, handler func(args map[string]interface{}) (interface{}, error)) string) {
+	__lock.Lock()
+	__mcpToolHook = f
+	pending := __mcpToolPending
+	__mcpToolPending = nil
+	__lock.Unlock()
+	for _, t := range pending {
+		f(t.name, t.description, t.inputSchema, t.handler)
+	}
+}
+// RegisterMCPTool 注册MCP工具, inputSchema 为参数的JSON Schema, 返回错误信息
+func RegisterMCPTool(name, description string, inputSchema map[string]interface{}, handler func(args map[string]interface{}) (interface{}, error)) string {
+	if handler == nil {
+		return "handler 不能为空"
+	}
+	__lock.Lock()
+	hook := __mcpToolHook
+	if hook == nil {
+		__mcpToolPending = append(__mcpToolPending, __mcpToolRegistration{name, description, inputSchema, handler})
+		__lock.Unlock()
+		return ""
+	}
+	__lock.Unlock()
+	return hook(name, description, inputSchema, handler)
+}
+// UnregisterMCPTool 删除脚本注册的MCP工具
+func UnregisterMCPTool(name string) string {
+	__lock.Lock()
+	hook := __mcpToolHook
+	if hook == nil {
+		list := make([]__mcpToolRegistration, 0, len(__mcpToolPending))
+		for _, t := range __mcpToolPending {
+			if t.name != name {
+				list = append(list, t)
+			}
+		}
+		__mcpToolPending = list
+		__lock.Unlock()
+		return ""
+	}
+	__lock.Unlock()
+	return hook(name, "", nil, nil)
+}
 func Log(msg ...interface{}) {
+	if __logHook != nil {
+		__logHook(msg...)
+	}
 	__lock.Lock()
 	if len(msg) > 1 {
 		scriptLog = append(scriptLog, msg...)
@@ -213,6 +270,11 @@ func extractCodeBody(s string) string {
 	return res
 }
 func RunCode() (SErr string) {
+	defer func() {
+		if SErr != "" {
+			MCPLog("error", "script", "脚本编译失败: "+SErr)
+		}
+	}()
 	var iEval = interp.New(interp.Options{})
 	iEval.Use(stdlib.Symbols)
 	ca := string(GlobalConfig.GoScriptCode) + ScriptCode
@@ -384,6 +446,26 @@ func RunCode() (SErr string) {
 		return "setPidGetName"
 	}
 	setPidGetName(GetPidName)
+	v, err = iEval.Eval("main._____internal_______setLogHook")
+	if err != nil {
+		return err.Error()
+	}
+	setLogHook := v.Interface().(func(func(msg ...interface{})))
+	if setLogHook == nil {
+		return "setLogHook"
+	}
+	setLogHook(scriptLogHook)
+	v, err = iEval.Eval("main._____internal_______setMCPToolHook")
+	if err != nil {
+		return err.Error()
+	}
+	setMCPToolHook := v.Interface().(func(func(name, description string, inputSchema map[string]interface{}, handler func(args map[string]interface{}) (interface{}, error)) string))
+	if setMCPToolHook == nil {
+		return "setMCPToolHook"
+	}
+	//脚本重新加载后,上一次脚本注册的工具失效
+	resetScriptTools()
+	setMCPToolHook(scriptToolHook)
 	lock.Lock()
 	NewEval = iEval
 	httpFunc = _httpFunc
@@ -584,6 +666,7 @@ func RunHTTPResponseScriptCode(Conn SunnyNet.HttpConn) (_Return_ bool) {
 		Conn.SetResponseBody(_Body)
 	}
 	h1 := HashMap.GetRequest(Conn.Theology())
+	defer HashMap.Release(h1)
 	if h1 == nil {
 		return false
 	}
@@ -734,6 +817,25 @@ type ConfigReplaceRules struct {
 	Dest string `json:"Dest"`
 	Hash string `json:"Hash"`
 }
+type ConfigPromptArgument struct {
+	Name        string `json:"Name"`
+	Description string `json:"Description"`
+	Required    bool   `json:"Required"`
+}
+type ConfigPromptTemplate struct {
+	Name        string                 `json:"Name"`
+	Description string                 `json:"Description"`
+	Arguments   []ConfigPromptArgument `json:"Arguments"`
+	Template    string                 `json:"Template"`
+}
+type ConfigMCPTool struct {
+	Name        string                 `json:"Name"`
+	Description string                 `json:"Description"`
+	InputSchema map[string]interface{} `json:"InputSchema"` //参数的JSON Schema，为空时不接受参数
+	Tool        string                 `json:"Tool"`        //调用的MCP工具
+	Arguments   map[string]interface{} `json:"Arguments"`   //固定参数，覆盖调用方传入的同名参数
+	ScriptFunc  string                 `json:"ScriptFunc"`  //或调用Go脚本中的函数 func(args map[string]interface{}) (interface{}, error)
+}
 type ConfigRequestCertManager struct {
 	Rule     uint8  `json:"rule"`
 	FilePath string `json:"FilePath"`
@@ -786,7 +888,27 @@ type UserConfig struct {
 		KeyPath string `json:"KeyPath"`
 	} `json:"Cert"`
 	RequestCertManager map[int]ConfigRequestCertManager `json:"RequestCertManager"`
+	CaptureLimits      MapHash.Limits                   `json:"CaptureLimits"` //抓包存储上限
+	PromptTemplates    []ConfigPromptTemplate           `json:"PromptTemplates"`
+	CustomTools        []ConfigMCPTool                  `json:"CustomTools"`
 	GOOS               string                           `json:"GOOS"`
+	MCP                struct {
+		Token          string            `json:"Token"`          //访问令牌（Authorization: Bearer）
+		BindAddress    string            `json:"BindAddress"`    //监听地址，默认仅本机
+		AllowedOrigins []string          `json:"AllowedOrigins"` //允许跨域访问的来源
+		ReadOnly       bool              `json:"ReadOnly"`       //只读模式：只允许调用只读工具
+		ToolPolicy     map[string]string `json:"ToolPolicy"`     //单个工具的策略 allow/deny/confirm，优先于只读模式
+	} `json:"MCP"`
+	CaptureStore struct {
+		Enable      bool   `json:"Enable"`      //启用磁盘存储，数据体写入磁盘，重启后恢复会话
+		Dir         string `json:"Dir"`         //存储目录，默认 ~/Sunny/capture
+		IdleSeconds int    `json:"IdleSeconds"` //会话空闲多少秒后把数据体写入磁盘，默认 30
+	} `json:"CaptureStore"`
+	Autosave struct {
+		Enable          bool   `json:"Enable"`          //定时把会话追加到日志文件，崩溃或断电后重启时恢复
+		Path            string `json:"Path"`            //日志文件路径，默认 ~/Sunny/autosave.syn
+		IntervalSeconds int    `json:"IntervalSeconds"` //保存间隔秒数，默认 30
+	} `json:"Autosave"`
 }
 
 func (c *UserConfig) loadDefaultValue() {
@@ -815,6 +937,24 @@ func (c *UserConfig) loadDefaultValue() {
 	if c.AuthenticationUserInfo == nil {
 		c.AuthenticationUserInfo = make(map[string]string)
 	}
+	if c.PromptTemplates == nil {
+		c.PromptTemplates = make([]ConfigPromptTemplate, 0)
+	}
+	if c.CustomTools == nil {
+		c.CustomTools = make([]ConfigMCPTool, 0)
+	}
+	if c.MCP.BindAddress == "" {
+		c.MCP.BindAddress = "127.0.0.1"
+	}
+	if c.MCP.AllowedOrigins == nil {
+		c.MCP.AllowedOrigins = make([]string, 0)
+	}
+	if c.MCP.ToolPolicy == nil {
+		c.MCP.ToolPolicy = make(map[string]string)
+	}
+	if c.CaptureLimits.Policy == "" {
+		c.CaptureLimits.Policy = MapHash.EvictOldest
+	}
 	//证书选择使用
 	{
 		if c.Cert.Default == false {
@@ -1047,6 +1187,134 @@ func (c *UserConfig) ResetColorConfig(dark bool) error {
 	return c.saveToFile()
 }
 
+// SavePromptTemplate 添加或更新自定义提示词模板（按名称覆盖）
+func (c *UserConfig) SavePromptTemplate(t ConfigPromptTemplate) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	for i := range c.PromptTemplates {
+		if c.PromptTemplates[i].Name == t.Name {
+			c.PromptTemplates[i] = t
+			return c.saveToFile()
+		}
+	}
+	c.PromptTemplates = append(c.PromptTemplates, t)
+	return c.saveToFile()
+}
+
+// DeletePromptTemplate 删除自定义提示词模板
+func (c *UserConfig) DeletePromptTemplate(name string) (bool, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	list := make([]ConfigPromptTemplate, 0, len(c.PromptTemplates))
+	found := false
+	for _, t := range c.PromptTemplates {
+		if t.Name == name {
+			found = true
+			continue
+		}
+		list = append(list, t)
+	}
+	if !found {
+		return false, nil
+	}
+	c.PromptTemplates = list
+	return true, c.saveToFile()
+}
+
+// SaveCustomTool 添加或更新自定义MCP工具（按名称覆盖）
+func (c *UserConfig) SaveCustomTool(t ConfigMCPTool) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	for i := range c.CustomTools {
+		if c.CustomTools[i].Name == t.Name {
+			c.CustomTools[i] = t
+			return c.saveToFile()
+		}
+	}
+	c.CustomTools = append(c.CustomTools, t)
+	return c.saveToFile()
+}
+
+// DeleteCustomTool 删除自定义MCP工具
+func (c *UserConfig) DeleteCustomTool(name string) (bool, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	list := make([]ConfigMCPTool, 0, len(c.CustomTools))
+	found := false
+	for _, t := range c.CustomTools {
+		if t.Name == name {
+			found = true
+			continue
+		}
+		list = append(list, t)
+	}
+	if !found {
+		return false, nil
+	}
+	c.CustomTools = list
+	return true, c.saveToFile()
+}
+
+// GetMCPToken 获取MCP访问令牌，没有时生成并保存
+func (c *UserConfig) GetMCPToken() string {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if c.MCP.Token == "" {
+		c.MCP.Token = newSessionID() + newSessionID()
+		_ = c.saveToFile()
+	}
+	return c.MCP.Token
+}
+
+// ResetMCPToken 重新生成MCP访问令牌
+func (c *UserConfig) ResetMCPToken() (string, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	c.MCP.Token = newSessionID() + newSessionID()
+	return c.MCP.Token, c.saveToFile()
+}
+
+// SaveMCPNetConfig 保存MCP监听地址与允许的跨域来源（重启MCP后生效）
+func (c *UserConfig) SaveMCPNetConfig(bind string, origins []string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	c.MCP.BindAddress = strings.TrimSpace(bind)
+	c.MCP.AllowedOrigins = make([]string, 0, len(origins))
+	for _, o := range origins {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			c.MCP.AllowedOrigins = append(c.MCP.AllowedOrigins, o)
+		}
+	}
+	if c.MCP.BindAddress == "" {
+		c.MCP.BindAddress = "127.0.0.1"
+	}
+	return c.saveToFile()
+}
+
+// SaveMCPToolPolicy 保存MCP工具策略（只读模式与单个工具的 allow/deny/confirm）
+func (c *UserConfig) SaveMCPToolPolicy(readOnly bool, policy map[string]string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	c.MCP.ReadOnly = readOnly
+	c.MCP.ToolPolicy = make(map[string]string)
+	for name, p := range policy {
+		switch p {
+		case "allow", "deny", "confirm":
+			c.MCP.ToolPolicy[name] = p
+		}
+	}
+	return c.saveToFile()
+}
+
+// SaveCaptureLimits 保存抓包存储上限并立即应用
+func (c *UserConfig) SaveCaptureLimits(l MapHash.Limits) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	c.CaptureLimits = l
+	HashMap.SetLimits(l)
+	return c.saveToFile()
+}
+
 var configLock sync.Mutex
 var GlobalConfig *UserConfig
 
@@ -25,9 +25,11 @@ func CreateRequestCode(TheologyArray []int, Lang string, module string) string {
 		if h != nil {
 			u, e := url.Parse(h.URL)
 			if e != nil {
+				HashMap.Release(h)
 				continue
 			}
 			mm := &CreateRequest{URL: u, Header: h.Header, Body: h.Body, Method: h.Method}
+			HashMap.Release(h)
 			index++
 			mm.FuncName = "SunnyNetCreateRequest" + strconv.Itoa(index)
 			mm.Cookie = mm.Header.Get("Cookie")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"changeme/MapHash"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"github.com/qtgolang/SunnyNet/src/encoding/hex"
@@ -21,6 +22,24 @@ type FindValue struct {
 	SearchResult  map[int]bool
 	CaseSensitive bool //是否区分大小写
 	PbSkip        int
+	OnProgress    func(percentage int) //搜索进度回调（MCP 使用）
+	OnError       func(msg string)     //错误回调，为空时弹出界面提示
+	Private       bool                 //只返回结果，不修改界面的搜索标记与进度（MCP 使用）
+	Cancelled     bool                 //搜索被取消（结果不完整）
+	ctx           context.Context
+}
+
+// 正在进行的搜索，CancelSearch 会全部取消
+var runningSearch = make(map[int]context.CancelFunc)
+var runningSearchID int
+
+// fail 报告搜索错误
+func (c *FindValue) fail(msg string) {
+	if c.OnError != nil {
+		c.OnError(msg)
+		return
+	}
+	CallJs("弹出错误提示", msg)
 }
 
 func (c *FindValue) getValue() string {
@@ -37,15 +56,23 @@ func (c *FindValue) getValue() string {
 	return strings.ToLower(v)
 }
 func (c *FindValue) Find() any {
+	return c.FindContext(context.Background())
+}
+
+// FindContext 与 Find 相同，ctx 取消或调用 CancelSearch 后停止搜索并返回已找到的结果
+func (c *FindValue) FindContext(ctx context.Context) any {
+	c.ctx = ctx
 	c.CaseSensitive = !strings.Contains(c.Options, "不区分大小写")
-	defer func() {
-		Insert.Lock()
-		SearchPercentage = -1
-		Insert.Unlock()
-	}()
+	if !c.Private {
+		defer func() {
+			Insert.Lock()
+			SearchPercentage = -1
+			Insert.Unlock()
+		}()
+	}
 	c.SearchResult = make(map[int]bool)
 	if c.Value == "" {
-		CallJs("弹出错误提示", "查找失败：请输入要搜索的内容")
+		c.fail("查找失败：请输入要搜索的内容")
 		return nil
 	}
 	switch c.Type {
@@ -88,7 +115,7 @@ func (c *FindValue) FindGBK() any {
 func (c *FindValue) FindHex() any {
 	bs, e := hex.DecodeString(c.getValue())
 	if e != nil {
-		CallJs("弹出错误提示", "查找失败：输入的 HEX 不正确,请检查！！")
+		c.fail("查找失败：输入的 HEX 不正确,请检查！！")
 		return nil
 	}
 	c.Bytes = bs
@@ -98,7 +125,7 @@ func (c *FindValue) FindBase64() any {
 	c.CaseSensitive = false
 	b, e := base64.StdEncoding.DecodeString(c.getValue())
 	if e != nil {
-		CallJs("弹出错误提示", "查找失败：输入的 Base64 不正确,请检查！！")
+		c.fail("查找失败：输入的 Base64 不正确,请检查！！")
 		return nil
 	}
 	c.Bytes = b
@@ -111,7 +138,7 @@ func (c *FindValue) FindProtoBuf() any {
 func (c *FindValue) FindInt32() any {
 	num, e := strconv.Atoi(c.getValue())
 	if e != nil {
-		CallJs("弹出错误提示", "查找失败：输入的数值不正确,或类型选择错误")
+		c.fail("查找失败：输入的数值不正确,或类型选择错误")
 		return nil
 	}
 	bs := make([]byte, 4)
@@ -125,7 +152,7 @@ func (c *FindValue) FindInt32() any {
 func (c *FindValue) FindInt64() any {
 	num, e := strconv.Atoi(c.getValue())
 	if e != nil {
-		CallJs("弹出错误提示", "查找失败：输入的数值不正确,或查找类型选择错误")
+		c.fail("查找失败：输入的数值不正确,或查找类型选择错误")
 		return nil
 	}
 	bs := make([]byte, 8)
@@ -140,7 +167,7 @@ func (c *FindValue) FindFloat32() any {
 	// 将字符串转换为float32
 	f32, err := strconv.ParseFloat(c.getValue(), 32)
 	if err != nil {
-		CallJs("弹出错误提示", "查找失败：输入的数值不正确,或查找类型选择错误")
+		c.fail("查找失败：输入的数值不正确,或查找类型选择错误")
 		return nil
 	}
 	bytes32 := make([]byte, 4)
@@ -154,7 +181,7 @@ func (c *FindValue) FindFloat64() any {
 	// 将字符串转换为 float64
 	f64, err := strconv.ParseFloat(c.getValue(), 64)
 	if err != nil {
-		CallJs("弹出错误提示", "查找失败：输入的数值不正确,或查找类型选择错误")
+		c.fail("查找失败：输入的数值不正确,或查找类型选择错误")
 		return nil
 	}
 	bytes32 := make([]byte, 4)
@@ -167,12 +194,17 @@ func (c *FindValue) FindFloat64() any {
 
 func CancelSearch() []int {
 	Insert.Lock()
+	for id, cancel := range runningSearch {
+		cancel()
+		delete(runningSearch, id)
+	}
 	i := LastSearch
 	for _, v := range LastSearch {
 		h := HashMap.GetRequest(v)
 		if h != nil {
 			h.Color.Search = ""
 		}
+		HashMap.Release(h)
 	}
 	LastSearch = make([]int, 0)
 	for n := 0; n < len(LastSearchSocket); n++ {
@@ -184,7 +216,7 @@ func CancelSearch() []int {
 }
 
 func (c *FindValue) FindStart() any {
-	if strings.Contains(c.Options, "取消之前的颜色标记") {
+	if !c.Private && strings.Contains(c.Options, "取消之前的颜色标记") {
 		Insert.Lock()
 		for i := 0; i < len(LastSearchSocket); i++ {
 			LastSearchSocket[i].Color = ""
@@ -192,8 +224,20 @@ func (c *FindValue) FindStart() any {
 		LastSearchSocket = make([]*MapHash.UpdateSocketList, 0)
 		Insert.Unlock()
 	}
-	HashMap.Search(c.Search)
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	Insert.Lock()
+	runningSearchID++
+	id := runningSearchID
+	runningSearch[id] = cancel
+	Insert.Unlock()
+	c.Cancelled = HashMap.SearchContext(ctx, c.Search) != nil
 	Insert.Lock()
+	delete(runningSearch, id)
+	cancel()
 	var _SearchResult []int
 	for o, v := range c.SearchResult {
 		if v {
@@ -201,12 +245,18 @@ func (c *FindValue) FindStart() any {
 		}
 	}
 	f := &SearchResult{SearchResult: _SearchResult, Color: c.Color}
+	//Private 搜索的结果只返回给调用方，不计入界面的 LastSearch
+	if c.Private {
+		Insert.Unlock()
+		return f
+	}
 	if strings.Contains(c.Options, "取消之前的颜色标记") {
 		for _, v := range LastSearch {
 			h := HashMap.GetRequest(v)
 			if h != nil {
 				h.Color.Search = ""
 			}
+			HashMap.Release(h)
 		}
 		f.LastSearchResult = LastSearch
 		LastSearch = _SearchResult
@@ -248,10 +298,29 @@ func (c *FindValue) caseSensitiveSearch(Theology int, s string) bool {
 	return false
 }
 
+// markFound 在界面中标记找到的会话与Socket数据，Private 搜索不标记
+func (c *FindValue) markFound(request *MapHash.Request, data *MapHash.UpdateSocketData) {
+	if c.Private {
+		return
+	}
+	request.Color.Search = c.Color
+	if data != nil {
+		data.Info.Color = c.Color
+		Insert.Lock()
+		LastSearchSocket = append(LastSearchSocket, data.Info)
+		Insert.Unlock()
+	}
+}
+
 func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
-	Insert.Lock()
-	SearchPercentage = percentage
-	Insert.Unlock()
+	if !c.Private {
+		Insert.Lock()
+		SearchPercentage = percentage
+		Insert.Unlock()
+	}
+	if c.OnProgress != nil {
+		c.OnProgress(percentage)
+	}
 	if request == nil {
 		return
 	}
@@ -263,7 +332,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 			//在请求Body中搜索
 			{
 				if c.caseSensitiveSearch(Theology, _PbToJson(request.Body, c.PbSkip)) {
-					request.Color.Search = c.Color
+					c.markFound(request, nil)
 					return
 				}
 			}
@@ -272,7 +341,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 			//在请求响应Body中搜索
 			{
 				if c.caseSensitiveSearch(Theology, _PbToJson(request.Response.Body, c.PbSkip)) {
-					request.Color.Search = c.Color
+					c.markFound(request, nil)
 					return
 				}
 			}
@@ -291,11 +360,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 					if v != nil {
 						if strings.Contains(sv, v.Info.Ico) {
 							if c.caseSensitiveSearch(Theology, _PbToJson(v.Body, c.PbSkip)) {
-								v.Info.Color = c.Color
-								request.Color.Search = c.Color
-								Insert.Lock()
-								LastSearchSocket = append(LastSearchSocket, v.Info)
-								Insert.Unlock()
+								c.markFound(request, v)
 							}
 						}
 					}
@@ -310,7 +375,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 		//在URL中搜索
 		{
 			if c.caseSensitiveSearch(Theology, request.URL) {
-				request.Color.Search = c.Color
+				c.markFound(request, nil)
 				return
 			}
 		}
@@ -328,7 +393,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 					}
 				}
 				if c.caseSensitiveSearch(Theology, _t) {
-					request.Color.Search = c.Color
+					c.markFound(request, nil)
 					return
 				}
 			}
@@ -336,7 +401,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 		//在请求Body中搜索
 		{
 			if c.caseSensitiveSearch(Theology, string(request.Body)) {
-				request.Color.Search = c.Color
+				c.markFound(request, nil)
 				return
 			}
 		}
@@ -356,7 +421,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 					}
 				}
 				if c.caseSensitiveSearch(Theology, _t) {
-					request.Color.Search = c.Color
+					c.markFound(request, nil)
 					return
 				}
 			}
@@ -364,7 +429,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 		//在请求响应Body中搜索
 		{
 			if c.caseSensitiveSearch(Theology, string(request.Response.Body)) {
-				request.Color.Search = c.Color
+				c.markFound(request, nil)
 				return
 			}
 		}
@@ -383,11 +448,7 @@ func (c *FindValue) Search(Theology, percentage int, request *MapHash.Request) {
 				if v != nil {
 					if strings.Contains(sv, v.Info.Ico) {
 						if c.caseSensitiveSearch(Theology, string(v.Body)) {
-							v.Info.Color = c.Color
-							request.Color.Search = c.Color
-							Insert.Lock()
-							LastSearchSocket = append(LastSearchSocket, v.Info)
-							Insert.Unlock()
+							c.markFound(request, v)
 						}
 					}
 				}
@@ -15,6 +15,28 @@ type HostsRules struct {
 
 var _HostsRules []HostsRules
 
+// hostsRulePattern 把界面中输入的源地址（* 为通配符）转换为正则表达式
+func hostsRulePattern(source string) string {
+	source = strings.ReplaceAll(source, ".*", "{点星}")
+	source = strings.ReplaceAll(source, "*", ".*")
+	return strings.ReplaceAll(source, "{点星}", ".*")
+}
+
+// buildHostsRules 把配置中的HOSTS规则（源地址已是正则表达式）转换为运行时规则，返回无效规则的Hash
+func buildHostsRules(list []ConfigReplaceRules) ([]HostsRules, []string) {
+	var _Rules []HostsRules
+	var failHash []string
+	for _, v := range list {
+		regex, e := regexp.Compile(v.Src)
+		if v.Src == "" || v.Dest == "" || e != nil {
+			failHash = append(failHash, v.Hash)
+			continue
+		}
+		_Rules = append(_Rules, HostsRules{regex: regex, target: v.Dest})
+	}
+	return _Rules, failHash
+}
+
 func HostsRulesEvent(command string, args *JSON.SyJson) any {
 	switch command {
 	case "保存HOSTS规则":
@@ -29,9 +51,7 @@ func HostsRulesEvent(command string, args *JSON.SyJson) any {
 			_target := args.GetData("Data[" + strconv.Itoa(i) + "].新地址")
 			_source = strings.ReplaceAll(_source, "\\\\", "\\")
 			_target = strings.ReplaceAll(_target, "\\\\", "\\")
-			_source = strings.ReplaceAll(_source, ".*", "{点星}")
-			_source = strings.ReplaceAll(_source, "*", ".*")
-			_source = strings.ReplaceAll(_source, "{点星}", ".*")
+			_source = hostsRulePattern(_source)
 			regex, e := regexp.Compile(_source)
 			if _source == "" || _target == "" || e != nil {
 				failHash = append(failHash, _Hash)
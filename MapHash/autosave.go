@@ -0,0 +1,193 @@
+package MapHash
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// 自动保存：定时把新增或有变化的会话追加到日志文件（记录文件格式），被删除的会话追加 Deleted 记录，
+// 崩溃或断电后重启时从日志恢复。日志中的过期记录过多时整体重写。
+
+// 日志记录数超过该值且超过当前会话数的 4 倍时重写日志
+const autosaveCompactRecords = 1000
+
+type autosaveState struct {
+	path    string
+	w       *CaptureWriter
+	saved   map[int]string //已写入日志的会话及写入时的签名
+	gen     int            //Map.gen，清空列表后重新开始日志
+	records int
+}
+
+// signature 会话的变化签名，数据体在磁盘上时不加载
+func (r *Request) signature() string {
+	req := len(r.Body)
+	if r.spill != nil && r.spill.onDisk && req == 0 && r.spill.req != nil {
+		req = r.spill.req.Len
+	}
+	return r.SendTime + "|" + r.RecTime + "|" + strconv.Itoa(req) + "|" + strconv.Itoa(r.ResponseBodyLen()) + "|" +
+		strconv.Itoa(r.Response.StateCode) + "|" + strconv.Itoa(len(r.SocketData)) + "|" +
+		strconv.Itoa(r.SendNum) + "|" + strconv.Itoa(r.RecNum) + "|" + r.Notes + "|" + r.Color.TagColor + "|" +
+		strconv.FormatBool(r.Pinned) + "|" + strconv.FormatBool(r.BodiesEvicted) + "|" + strconv.Itoa(len(r.Header))
+}
+
+// StartAutosave 开始自动保存到日志文件；restore 为 true 时先把日志中的会话恢复到 Map，返回恢复的会话
+func (m *Map) StartAutosave(Path string, restore bool) ([]int, []*Request, error) {
+	m.autosaveLock.Lock()
+	defer m.autosaveLock.Unlock()
+	if m.autosave != nil {
+		_ = m.autosave.w.Close()
+		m.autosave = nil
+	}
+	var keys []int
+	var list []*Request
+	if restore {
+		if st, err := os.Stat(Path); err == nil && st.Size() > 0 {
+			var err error
+			if keys, list, err = m.LoadFile(Path); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	a := &autosaveState{path: Path}
+	if err := m.rewriteAutosave(a); err != nil {
+		return keys, list, err
+	}
+	m.autosave = a
+	return keys, list, nil
+}
+
+// StopAutosave 写入最后的变化并关闭日志
+func (m *Map) StopAutosave() error {
+	err := m.Autosave()
+	m.autosaveLock.Lock()
+	defer m.autosaveLock.Unlock()
+	if m.autosave != nil {
+		if e := m.autosave.w.Close(); err == nil {
+			err = e
+		}
+		m.autosave = nil
+	}
+	return err
+}
+
+// AutosavedFlows 日志中的会话数，未开启自动保存时返回 -1
+func (m *Map) AutosavedFlows() int {
+	m.autosaveLock.Lock()
+	defer m.autosaveLock.Unlock()
+	if m.autosave == nil {
+		return -1
+	}
+	return len(m.autosave.saved)
+}
+
+// rewriteAutosave 用当前的全部会话重写日志，完成后替换原文件
+func (m *Map) rewriteAutosave(a *autosaveState) error {
+	if a.w != nil {
+		_ = a.w.Close()
+		a.w = nil
+	}
+	m.lock.Lock()
+	keys, _ := m.pick(true, nil)
+	a.gen = m.gen
+	m.lock.Unlock()
+	tmp := a.path + ".tmp"
+	w, err := CreateCaptureFile(tmp)
+	if err != nil {
+		return err
+	}
+	a.saved = make(map[int]string)
+	a.records = 0
+	for _, k := range keys {
+		if err = m.autosaveFlow(a, w, k); err != nil {
+			break
+		}
+	}
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, a.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	a.w, err = AppendCaptureFile(a.path)
+	return err
+}
+
+// autosaveFlow 写入单个会话并记录签名
+func (m *Map) autosaveFlow(a *autosaveState, w *CaptureWriter, k int) error {
+	m.lock.Lock()
+	h := m.Request[k]
+	if h == nil {
+		m.lock.Unlock()
+		return nil
+	}
+	sig := h.signature()
+	bs, err := m.captureRecordOf(k, h)
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = w.writeRecord(bs); err != nil {
+		return err
+	}
+	a.saved[k] = sig
+	a.records++
+	return nil
+}
+
+// Autosave 把上次保存后新增或有变化的会话追加到日志，由调用方定时执行
+func (m *Map) Autosave() error {
+	m.autosaveLock.Lock()
+	defer m.autosaveLock.Unlock()
+	a := m.autosave
+	if a == nil {
+		return nil
+	}
+	var changed, deleted []int
+	m.lock.Lock()
+	if a.gen != m.gen {
+		m.lock.Unlock()
+		return m.rewriteAutosave(a)
+	}
+	for k, h := range m.Request {
+		if h != nil && h.Display && a.saved[k] != h.signature() {
+			changed = append(changed, k)
+		}
+	}
+	for k := range a.saved {
+		if h := m.Request[k]; h == nil || !h.Display {
+			deleted = append(deleted, k)
+		}
+	}
+	m.lock.Unlock()
+	if len(changed) == 0 && len(deleted) == 0 {
+		return nil
+	}
+	sort.Ints(changed)
+	for _, k := range deleted {
+		bs, _ := json.Marshal(&captureRecord{Theology: k, Deleted: true})
+		if err := a.w.writeRecord(bs); err != nil {
+			return err
+		}
+		delete(a.saved, k)
+		a.records++
+	}
+	for _, k := range changed {
+		if err := m.autosaveFlow(a, a.w, k); err != nil {
+			return err
+		}
+	}
+	if err := a.w.Sync(); err != nil {
+		return err
+	}
+	if a.records > autosaveCompactRecords && a.records > 4*len(a.saved) {
+		return m.rewriteAutosave(a)
+	}
+	return nil
+}
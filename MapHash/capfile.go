@@ -0,0 +1,406 @@
+package MapHash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 记录文件（.syn）格式，版本 2：
+//
+//	文件头  "SUNNYSYN" | 版本 uint16 | 长度 uint32 | JSON（captureHeader）
+//	记录    长度 uint32 | CRC32 uint32 | brotli 压缩的 JSON（captureRecord）
+//
+// 整数均为大端序。每个会话单独压缩为一条记录，写入时逐个会话序列化，可以直接追加到已有文件末尾；
+// 同一 theology 以最后一条记录为准，Deleted 记录删除之前的会话（自动保存的日志文件依赖这一点）。
+// 写入中断留下的不完整记录在读取时丢弃，追加前截掉。
+// 版本 1 为整个文件 brotli 压缩的 []*Request JSON 数组，仍可读取。
+
+const (
+	captureFileMagic = "SUNNYSYN"
+	// CaptureFileVersion 当前写入的记录文件版本
+	CaptureFileVersion = 2
+
+	captureMaxRecord = 1 << 30 //单条记录的长度上限，超过视为文件损坏
+)
+
+// ErrLegacyCaptureFile 旧版本的记录文件不支持追加
+var ErrLegacyCaptureFile = errors.New("旧版本的记录文件不支持追加，请另存为新文件")
+
+type captureHeader struct {
+	Version int       `json:"version"`
+	App     string    `json:"app"`
+	Created time.Time `json:"created"`
+}
+
+type captureRecord struct {
+	Theology int      `json:"t"`
+	Created  int64    `json:"c,omitempty"` //会话进入存储的时间（Unix 毫秒）
+	Deleted  bool     `json:"d,omitempty"`
+	Request  *Request `json:"r,omitempty"`
+}
+
+// CaptureWriter 逐条写入记录文件
+type CaptureWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// CreateCaptureFile 创建（或清空）记录文件并写入文件头
+func CreateCaptureFile(Path string) (*CaptureWriter, error) {
+	f, err := os.OpenFile(Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &CaptureWriter{f: f, w: bufio.NewWriterSize(f, 256<<10)}
+	if err = w.writeHeader(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// AppendCaptureFile 打开记录文件用于追加；文件不存在或为空时创建，末尾不完整的记录被截掉
+func AppendCaptureFile(Path string) (*CaptureWriter, error) {
+	f, err := os.OpenFile(Path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &CaptureWriter{f: f, w: bufio.NewWriterSize(f, 256<<10)}
+	st, err := f.Stat()
+	if err == nil && st.Size() == 0 {
+		err = w.writeHeader()
+	} else if err == nil {
+		var end int64
+		end, err = validCaptureEnd(f)
+		if err == nil {
+			err = f.Truncate(end)
+		}
+		if err == nil {
+			_, err = f.Seek(end, io.SeekStart)
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *CaptureWriter) writeHeader() error {
+	bs, err := json.Marshal(&captureHeader{Version: CaptureFileVersion, App: "SunnyNet", Created: time.Now()})
+	if err != nil {
+		return err
+	}
+	var hdr [14]byte
+	copy(hdr[:], captureFileMagic)
+	binary.BigEndian.PutUint16(hdr[8:], CaptureFileVersion)
+	binary.BigEndian.PutUint32(hdr[10:], uint32(len(bs)))
+	if _, err = w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.w.Write(bs)
+	return err
+}
+
+// writeRecord 写入已序列化的记录
+func (w *CaptureWriter) writeRecord(js []byte) error {
+	data := BrCompress(js)
+	if len(data) < 1 {
+		return errors.New("数据压缩失败")
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[4:], crc32.ChecksumIEEE(data))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// Sync 把缓冲的记录写入磁盘
+func (w *CaptureWriter) Sync() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Close 写入剩余数据并关闭文件
+func (w *CaptureWriter) Close() error {
+	err := w.Sync()
+	if e := w.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// readCaptureHeader 读取文件头，不是版本 2 及以上的文件返回 ok=false
+func readCaptureHeader(r io.Reader) (hdr *captureHeader, ok bool, err error) {
+	var b [14]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil || string(b[:8]) != captureFileMagic {
+		return nil, false, nil
+	}
+	hdr = &captureHeader{Version: int(binary.BigEndian.Uint16(b[8:]))}
+	if hdr.Version > CaptureFileVersion {
+		return nil, true, fmt.Errorf("记录文件版本 %d 高于当前支持的版本 %d，请升级SunnyNet", hdr.Version, CaptureFileVersion)
+	}
+	n := binary.BigEndian.Uint32(b[10:])
+	if n > captureMaxRecord {
+		return nil, true, errors.New("记录文件头损坏")
+	}
+	js := make([]byte, n)
+	if _, err = io.ReadFull(r, js); err != nil {
+		return nil, true, errors.New("记录文件头不完整")
+	}
+	_ = json.Unmarshal(js, hdr)
+	return hdr, true, nil
+}
+
+// nextCaptureRecord 读取下一条记录的压缩数据，文件结束或记录不完整、损坏时返回 io.EOF
+func nextCaptureRecord(r io.Reader) ([]byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, io.EOF
+	}
+	n := binary.BigEndian.Uint32(hdr[0:])
+	if n == 0 || n > captureMaxRecord {
+		return nil, io.EOF
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.EOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:]) {
+		return nil, io.EOF
+	}
+	return data, nil
+}
+
+// validCaptureEnd 返回最后一条完整记录之后的位置
+func validCaptureEnd(f *os.File) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	cr := &countingReader{r: bufio.NewReaderSize(f, 256<<10)}
+	_, ok, err := readCaptureHeader(cr)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrLegacyCaptureFile
+	}
+	end := cr.n
+	for {
+		if _, err = nextCaptureRecord(cr); err != nil {
+			return end, nil
+		}
+		end = cr.n
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readCaptureFile 逐条读取记录文件（同时支持版本 1），fn 按文件中的顺序收到每条记录
+// 版本 1 的文件没有 theology，按顺序编号
+func readCaptureFile(Path string, fn func(rec *captureRecord)) error {
+	f, err := os.Open(Path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	br := bufio.NewReaderSize(f, 256<<10)
+	_, ok, err := readCaptureHeader(br)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		//版本 1：整个文件一次解压
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		bs, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		var list []*Request
+		if err = json.Unmarshal(BrUnCompress(bs), &list); err != nil {
+			return fmt.Errorf("解密文件失败: %v", err)
+		}
+		for i, r := range list {
+			if r != nil {
+				fn(&captureRecord{Theology: i + 1, Request: r})
+			}
+		}
+		return nil
+	}
+	for {
+		data, err := nextCaptureRecord(br)
+		if err != nil {
+			return nil
+		}
+		rec := &captureRecord{}
+		if e := json.Unmarshal(BrUnCompress(data), rec); e != nil {
+			return nil
+		}
+		fn(rec)
+	}
+}
+
+// captureRecordOf 序列化会话为一条记录，调用前需持有 m.lock
+func (m *Map) captureRecordOf(k int, h *Request) ([]byte, error) {
+	onDisk := h.spill != nil && h.spill.onDisk
+	m.load(h)
+	rec := &captureRecord{Theology: k, Request: h}
+	if !h.created.IsZero() {
+		rec.Created = h.created.UnixMilli()
+	}
+	bs, err := json.Marshal(rec)
+	if onDisk {
+		m.unload(k, h, false)
+	}
+	return bs, err
+}
+
+// writeFlows 逐个会话序列化并写入，只在序列化单个会话时持有锁，返回写入的会话数
+func (m *Map) writeFlows(w *CaptureWriter, keys []int, SetStatusText func(string)) (int, error) {
+	n := 0
+	for i, k := range keys {
+		m.lock.Lock()
+		h := m.Request[k]
+		if h == nil {
+			m.lock.Unlock()
+			continue
+		}
+		bs, err := m.captureRecordOf(k, h)
+		m.lock.Unlock()
+		if err != nil {
+			return n, err
+		}
+		if err = w.writeRecord(bs); err != nil {
+			return n, err
+		}
+		n++
+		if SetStatusText != nil && (i+1)%500 == 0 {
+			SetStatusText("正在写入记录:" + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(keys)))
+		}
+	}
+	return n, nil
+}
+
+// SaveToFile 把选中的会话保存为记录文件。先写入临时文件，完成后替换目标文件
+func (m *Map) SaveToFile(Path string, All bool, TheologyArray []int, SetStatusText func(string)) bool {
+	SetStatusText("正在统计需要储存的信息")
+	m.lock.Lock()
+	keys, _ := m.pick(All, TheologyArray)
+	m.lock.Unlock()
+	if len(keys) < 1 {
+		SetStatusText("需要储存的数量小于1")
+		return false
+	}
+	tmp := Path + ".tmp"
+	w, err := CreateCaptureFile(tmp)
+	if err != nil {
+		SetStatusText("保存记录文件失败：" + err.Error())
+		return false
+	}
+	SetStatusText("有 " + strconv.Itoa(len(keys)) + " 条数据正在储存...")
+	n, err := m.writeFlows(w, keys, SetStatusText)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, Path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		SetStatusText("保存记录文件失败：" + err.Error())
+		return false
+	}
+	SetStatusText("保存记录文件成功：" + Path + "（" + strconv.Itoa(n) + " 条）")
+	return true
+}
+
+// AppendToFile 把选中的会话追加到已有的记录文件末尾，文件不存在时创建
+func (m *Map) AppendToFile(Path string, All bool, TheologyArray []int, SetStatusText func(string)) bool {
+	m.lock.Lock()
+	keys, _ := m.pick(All, TheologyArray)
+	m.lock.Unlock()
+	if len(keys) < 1 {
+		SetStatusText("需要储存的数量小于1")
+		return false
+	}
+	w, err := AppendCaptureFile(Path)
+	if err != nil {
+		SetStatusText("追加记录文件失败：" + err.Error())
+		return false
+	}
+	n, err := m.writeFlows(w, keys, SetStatusText)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		SetStatusText("追加记录文件失败：" + err.Error())
+		return false
+	}
+	SetStatusText("已追加 " + strconv.Itoa(n) + " 条记录到：" + Path)
+	return true
+}
+
+// LoadFile 读取记录文件并把其中的会话加入 Map（分配新的 theology），返回新会话的 theology 与会话
+func (m *Map) LoadFile(Path string) ([]int, []*Request, error) {
+	var order []int
+	records := make(map[int]*captureRecord)
+	err := readCaptureFile(Path, func(rec *captureRecord) {
+		if rec.Deleted || rec.Request == nil {
+			delete(records, rec.Theology)
+			return
+		}
+		if _, ok := records[rec.Theology]; !ok {
+			order = append(order, rec.Theology)
+		}
+		records[rec.Theology] = rec
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]int, 0, len(records))
+	list := make([]*Request, 0, len(records))
+	for _, old := range order {
+		rec := records[old]
+		if rec == nil {
+			continue
+		}
+		//同一 theology 删除后又写入时只保留一次
+		delete(records, old)
+		r := rec.Request
+		r.restoreCreated(rec.Created)
+		Theology := m.CreateUniqueID()
+		for _, d := range r.SocketData {
+			if d != nil && d.Info != nil {
+				d.Info.Theology = Theology
+			}
+		}
+		m.SetRequest(Theology, r)
+		keys = append(keys, Theology)
+		list = append(list, r)
+	}
+	return keys, list, nil
+}
@@ -0,0 +1,581 @@
+package MapHash
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 导出与导入：http://www.softwareishard.com/blog/har-12-spec/
+// Websocket 会话的消息按 Chrome 开发者工具的扩展字段 _webSocketMessages 导出，TCP/UDP 会话不导出。
+
+const harTimeLayout = "15:04:05.000"
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Pages   []any       `json:"pages,omitempty"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string                 `json:"startedDateTime"`
+	Time            float64                `json:"time"` //毫秒
+	Request         HARRequest             `json:"request"`
+	Response        HARResponse            `json:"response"`
+	Cache           struct{}               `json:"cache"`
+	Timings         HARTimings             `json:"timings"`
+	ServerIPAddress string                 `json:"serverIPAddress,omitempty"`
+	Comment         string                 `json:"comment,omitempty"`
+	WebSocket       []*HARWebSocketMessage `json:"_webSocketMessages,omitempty"`
+	Process         string                 `json:"_process,omitempty"`
+	ClientIP        string                 `json:"_clientIP,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Error       bool           `json:"_error,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARPostParam `json:"params"`
+	Text     string         `json:"text"`
+	Encoding string         `json:"_encoding,omitempty"` //非UTF-8请求体以 base64 保存
+}
+
+type HARPostParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings 各阶段耗时（毫秒），-1 表示不适用
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type HARWebSocketMessage struct {
+	Type   string  `json:"type"` //send/receive
+	Time   float64 `json:"time"` //Unix 秒
+	Opcode int     `json:"opcode"`
+	Data   string  `json:"data"`
+}
+
+// ExportHAR 把选中的 HTTP 与 Websocket 会话转换为 HAR，返回 HAR 与导出的会话数
+func (m *Map) ExportHAR(All bool, TheologyArray []int, CreatorVersion string) (*HAR, int) {
+	h, n, _ := m.ExportHARContext(context.Background(), All, TheologyArray, CreatorVersion, nil)
+	return h, n
+}
+
+// ExportHARContext 同 ExportHAR，每转换一个会话调用一次 onProgress（持有抓包锁），ctx 取消后立即停止并返回 ctx.Err()
+func (m *Map) ExportHARContext(ctx context.Context, All bool, TheologyArray []int, CreatorVersion string, onProgress func(done, total int)) (*HAR, int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, list := m.pick(All, TheologyArray)
+	defer m.loadBodies(list)()
+	h := &HAR{Log: HARLog{Version: "1.2", Creator: HARCreator{Name: "SunnyNet", Version: CreatorVersion}, Entries: make([]*HAREntry, 0, len(list))}}
+	for i, v := range list {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		if e := v.harEntry(); e != nil {
+			h.Log.Entries = append(h.Log.Entries, e)
+		}
+		if onProgress != nil {
+			onProgress(i+1, len(list))
+		}
+	}
+	return h, len(h.Log.Entries), nil
+}
+
+// harEntry 单个会话的 HAR 记录，非 HTTP/Websocket 会话返回 nil
+func (r *Request) harEntry() *HAREntry {
+	u, err := url.Parse(r.URL)
+	if err != nil || (r.Way != "HTTP" && r.Way != "Websocket") {
+		return nil
+	}
+	start, total := r.flowTimes()
+	proto := r.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	e := &HAREntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            ms(total),
+		Comment:         r.Notes,
+		Process:         r.PID,
+		ClientIP:        r.ClientIP,
+		Timings:         HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: ms(total)},
+	}
+	if r.Timing != nil && r.Timing.Total() >= 0 {
+		start = r.Timing.Start
+		e.StartedDateTime = start.Format(time.RFC3339Nano)
+		e.Time = ms(r.Timing.Total())
+		e.Timings = r.Timing.harTimings()
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		e.ServerIPAddress = ip.String()
+	}
+	e.Request = HARRequest{
+		Method:      r.Method,
+		URL:         r.URL,
+		HTTPVersion: proto,
+		Cookies:     harCookies((&http.Request{Header: r.Header}).Cookies()),
+		Headers:     harHeaders(r.Header),
+		QueryString: harQuery(u.Query()),
+		HeadersSize: -1,
+		BodySize:    len(r.Body),
+	}
+	if len(r.Body) > 0 {
+		e.Request.PostData = harPostData(r.Header.Get("Content-Type"), r.Body)
+	}
+	mimeType := r.Response.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "x-unknown"
+	}
+	e.Response = HARResponse{
+		Status:      r.Response.StateCode,
+		StatusText:  http.StatusText(r.Response.StateCode),
+		HTTPVersion: proto,
+		Cookies:     harCookies((&http.Response{Header: r.Response.Header}).Cookies()),
+		Headers:     harHeaders(r.Response.Header),
+		Content:     HARContent{Size: len(r.Response.Body), MimeType: mimeType},
+		RedirectURL: r.Response.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(r.Response.Body),
+		Error:       r.Response.Error,
+	}
+	if len(r.Response.Body) > 0 {
+		e.Response.Content.Text, e.Response.Content.Encoding = harText(r.Response.Body)
+	}
+	if r.Way == "Websocket" {
+		e.WebSocket = r.harWebSocketMessages(start)
+	}
+	return e
+}
+
+// flowTimes 由会话进入存储的日期与列表中的请求、响应时间推算开始时间和耗时
+func (r *Request) flowTimes() (time.Time, time.Duration) {
+	day := r.created
+	if day.IsZero() {
+		day = time.Now()
+	}
+	start, ok := clockOn(day, r.SendTime)
+	if !ok {
+		return day, 0
+	}
+	end, ok := clockOn(day, r.RecTime)
+	if !ok {
+		return start, 0
+	}
+	if end.Before(start) {
+		//跨过零点
+		end = end.Add(24 * time.Hour)
+	}
+	return start, end.Sub(start)
+}
+
+// clockOn 把 "15:04:05.000" 格式的时间放到 day 所在的日期上
+func clockOn(day time.Time, clock string) (time.Time, bool) {
+	t, err := time.ParseInLocation(harTimeLayout, clock, day.Location())
+	if err != nil {
+		return day, false
+	}
+	y, mo, d := day.Date()
+	return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), day.Location()), true
+}
+
+// socketTime Socket数据的时间，last 为上一段数据的时间，时间缺失或无效时沿用 last
+func socketTime(last time.Time, clock string) time.Time {
+	t, ok := clockOn(last, clock)
+	if !ok {
+		return last
+	}
+	switch {
+	case last.Sub(t) > 12*time.Hour:
+		//跨过零点
+		t = t.Add(24 * time.Hour)
+	case t.Before(last):
+		//收发线程记录的时间可能略有先后
+		t = last
+	}
+	return t
+}
+
+func (r *Request) harWebSocketMessages(start time.Time) []*HARWebSocketMessage {
+	list := make([]*HARWebSocketMessage, 0, len(r.SocketData))
+	last := start
+	for _, d := range r.SocketData {
+		if d == nil || d.Info == nil || d.Info.Index < 0 {
+			continue
+		}
+		msg := &HARWebSocketMessage{Type: "send", Opcode: wsOpcode(d.Info.WsType)}
+		if d.Info.Ico == "下行" {
+			msg.Type = "receive"
+		}
+		last = socketTime(last, d.Info.Time)
+		msg.Time = float64(last.UnixNano()) / float64(time.Second)
+		if msg.Opcode == 1 {
+			msg.Data = string(d.Body)
+		} else {
+			msg.Data = base64.StdEncoding.EncodeToString(d.Body)
+		}
+		list = append(list, msg)
+	}
+	return list
+}
+
+func wsOpcode(WsType string) int {
+	switch WsType {
+	case "Text":
+		return 1
+	case "Binary":
+		return 2
+	case "Close":
+		return 8
+	case "Ping":
+		return 9
+	case "Pong":
+		return 10
+	}
+	return 0
+}
+
+func wsTypeOf(opcode int) string {
+	switch opcode {
+	case 1:
+		return "Text"
+	case 2:
+		return "Binary"
+	case 8:
+		return "Close"
+	case 9:
+		return "Ping"
+	case 10:
+		return "Pong"
+	}
+	return "Invalid"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	list := make([]HARNameValue, 0, len(h))
+	for _, k := range sortedKeys(h) {
+		for _, v := range h[k] {
+			list = append(list, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return list
+}
+
+func harQuery(q url.Values) []HARNameValue {
+	list := make([]HARNameValue, 0, len(q))
+	for _, k := range sortedKeys(q) {
+		for _, v := range q[k] {
+			list = append(list, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	list := make([]HARCookie, 0, len(cookies))
+	for _, c := range cookies {
+		hc := HARCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			hc.Expires = c.Expires.Format(time.RFC3339)
+		}
+		list = append(list, hc)
+	}
+	return list
+}
+
+func harPostData(ContentType string, Body []byte) *HARPostData {
+	p := &HARPostData{MimeType: ContentType, Params: make([]HARPostParam, 0)}
+	p.Text, p.Encoding = harText(Body)
+	if mt, _, _ := mime.ParseMediaType(ContentType); mt == "application/x-www-form-urlencoded" && p.Encoding == "" {
+		if q, err := url.ParseQuery(p.Text); err == nil {
+			for _, k := range sortedKeys(q) {
+				for _, v := range q[k] {
+					p.Params = append(p.Params, HARPostParam{Name: k, Value: v})
+				}
+			}
+		}
+	}
+	return p
+}
+
+// harText 数据体为 UTF-8 文本时原样返回，否则返回 base64 编码
+func harText(Body []byte) (string, string) {
+	if utf8.Valid(Body) {
+		return string(Body), ""
+	}
+	return base64.StdEncoding.EncodeToString(Body), "base64"
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ImportHAR 解析 HAR 文件并把其中的会话加入 Map，返回新会话的 theology 与会话
+func (m *Map) ImportHAR(data []byte) ([]int, []*Request, error) {
+	list, err := ParseHAR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]int, 0, len(list))
+	for _, r := range list {
+		Theology := m.CreateUniqueID()
+		for _, d := range r.SocketData {
+			d.Info.Theology = Theology
+		}
+		m.SetRequest(Theology, r)
+		keys = append(keys, Theology)
+	}
+	return keys, list, nil
+}
+
+// ParseHAR 解析 HAR 文件，返回可直接放入 Map 的会话
+func ParseHAR(data []byte) ([]*Request, error) {
+	var h HAR
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	if h.Log.Entries == nil {
+		return nil, errors.New("不是有效的HAR文件：缺少 log.entries")
+	}
+	list := make([]*Request, 0, len(h.Log.Entries))
+	for i, e := range h.Log.Entries {
+		if e == nil {
+			continue
+		}
+		r, err := e.request()
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 条记录无效: %v", i+1, err)
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// request HAR 记录转换为会话
+func (e *HAREntry) request() (*Request, error) {
+	if _, err := url.Parse(e.Request.URL); err != nil || e.Request.URL == "" {
+		return nil, fmt.Errorf("请求地址无效: %q", e.Request.URL)
+	}
+	start, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+	if err != nil {
+		return nil, fmt.Errorf("startedDateTime 无效: %v", err)
+	}
+	start = start.Local()
+	r := &Request{
+		Method:   e.Request.Method,
+		URL:      e.Request.URL,
+		Proto:    e.Request.HTTPVersion,
+		Header:   headerOf(e.Request.Headers),
+		Display:  true,
+		Way:      "HTTP",
+		Notes:    e.Comment,
+		PID:      e.Process,
+		ClientIP: e.ClientIP,
+		SendTime: start.Format(harTimeLayout),
+		RecTime:  start.Add(time.Duration(e.Time * float64(time.Millisecond))).Format(harTimeLayout),
+		created:  start,
+		Timing:   timingOf(start, e.Timings),
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	if r.Proto == "" || !strings.HasPrefix(strings.ToUpper(r.Proto), "HTTP/") {
+		r.Proto = "HTTP/1.1"
+	}
+	if r.Header.Get("Cookie") == "" && len(e.Request.Cookies) > 0 {
+		pairs := make([]string, 0, len(e.Request.Cookies))
+		for _, c := range e.Request.Cookies {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+		r.Header.Set("Cookie", strings.Join(pairs, "; "))
+	}
+	if p := e.Request.PostData; p != nil {
+		if r.Body, err = decodeHARText(p.Text, p.Encoding); err != nil {
+			return nil, fmt.Errorf("请求体无效: %v", err)
+		}
+		if p.Text == "" && len(p.Params) > 0 {
+			q := url.Values{}
+			for _, v := range p.Params {
+				q.Add(v.Name, v.Value)
+			}
+			r.Body = []byte(q.Encode())
+		}
+		if p.MimeType != "" && r.Header.Get("Content-Type") == "" {
+			r.Header.Set("Content-Type", p.MimeType)
+		}
+	}
+	r.Response.StateCode = e.Response.Status
+	r.Response.Header = headerOf(e.Response.Headers)
+	r.Response.Error = e.Response.Error
+	if len(r.Response.Header.Values("Set-Cookie")) == 0 {
+		for _, c := range e.Response.Cookies {
+			r.Response.Header.Add("Set-Cookie", (&http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HttpOnly: c.HTTPOnly, Secure: c.Secure}).String())
+		}
+	}
+	if r.Response.Body, err = decodeHARText(e.Response.Content.Text, e.Response.Content.Encoding); err != nil {
+		return nil, fmt.Errorf("响应体无效: %v", err)
+	}
+	if e.Response.Content.MimeType != "" && e.Response.Content.MimeType != "x-unknown" && r.Response.Header.Get("Content-Type") == "" {
+		r.Response.Header.Set("Content-Type", e.Response.Content.MimeType)
+	}
+	//内容已解码保存，去掉压缩标记避免再次解压
+	r.Response.Header.Del("Content-Encoding")
+	if len(e.WebSocket) > 0 || r.Response.StateCode == http.StatusSwitchingProtocols {
+		r.Way = "Websocket"
+		r.SocketData = make([]*UpdateSocketData, 0, len(e.WebSocket))
+		for _, msg := range e.WebSocket {
+			if msg == nil {
+				continue
+			}
+			d := msg.socketData(len(r.SocketData) + 1)
+			if d.Info.Ico == "上行" {
+				r.SendNum += len(d.Body)
+			} else {
+				r.RecNum += len(d.Body)
+			}
+			r.SocketData = append(r.SocketData, d)
+		}
+	}
+	return r, nil
+}
+
+func (msg *HARWebSocketMessage) socketData(Index int) *UpdateSocketData {
+	var body []byte
+	if msg.Opcode == 1 {
+		body = []byte(msg.Data)
+	} else {
+		b, err := base64.StdEncoding.DecodeString(msg.Data)
+		if err != nil {
+			//部分工具导出的二进制消息未编码
+			b = []byte(msg.Data)
+		}
+		body = b
+	}
+	Ico := "上行"
+	if msg.Type == "receive" {
+		Ico = "下行"
+	}
+	sec := int64(msg.Time)
+	t := time.Unix(sec, int64((msg.Time-float64(sec))*float64(time.Second)))
+	BodyHash := ""
+	if len(body) > 64 {
+		BodyHash = fmt.Sprintf("% X", body[:64]) + "..."
+	} else {
+		BodyHash = fmt.Sprintf("% X", body)
+	}
+	return &UpdateSocketData{
+		Body: body,
+		Info: &UpdateSocketList{
+			Index:    Index,
+			Ico:      Ico,
+			BodyHash: BodyHash,
+			Length:   len(body),
+			Time:     t.Format(harTimeLayout),
+			WsType:   wsTypeOf(msg.Opcode),
+		},
+	}
+}
+
+// headerOf HAR 头列表转换为 http.Header，忽略 HTTP/2 伪头
+func headerOf(list []HARNameValue) http.Header {
+	h := make(http.Header)
+	for _, v := range list {
+		if v.Name == "" || strings.HasPrefix(v.Name, ":") {
+			continue
+		}
+		h[v.Name] = append(h[v.Name], v.Value)
+	}
+	return h
+}
+
+func decodeHARText(Text, Encoding string) ([]byte, error) {
+	if Text == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(Encoding, "base64") {
+		return base64.StdEncoding.DecodeString(Text)
+	}
+	return []byte(Text), nil
+}
@@ -2,16 +2,13 @@ package MapHash
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"github.com/qtgolang/SunnyNet/SunnyNet"
 	"github.com/qtgolang/SunnyNet/public"
 	"github.com/qtgolang/SunnyNet/src/GoWinHttp"
 	"io"
-	"net"
 	"net/http"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +20,12 @@ type Map struct {
 	Request      map[int]*Request
 	lock         sync.Mutex
 	UpdateLength map[int]*ResponseLength
+	limits       Limits
+	usage        Usage
+	store        *Store
+	gen          int //清空列表时递增
+	autosaveLock sync.Mutex
+	autosave     *autosaveState
 }
 
 type WaitGroup struct {
@@ -77,15 +80,22 @@ type Request struct {
 		StopRec  bool `json:"StopRec"`
 		StopALL  bool `json:"StopALL"`
 	} `json:"StopSend"`
-	SocketData []*UpdateSocketData `json:"SocketData"`
-	SendTime   string              `json:"SendTime"`
-	RecTime    string              `json:"RecTime"`
-	SendNum    int                 `json:"SendNum"`
-	RecNum     int                 `json:"RecNum"`
-	Way        string              `json:"Way"`
-	Notes      string              `json:"Notes"`
-	ClientIP   string              `json:"ClientIP"`
-	Color      struct {
+	SocketData    []*UpdateSocketData `json:"SocketData"`
+	SendTime      string              `json:"SendTime"`
+	RecTime       string              `json:"RecTime"`
+	SendNum       int                 `json:"SendNum"`
+	RecNum        int                 `json:"RecNum"`
+	Way           string              `json:"Way"`
+	Notes         string              `json:"Notes"`
+	ClientIP      string              `json:"ClientIP"`
+	Pinned        bool                `json:"Pinned"`        //已固定，unpinned 策略下不会被淘汰
+	BodiesEvicted bool                `json:"BodiesEvicted"` //数据体已因存储上限被丢弃
+	Timing        *Timing             `json:"Timing,omitempty"`
+	ReplayOf      int                 `json:"ReplayOf,omitempty"` //由哪个会话重放产生
+	created       time.Time
+	spill         *spillState
+	refs          int //GetRequest 取出后尚未 Release 的次数
+	Color         struct {
 		TagColor string `json:"TagColor"` //标记的文本颜色
 		Search   string `json:"search"`   //搜索的背景颜色
 	} `json:"color"` //显示图标
@@ -102,6 +112,7 @@ type RequestWeb struct {
 		StateCode int
 		StateText string
 		Error     bool
+		Timing    []TimingPhase //耗时瀑布图
 	}
 	SocketData []*UpdateSocketList
 	Options    struct {
@@ -142,6 +153,9 @@ func (m *Map) addResponseLength(TheologyID int, a, b int) {
 func (m *Map) SetRequest(TheologyID int, h *Request) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	if h != nil {
+		h.touch()
+	}
 	m.Request[TheologyID] = h
 }
 func (m *Map) CreateUniqueID() int {
@@ -158,6 +172,7 @@ func (m *Map) SetRequestUDP(TheologyID int, Conn SunnyNet.UDPConn) *Request {
 	} else {
 		m.Request[TheologyID].UdpConn = Conn
 	}
+	m.Request[TheologyID].touch()
 	m.Request[TheologyID].Display = true
 	return m.Request[TheologyID]
 }
@@ -169,6 +184,7 @@ func (m *Map) SetRequestTCP(TheologyID int, Conn SunnyNet.TcpConn) *Request {
 	} else {
 		m.Request[TheologyID].TcpConn = Conn
 	}
+	m.Request[TheologyID].touch()
 	m.Request[TheologyID].Display = true
 	return m.Request[TheologyID]
 }
@@ -176,12 +192,17 @@ func (m *Map) GetRequestWeb(Theology int) *RequestWeb {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	h := m.Request[Theology]
+	if h == nil {
+		return nil
+	}
+	m.load(h)
 	r := &RequestWeb{Body: h.Body, URL: h.URL, Proto: h.Proto, Header: h.Header, Method: h.Method}
 	r.Response.Header = h.Response.Header
 	r.Response.Body = h.Response.Body
 	r.Response.StateText = http.StatusText(h.Response.StateCode)
 	r.Response.StateCode = h.Response.StateCode
 	r.Response.Error = h.Response.Error
+	r.Response.Timing = h.Timing.Phases()
 	r.SocketData = make([]*UpdateSocketList, 0)
 	for i := 0; i < len(h.SocketData); i++ {
 		r.SocketData = append(r.SocketData, h.SocketData[i].Info)
@@ -192,12 +213,30 @@ func (m *Map) GetRequestWeb(Theology int) *RequestWeb {
 
 	return r
 }
+
+// GetRequest 取出会话并加载数据体。会话被固定到调用 Release 为止，期间 SpillIdle 不会释放它的数据体
 func (m *Map) GetRequest(Theology int) *Request {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	h := m.Request[Theology]
+	m.load(h)
+	if h != nil {
+		h.refs++
+	}
 	return h
 }
+
+// Release 用完 GetRequest 取出的会话后调用，h 为 nil 时忽略
+func (m *Map) Release(h *Request) {
+	if h == nil {
+		return
+	}
+	m.lock.Lock()
+	if h.refs > 0 {
+		h.refs--
+	}
+	m.lock.Unlock()
+}
 func (m *Map) SetOptions(Theology int, send, rec, all bool) bool {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -235,16 +274,22 @@ func (m *Map) SetSocketDataEmpty(Theology int) bool {
 		h.SendNum = 0
 		h.RecNum = 0
 		m.addResponseLength(Theology, h.SendNum, h.RecNum)
+		h.resetSocketSpill()
+		m.persist(Theology, h)
 	}
 	return h != nil
 }
 func NewHashMap() *Map {
-	return &Map{Request: make(map[int]*Request)}
+	return &Map{Request: make(map[int]*Request), UpdateLength: make(map[int]*ResponseLength)}
 }
 func (m *Map) Empty() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	mz := make(map[int]*Request)
+	m.gen++
+	if m.store != nil {
+		m.store.record(&indexRecord{Reset: true})
+	}
 	for k, v := range m.Request {
 		if v != nil {
 			if v.UdpConn != nil || v.TcpConn != nil || v.WsConn != nil {
@@ -252,6 +297,8 @@ func (m *Map) Empty() {
 					v.SocketData = make([]*UpdateSocketData, 0)
 					v.RecNum = 0
 					v.SendNum = 0
+					v.resetSocketSpill()
+					m.persist(k, v)
 					mz[k] = v
 				}
 			}
@@ -288,10 +335,13 @@ func (m *Map) Delete(TheologyArray []int) {
 					v.SocketData = make([]*UpdateSocketData, 0)
 					v.RecNum = 0
 					v.SendNum = 0
+					v.resetSocketSpill()
+					m.persist(k, v)
 				}
 			} else {
 				delete(m.Request, k)
 				delete(m.UpdateLength, k)
+				m.forget(k)
 			}
 			v.Wait.Done()
 		}
@@ -299,14 +349,28 @@ func (m *Map) Delete(TheologyArray []int) {
 	}
 }
 func (m *Map) Search(callSearch func(int, int, *Request)) {
+	_ = m.SearchContext(context.Background(), callSearch)
+}
+
+// SearchContext 遍历所有请求，ctx 取消后立即停止并返回 ctx.Err()
+func (m *Map) SearchContext(ctx context.Context, callSearch func(int, int, *Request)) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	max := float64(len(m.Request))
 	i := float64(0)
 	for k, v := range m.Request {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		i++
+		onDisk := v != nil && v.spill != nil && v.spill.onDisk
+		m.load(v)
 		callSearch(k, int(i/max*100), v)
+		if onDisk {
+			m.unload(k, v, false)
+		}
 	}
+	return nil
 }
 func (m *Map) CloseSession(TheologyArray []int) {
 	m.lock.Lock()
@@ -325,200 +389,74 @@ func (m *Map) CloseSession(TheologyArray []int) {
 
 }
 
-func (m *Map) SaveToFile(Path string, All bool, TheologyArray []int, SetStatusText func(string)) bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	var SaveData []*Request
-	SetStatusText("正在统计需要储存的信息")
+// pick 按 theology 顺序选出需要保存或导出的会话，All 为 true 时选出全部显示在列表中的会话
+func (m *Map) pick(All bool, TheologyArray []int) ([]int, []*Request) {
+	var keys []int
+	var list []*Request
 	if All {
-		var keys []int
+		var all []int
 		for k := range m.Request {
-			keys = append(keys, k)
+			all = append(all, k)
 		}
-		sort.Ints(keys)
-		for _, _ket := range keys {
-			k := m.Request[_ket]
-			if k != nil {
-				if k.Display {
-					SaveData = append(SaveData, k)
-				}
-			}
-		}
-	} else {
-		for _, k := range TheologyArray {
+		sort.Ints(all)
+		for _, k := range all {
 			v := m.Request[k]
-			if v != nil {
-				SaveData = append(SaveData, v)
+			if v != nil && v.Display {
+				keys = append(keys, k)
+				list = append(list, v)
 			}
 		}
+		return keys, list
 	}
-	if len(SaveData) < 1 {
-		SetStatusText("需要储存的数量小于1")
-		return false
-	}
-	SetStatusText("有 " + strconv.Itoa(len(SaveData)) + " 条数据正在序列化储存...")
-	bs, e := json.Marshal(&SaveData)
-	if e != nil {
-		SetStatusText("数据序列化储存失败！！")
-		return false
-	}
-	SetStatusText("数据压缩中...")
-	bs2 := BrCompress(bs)
-	if len(bs2) < 1 {
-		SetStatusText("数据失败！")
-		return false
-	}
-	SetStatusText("正在写入文件")
-	err := os.WriteFile(Path, bs2, 666)
-	if err == nil {
-		SetStatusText("保存记录文件成功：" + Path)
-	} else {
-		SetStatusText("保存记录文件失败：" + err.Error())
-	}
-	return err == nil
-}
-
-// Resend 重发请求
-func (m *Map) Resend(TheologyArray []int, mode int, Port int) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
 	for _, k := range TheologyArray {
 		v := m.Request[k]
 		if v != nil {
-			go resend(v, mode, Port)
+			keys = append(keys, k)
+			list = append(list, v)
 		}
 	}
+	return keys, list
 }
 
-func resend(m *Request, mode, Port int) {
-	if m != nil {
-		/*
-			if m.Way == "Websocket" {
-				resendWS(m, mode, Port)
-			}
-		*/
-		if m.Way == "HTTP" {
-			resendHttp(m, mode, Port)
+// loadBodies 临时加载磁盘上的数据体，返回的函数重新释放
+func (m *Map) loadBodies(list []*Request) func() {
+	loaded := make(map[*Request]bool)
+	for _, v := range list {
+		if v.spill != nil && v.spill.onDisk {
+			m.load(v)
+			loaded[v] = true
 		}
-		/*
-			else if m.Way == "UDP" {
-				resendUDP(m, Port)
-			} else if strings.Contains(strings.ToUpper(m.Way), "TCP") {
-				resendTCP(m, strings.Contains(strings.ToUpper(m.Way), "TLS"), Port)
-			}
-		*/
-	}
-
-}
-
-// 写完但是貌似有问题
-func resendTCP(m *Request, _TLS bool, LocalSunnyNetPort int) {
-	_t := strings.Split(m.URL, "->")
-	if len(_t) != 2 {
-		return
-	}
-	DataInfo := m.SocketData
-	if DataInfo == nil {
-		return
-	}
-	RemoteAddr := _t[1]
-	uAddr := SunnyNet.TargetInfo{}
-	uAddr.Parse(RemoteAddr, 0)
-	if uAddr.Port == 0 {
-		return
 	}
-	Conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(LocalSunnyNetPort), time.Duration(10000)*time.Millisecond)
-	defer func() { _ = Conn.Close() }()
-	if err != nil {
-		return
-	}
-	if GoWinHttp.ConnectS5(&Conn, &GoWinHttp.Proxy{}, uAddr.Host, uAddr.Port) == false {
-		return
-	}
-	if _TLS {
-		cfg := &tls.Config{ServerName: uAddr.Host}
-		cfg.InsecureSkipVerify = true
-		tlsConn := tls.Client(Conn, cfg)
-		err = tlsConn.Handshake()
-		if err != nil {
+	return func() {
+		if len(loaded) < 1 {
 			return
 		}
-		Conn = tlsConn
-	}
-	var t time.Time
-	var t2 time.Time
-	for _, v := range m.SocketData {
-		t2, err = time.Parse("2006-01-02 15:04:05.000", "2024-01-01 "+v.Info.Time)
-		if t.Year() == 2024 {
-			if err == nil {
-				time.Sleep(t2.Sub(t))
+		for k, v := range m.Request {
+			if loaded[v] {
+				m.unload(k, v, false)
 			}
 		}
-		t = t2
-		if v.Info.Ico == "上行" {
-			_, _ = Conn.Write(v.Body)
-			mx := make([]byte, 4096)
-			_, _ = Conn.Read(mx)
-		}
 	}
-	time.Sleep(time.Second)
 }
 
-// 未写完
-func resendUDP(m *Request, LocalSunnyNetPort int) {
-	_t := strings.Split(m.URL, "->")
-	if len(_t) != 2 {
-		return
-	}
-	DataInfo := m.SocketData
-	if DataInfo == nil {
-		return
-	}
-	RemoteAddr := _t[1]
-	uAddr := SunnyNet.TargetInfo{}
-	uAddr.Parse(RemoteAddr, 0)
-	if uAddr.Port == 0 {
-		return
-	}
-	PackMsg := func(data []byte) []byte {
-		return nil
-	}
-	// 创建一个 UDP 地址
-	serverAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:"+strconv.Itoa(LocalSunnyNetPort))
-	if err != nil {
-		return
-	}
-	// 连接 UDP 服务器
-	conn, err := net.DialUDP("udp", nil, serverAddr)
-	if err != nil {
-		return
-	}
-	defer func() { _ = conn.Close() }()
-
-	var t time.Time
-	var t2 time.Time
-	for _, v := range m.SocketData {
-		t2, err = time.Parse("2006-01-02 15:04:05.000", "2024-01-01 "+v.Info.Time)
-		if t.Year() == 2024 {
-			if err == nil {
-				time.Sleep(t2.Sub(t))
-			}
+// Resend 重发请求，TCP、UDP 与 Websocket 会话按记录重放，mode 为 4 时按记录的间隔发送
+func (m *Map) Resend(TheologyArray []int, mode int, Port int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, k := range TheologyArray {
+		v := m.Request[k]
+		if v == nil {
+			continue
 		}
-		t = t2
-		if v.Info.Ico == "上行" {
-			_, _ = conn.Write(PackMsg(v.Body))
-			buffer := make([]byte, 4096)
-			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
-			_, _, _ = conn.ReadFromUDP(buffer)
+		if v.Way == "HTTP" {
+			m.load(v)
+			go resendHttp(v, mode, Port)
+		} else {
+			go func(k int) { _, _ = m.Replay(context.Background(), k, Port, ReplayOptions{KeepTiming: mode == 4}) }(k)
 		}
 	}
-	time.Sleep(time.Second)
-
 }
 
-func resendWS(m *Request, mode, Port int) {
-
-}
 func resendHttp(m *Request, mode, SunnyNetServerPort int) {
 	if m == nil {
 		return
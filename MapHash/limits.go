@@ -0,0 +1,211 @@
+package MapHash
+
+import (
+	"sort"
+	"time"
+)
+
+// 淘汰策略
+const (
+	EvictOldest   = "oldest"   // 淘汰最早的会话（包括已固定的会话）
+	EvictUnpinned = "unpinned" // 淘汰最早的未固定会话，固定的会话永不淘汰
+	EvictBodies   = "bodies"   // 只丢弃最早会话的数据体，保留会话信息；会话数超限时仍淘汰最早的会话
+)
+
+// Limits 抓包存储的上限，0 表示不限制
+type Limits struct {
+	MaxFlows int    `json:"MaxFlows"` //最多保留的会话数
+	MaxBytes int64  `json:"MaxBytes"` //请求体、响应体与Socket数据的总字节数
+	MaxAge   int    `json:"MaxAge"`   //会话保留时长（秒）
+	Policy   string `json:"Policy"`   //淘汰策略 oldest/unpinned/bodies
+}
+
+// Usage 抓包存储的当前用量
+type Usage struct {
+	Flows        int    `json:"flows"`        //当前会话数
+	Pinned       int    `json:"pinned"`       //已固定的会话数
+	Bytes        int64  `json:"bytes"`        //当前数据体总字节数
+	EvictedFlows int64  `json:"evictedFlows"` //累计淘汰的会话数
+	TrimmedFlows int64  `json:"trimmedFlows"` //累计丢弃数据体的会话数
+	EvictedBytes int64  `json:"evictedBytes"` //累计释放的字节数
+	StoredFlows  int    `json:"storedFlows"`  //数据体已写入磁盘的会话数（启用磁盘存储时）
+	DiskBytes    int64  `json:"diskBytes"`    //磁盘存储分段文件的大小
+	Limits       Limits `json:"limits"`
+}
+
+// flowSize 会话占用的数据体字节数
+func flowSize(h *Request) int64 {
+	n := int64(len(h.Body) + len(h.Response.Body))
+	for _, d := range h.SocketData {
+		if d != nil {
+			n += int64(len(d.Body))
+		}
+	}
+	return n
+}
+
+// waiting 会话是否停在断点上等待放行，这类会话不能淘汰
+func (r *Request) waiting() bool {
+	r.Wait.lock.Lock()
+	defer r.Wait.lock.Unlock()
+	return r.Wait.i > 0
+}
+
+// live 是否为仍在列表中显示的长连接会话，淘汰时只清空数据（与 Delete 相同）
+func (r *Request) live() bool {
+	return r.Display && (r.UdpConn != nil || r.TcpConn != nil || r.WsConn != nil)
+}
+
+// dropBodies 丢弃会话的数据体，保留会话信息
+func (r *Request) dropBodies() {
+	r.Body = nil
+	r.Response.Body = nil
+	r.SocketData = make([]*UpdateSocketData, 0)
+	r.BodiesEvicted = true
+	if r.spill != nil {
+		r.spill.req, r.spill.resp, r.spill.socket = nil, nil, nil
+	}
+}
+
+// touch 记录会话进入存储的时间
+func (r *Request) touch() {
+	if r.created.IsZero() {
+		r.created = time.Now()
+	}
+}
+
+// restoreCreated 恢复会话进入存储的时间（Unix 毫秒），没有保存时取计时的开始时间，保留时长不因重新打开而重新计算
+func (r *Request) restoreCreated(ms int64) {
+	switch {
+	case ms > 0:
+		r.created = time.UnixMilli(ms)
+	case r.Timing != nil && !r.Timing.Start.IsZero():
+		r.created = r.Timing.Start
+	default:
+		r.touch()
+	}
+}
+
+// SetLimits 设置抓包存储上限，下次 Enforce 时生效
+func (m *Map) SetLimits(l Limits) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch l.Policy {
+	case EvictOldest, EvictUnpinned, EvictBodies:
+	default:
+		l.Policy = EvictOldest
+	}
+	m.limits = l
+}
+
+// Usage 获取抓包存储的当前用量
+func (m *Map) Usage() Usage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	u := m.usage
+	u.Flows, u.Pinned, u.Bytes = 0, 0, 0
+	for _, h := range m.Request {
+		if h == nil {
+			continue
+		}
+		u.Flows++
+		if h.Pinned {
+			u.Pinned++
+		}
+		u.Bytes += flowSize(h)
+		if h.spill != nil && h.spill.onDisk {
+			u.StoredFlows++
+		}
+	}
+	if m.store != nil {
+		m.store.lock.Lock()
+		u.DiskBytes = m.store.size
+		m.store.lock.Unlock()
+	}
+	u.Limits = m.limits
+	return u
+}
+
+// SetPinned 固定或取消固定会话，固定的会话在 unpinned 策略下不会被淘汰
+func (m *Map) SetPinned(Theology int, pinned bool) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := m.Request[Theology]
+	if h != nil {
+		h.Pinned = pinned
+		m.persist(Theology, h)
+	}
+	return h != nil
+}
+
+// Enforce 按上限淘汰会话，从最早的会话开始；返回已从存储中移除的会话ID
+func (m *Map) Enforce() []int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l := m.limits
+	if l.MaxFlows <= 0 && l.MaxBytes <= 0 && l.MaxAge <= 0 {
+		return nil
+	}
+	keys := make([]int, 0, len(m.Request))
+	sizes := make(map[int]int64, len(m.Request))
+	var total int64
+	for k, h := range m.Request {
+		if h == nil {
+			continue
+		}
+		keys = append(keys, k)
+		sizes[k] = flowSize(h)
+		total += sizes[k]
+	}
+	sort.Ints(keys)
+	flows := len(keys)
+	var deadline time.Time
+	if l.MaxAge > 0 {
+		deadline = time.Now().Add(-time.Duration(l.MaxAge) * time.Second)
+	}
+	removed := make([]int, 0)
+	for _, k := range keys {
+		h := m.Request[k]
+		tooMany := l.MaxFlows > 0 && flows > l.MaxFlows
+		tooBig := l.MaxBytes > 0 && total > l.MaxBytes
+		expired := !deadline.IsZero() && !h.created.IsZero() && h.created.Before(deadline)
+		if !tooMany && !tooBig && !expired {
+			if deadline.IsZero() {
+				break
+			}
+			continue
+		}
+		if h.waiting() || (h.Pinned && l.Policy == EvictUnpinned) {
+			continue
+		}
+		if l.Policy == EvictBodies && !tooMany {
+			if sizes[k] > 0 {
+				h.dropBodies()
+				m.recordTrimmed(k, h)
+				total -= sizes[k]
+				m.usage.TrimmedFlows++
+				m.usage.EvictedBytes += sizes[k]
+			}
+			continue
+		}
+		total -= sizes[k]
+		m.usage.EvictedBytes += sizes[k]
+		if h.live() {
+			if sizes[k] > 0 {
+				h.SocketData = make([]*UpdateSocketData, 0)
+				h.BodiesEvicted = true
+				h.resetSocketSpill()
+				m.recordTrimmed(k, h)
+				m.usage.TrimmedFlows++
+			}
+			continue
+		}
+		delete(m.Request, k)
+		delete(m.UpdateLength, k)
+		m.forget(k)
+		flows--
+		m.usage.EvictedFlows++
+		removed = append(removed, k)
+	}
+	return removed
+}
@@ -0,0 +1,523 @@
+package MapHash
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PCAP-NG 导出：https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+// SunnyNet 只保存了 TCP/UDP/Websocket 会话收发的数据（Socket数据），
+// 导出时根据 Request.URL 中的本地与远程地址构造 IP/TCP/UDP 头，每段数据一个数据包（过长的 TCP 数据拆分为多个报文段）。
+// Websocket 会话先写入握手的 HTTP 请求与响应，之后每条消息构造为一个 Websocket 帧。
+// 接口类型为 LINKTYPE_RAW，远程地址为域名时使用 198.18.0.0/15 中的占位地址，并在名称解析块中记录域名。
+
+const (
+	pcapBlockSHB = 0x0A0D0D0A
+	pcapBlockIDB = 0x00000001
+	pcapBlockNRB = 0x00000004
+	pcapBlockEPB = 0x00000006
+
+	pcapLinkTypeRaw = 101
+
+	pcapOptComment = 1
+	pcapOptEPBFlag = 2
+	pcapOptSHBApp  = 4
+	pcapOptIDBName = 2
+
+	pcapFlagInbound  = 1
+	pcapFlagOutbound = 2
+
+	pcapMaxSegment  = 65000 //单个 TCP 报文段最多携带的数据
+	pcapMaxDatagram = 65507
+)
+
+// PcapResult PCAP-NG 导出结果
+type PcapResult struct {
+	Sessions int   `json:"sessions"` //导出的会话数
+	Packets  int   `json:"packets"`  //写入的数据包数
+	Skipped  []int `json:"skipped"`  //未导出的会话（HTTP 会话，或未选择解密时的 TLS 会话）
+}
+
+// pcapPacket 一个待写入的数据包
+type pcapPacket struct {
+	ts       time.Time
+	data     []byte
+	outbound bool
+	comment  string
+}
+
+// pcapEndpoint 一端的地址
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+	name string //域名，写入名称解析块
+}
+
+// pcapFlow 构造一个会话的数据包，记录双方的序号
+type pcapFlow struct {
+	client, server pcapEndpoint
+	v6             bool
+	udp            bool
+	cseq, sseq     uint32
+	packets        []*pcapPacket
+}
+
+// ExportPcapNG 把选中的 TCP、UDP 与 Websocket 会话写为 PCAP-NG。
+// Decrypted 为 false 时只导出原本就是明文的会话；为 true 时 TLS 会话（TLS-TCP、wss）也写入捕获到的明文，
+// 443 端口改为 80，方便 Wireshark 按 HTTP/Websocket 解析。
+func (m *Map) ExportPcapNG(w io.Writer, All bool, TheologyArray []int, Decrypted bool) (*PcapResult, error) {
+	return m.ExportPcapNGContext(context.Background(), w, All, TheologyArray, Decrypted, nil)
+}
+
+// ExportPcapNGContext 同 ExportPcapNG，每处理一个会话调用一次 onProgress（持有抓包锁），ctx 取消后立即停止并返回 ctx.Err()
+func (m *Map) ExportPcapNGContext(ctx context.Context, w io.Writer, All bool, TheologyArray []int, Decrypted bool, onProgress func(done, total int)) (*PcapResult, error) {
+	m.lock.Lock()
+	keys, list := m.pick(All, TheologyArray)
+	release := m.loadBodies(list)
+	res := &PcapResult{Skipped: make([]int, 0)}
+	var packets []*pcapPacket
+	names := make(map[string]string)
+	for i, r := range list {
+		if err := ctx.Err(); err != nil {
+			release()
+			m.lock.Unlock()
+			return nil, err
+		}
+		if onProgress != nil {
+			onProgress(i+1, len(list))
+		}
+		f := r.pcapFlow(keys[i], Decrypted)
+		if f == nil {
+			res.Skipped = append(res.Skipped, keys[i])
+			continue
+		}
+		res.Sessions++
+		packets = append(packets, f.packets...)
+		for _, e := range []pcapEndpoint{f.client, f.server} {
+			if e.name != "" {
+				names[e.ip.String()] = e.name
+			}
+		}
+	}
+	release()
+	m.lock.Unlock()
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].ts.Before(packets[j].ts) })
+	res.Packets = len(packets)
+
+	var buf bytes.Buffer
+	writePcapSHB(&buf)
+	writePcapIDB(&buf)
+	if len(names) > 0 {
+		writePcapNRB(&buf, names)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	for _, p := range packets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		buf.Reset()
+		writePcapEPB(&buf, p)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// pcapFlow 构造会话的数据包，不支持导出的会话返回 nil
+func (r *Request) pcapFlow(Theology int, Decrypted bool) *pcapFlow {
+	way := strings.ToUpper(r.Way)
+	var f *pcapFlow
+	var tls bool
+	switch {
+	case way == "WEBSOCKET":
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			return nil
+		}
+		tls = strings.EqualFold(u.Scheme, "wss") || strings.EqualFold(u.Scheme, "https")
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if tls {
+				port = "443"
+			}
+		}
+		f = newPcapFlow(r.ClientIP, net.JoinHostPort(u.Hostname(), port), Theology, false)
+	case way == "UDP":
+		local, remote, ok := strings.Cut(r.URL, "->")
+		if !ok {
+			return nil
+		}
+		f = newPcapFlow(local, remote, Theology, true)
+	case strings.Contains(way, "TCP"):
+		local, remote, ok := strings.Cut(r.URL, "->")
+		if !ok {
+			return nil
+		}
+		tls = strings.Contains(way, "TLS")
+		f = newPcapFlow(local, remote, Theology, false)
+	default:
+		return nil
+	}
+	if tls && !Decrypted {
+		return nil
+	}
+	comment := "SunnyNet theology " + strconv.Itoa(Theology) + " " + r.Way
+	if tls && f.server.port == 443 {
+		f.server.port = 80
+		comment += "，TLS 解密后的明文，原端口 443"
+	} else if tls {
+		comment += "，TLS 解密后的明文"
+	}
+
+	start, _ := r.flowTimes()
+	last := start
+	if !f.udp {
+		f.handshake(start)
+	}
+	if way == "WEBSOCKET" {
+		f.websocketHandshake(r, start)
+	}
+	for _, d := range r.SocketData {
+		if d == nil || d.Info == nil || d.Info.Index < 0 {
+			continue
+		}
+		last = socketTime(last, d.Info.Time)
+		up := d.Info.Ico != "下行"
+		data := d.Body
+		if way == "WEBSOCKET" {
+			data = websocketFrame(wsOpcode(d.Info.WsType), data, up)
+		}
+		f.send(last, data, up)
+	}
+	if len(f.packets) > 0 {
+		f.packets[0].comment = comment
+	}
+	return f
+}
+
+func newPcapFlow(local, remote string, Theology int, udp bool) *pcapFlow {
+	f := &pcapFlow{udp: udp}
+	f.client = pcapEndpointOf(local)
+	f.server = pcapEndpointOf(remote)
+	if f.client.ip == nil {
+		f.client.ip = net.IPv4(127, 0, 0, 1)
+	}
+	if f.server.ip == nil {
+		f.server.ip = net.IPv4zero.To4()
+	}
+	if f.client.port == 0 {
+		//客户端端口未知时按 theology 生成，保证不同会话的四元组不同
+		f.client.port = uint16(49152 + Theology%16384)
+	}
+	f.v6 = f.client.ip.To4() == nil || f.server.ip.To4() == nil
+	return f
+}
+
+// pcapEndpointOf 解析 host:port，host 不是 IP 时生成占位地址
+func pcapEndpointOf(addr string) pcapEndpoint {
+	addr = strings.TrimSpace(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+	e := pcapEndpoint{}
+	if p, err := strconv.Atoi(port); err == nil && p > 0 && p < 65536 {
+		e.port = uint16(p)
+	}
+	if host == "" {
+		return e
+	}
+	if e.ip = net.ParseIP(host); e.ip == nil {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(host))
+		v := h.Sum32()
+		e.ip = net.IPv4(198, 18+byte(v>>16&1), byte(v>>8), byte(v)).To4()
+		e.name = host
+	}
+	return e
+}
+
+// handshake TCP 三次握手
+func (f *pcapFlow) handshake(ts time.Time) {
+	f.cseq, f.sseq = 0, 0
+	f.add(ts, true, tcpSYN, nil)
+	f.cseq++
+	f.add(ts, false, tcpSYN|tcpACK, nil)
+	f.sseq++
+	f.add(ts, true, tcpACK, nil)
+}
+
+// websocketHandshake 把握手的 HTTP 请求与响应写为 TCP 数据
+func (f *pcapFlow) websocketHandshake(r *Request, ts time.Time) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return
+	}
+	var req bytes.Buffer
+	req.WriteString("GET " + u.RequestURI() + " HTTP/1.1\r\n")
+	h := r.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	if h.Get("Host") == "" {
+		req.WriteString("Host: " + u.Host + "\r\n")
+	}
+	_ = h.Write(&req)
+	req.WriteString("\r\n")
+	f.send(ts, req.Bytes(), true)
+
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rh := r.Response.Header.Clone()
+	if len(rh) == 0 {
+		rh = http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}}
+	}
+	_ = rh.Write(&resp)
+	resp.WriteString("\r\n")
+	f.send(ts, resp.Bytes(), false)
+}
+
+// send 写入一段数据，up 为 true 表示客户端发往服务器
+func (f *pcapFlow) send(ts time.Time, data []byte, up bool) {
+	if f.udp {
+		if len(data) > pcapMaxDatagram {
+			data = data[:pcapMaxDatagram]
+		}
+		f.add(ts, up, 0, data)
+		return
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > pcapMaxSegment {
+			n = pcapMaxSegment
+		}
+		f.add(ts, up, tcpPSH|tcpACK, data[:n])
+		if up {
+			f.cseq += uint32(n)
+		} else {
+			f.sseq += uint32(n)
+		}
+		data = data[n:]
+	}
+}
+
+const (
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// add 构造 IP 包
+func (f *pcapFlow) add(ts time.Time, up bool, flags byte, payload []byte) {
+	src, dst := f.client, f.server
+	seq, ack := f.cseq, f.sseq
+	if !up {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+	var l4 []byte
+	proto := byte(6)
+	if f.udp {
+		proto = 17
+		l4 = make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint16(l4[0:], src.port)
+		binary.BigEndian.PutUint16(l4[2:], dst.port)
+		binary.BigEndian.PutUint16(l4[4:], uint16(len(l4)))
+		copy(l4[8:], payload)
+	} else {
+		l4 = make([]byte, 20+len(payload))
+		binary.BigEndian.PutUint16(l4[0:], src.port)
+		binary.BigEndian.PutUint16(l4[2:], dst.port)
+		binary.BigEndian.PutUint32(l4[4:], seq)
+		if flags&tcpACK != 0 {
+			binary.BigEndian.PutUint32(l4[8:], ack)
+		}
+		l4[12] = 5 << 4
+		l4[13] = flags
+		binary.BigEndian.PutUint16(l4[14:], 65535)
+		copy(l4[20:], payload)
+	}
+	var pkt []byte
+	var pseudo []byte
+	if f.v6 {
+		pkt = make([]byte, 40+len(l4))
+		pkt[0] = 6 << 4
+		binary.BigEndian.PutUint16(pkt[4:], uint16(len(l4)))
+		pkt[6] = proto
+		pkt[7] = 64
+		copy(pkt[8:], src.ip.To16())
+		copy(pkt[24:], dst.ip.To16())
+		pseudo = make([]byte, 40)
+		copy(pseudo, pkt[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(l4)))
+		pseudo[39] = proto
+	} else {
+		pkt = make([]byte, 20+len(l4))
+		pkt[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		pkt[6] = 0x40 //不分片
+		pkt[8] = 64
+		pkt[9] = proto
+		copy(pkt[12:], src.ip.To4())
+		copy(pkt[16:], dst.ip.To4())
+		binary.BigEndian.PutUint16(pkt[10:], checksum(nil, pkt[:20]))
+		pseudo = make([]byte, 12)
+		copy(pseudo, pkt[12:20])
+		pseudo[9] = proto
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(l4)))
+	}
+	sumAt := 16
+	if f.udp {
+		sumAt = 6
+	}
+	sum := checksum(pseudo, l4)
+	if f.udp && sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(l4[sumAt:], sum)
+	copy(pkt[len(pkt)-len(l4):], l4)
+	f.packets = append(f.packets, &pcapPacket{ts: ts, data: pkt, outbound: up})
+}
+
+// checksum 互联网校验和（RFC 1071）
+func checksum(pseudo, data []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(pseudo)
+	add(data)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// websocketFrame 构造单帧 Websocket 消息，客户端发送的帧按协议要求带掩码（掩码为 0，数据不变）
+func websocketFrame(opcode int, payload []byte, masked bool) []byte {
+	if opcode == 0 {
+		opcode = 2
+	}
+	frame := []byte{0x80 | byte(opcode)}
+	mask := byte(0)
+	if masked {
+		mask = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, mask|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, mask|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, mask|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if masked {
+		frame = append(frame, 0, 0, 0, 0)
+	}
+	return append(frame, payload...)
+}
+
+// ============ 块 ============
+
+func writePcapBlock(w *bytes.Buffer, blockType uint32, body []byte) {
+	n := uint32(12 + len(body))
+	_ = binary.Write(w, binary.LittleEndian, blockType)
+	_ = binary.Write(w, binary.LittleEndian, n)
+	w.Write(body)
+	_ = binary.Write(w, binary.LittleEndian, n)
+}
+
+// pcapOption 选项，值按 4 字节对齐
+func pcapOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pcapPad(b)
+}
+
+func pcapPad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func pcapEndOptions(b []byte) []byte {
+	return append(b, 0, 0, 0, 0)
+}
+
+func writePcapSHB(w *bytes.Buffer) {
+	b := binary.LittleEndian.AppendUint32(nil, 0x1A2B3C4D)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, 0xFFFFFFFFFFFFFFFF) //段长度未知
+	b = pcapOption(b, pcapOptSHBApp, []byte("SunnyNet"))
+	writePcapBlock(w, pcapBlockSHB, pcapEndOptions(b))
+}
+
+func writePcapIDB(w *bytes.Buffer) {
+	b := binary.LittleEndian.AppendUint16(nil, pcapLinkTypeRaw)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = pcapOption(b, pcapOptIDBName, []byte("SunnyNet"))
+	writePcapBlock(w, pcapBlockIDB, pcapEndOptions(b))
+}
+
+// writePcapNRB 名称解析块，记录占位地址对应的域名
+func writePcapNRB(w *bytes.Buffer, names map[string]string) {
+	ips := make([]string, 0, len(names))
+	for ip := range names {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	var b []byte
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		code, addr := uint16(1), []byte(ip.To4())
+		if addr == nil {
+			code, addr = 2, ip.To16()
+		}
+		value := append(append(append([]byte{}, addr...), names[s]...), 0)
+		b = pcapOption(b, code, value)
+	}
+	writePcapBlock(w, pcapBlockNRB, pcapEndOptions(b))
+}
+
+func writePcapEPB(w *bytes.Buffer, p *pcapPacket) {
+	us := uint64(p.ts.UnixMicro())
+	b := binary.LittleEndian.AppendUint32(nil, 0)
+	b = binary.LittleEndian.AppendUint32(b, uint32(us>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(us))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.data)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.data)))
+	b = pcapPad(append(b, p.data...))
+	if p.comment != "" {
+		b = pcapOption(b, pcapOptComment, []byte(p.comment))
+	}
+	flag := uint32(pcapFlagInbound)
+	if p.outbound {
+		flag = pcapFlagOutbound
+	}
+	b = pcapOption(b, pcapOptEPBFlag, binary.LittleEndian.AppendUint32(nil, flag))
+	writePcapBlock(w, pcapBlockEPB, pcapEndOptions(b))
+}
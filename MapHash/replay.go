@@ -0,0 +1,448 @@
+package MapHash
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/qtgolang/SunnyNet/SunnyNet"
+	"github.com/qtgolang/SunnyNet/src/GoWinHttp"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 重放 TCP、UDP 与 Websocket 会话：经本地代理端口重新连接目标，按顺序发送记录中客户端发出的数据，
+// 重放产生的连接由代理像普通会话一样捕获，新会话的 ReplayOf 为被重放的会话
+
+// ReplayHeader Websocket 重放时用于关联新会话的请求头，由 HTTP 回调读取后删除
+const ReplayHeader = "SunnyNetReplay"
+
+// ReplayOptions 重放选项
+type ReplayOptions struct {
+	KeepTiming bool          //按记录中的间隔发送，否则连续发送
+	Wait       time.Duration //发送完成后等待响应的时间，默认 1 秒
+}
+
+// ReplayResult 重放结果
+type ReplayResult struct {
+	Theology int    `json:"theology"` //被重放的会话
+	Replay   int    `json:"replay"`   //重放产生的新会话，代理未捕获时为 0
+	Way      string `json:"way"`
+	Frames   int    `json:"frames"`   //发送的数据段数
+	Sent     int    `json:"sent"`     //发送的字节数
+	Received int    `json:"received"` //收到的字节数
+}
+
+type replayFrame struct {
+	body   []byte
+	wsType string
+	at     time.Time
+}
+
+type replayLink struct {
+	from int
+	to   int
+}
+
+var replayLinks = struct {
+	sync.Mutex
+	m map[string]*replayLink
+}{m: make(map[string]*replayLink)}
+
+var replaySeq int64
+
+// expectReplay 登记即将产生的重放连接，key 为客户端地址或 ReplayHeader 的值
+func expectReplay(key string, from int) *replayLink {
+	l := &replayLink{from: from}
+	replayLinks.Lock()
+	replayLinks.m[key] = l
+	replayLinks.Unlock()
+	return l
+}
+
+func (l *replayLink) done(key string) int {
+	replayLinks.Lock()
+	defer replayLinks.Unlock()
+	delete(replayLinks.m, key)
+	return l.to
+}
+
+// LinkReplay 新会话出现时调用，key 为会话的客户端地址或 ReplayHeader 的值，
+// 是重放产生的会话时记录 ReplayOf 并返回被重放的会话，否则返回 0
+func (m *Map) LinkReplay(key string, Theology int) int {
+	replayLinks.Lock()
+	l := replayLinks.m[key]
+	if l != nil && l.to == 0 {
+		l.to = Theology
+	}
+	replayLinks.Unlock()
+	if l == nil {
+		return 0
+	}
+	m.lock.Lock()
+	if h := m.Request[Theology]; h != nil {
+		h.ReplayOf = l.from
+	}
+	m.lock.Unlock()
+	return l.from
+}
+
+// Replay 重放 TCP、UDP 或 Websocket 会话，Port 为本地代理端口
+func (m *Map) Replay(ctx context.Context, Theology int, Port int, opt ReplayOptions) (*ReplayResult, error) {
+	m.lock.Lock()
+	h := m.Request[Theology]
+	if h == nil {
+		m.lock.Unlock()
+		return nil, fmt.Errorf("会话 %d 不存在", Theology)
+	}
+	m.load(h)
+	way, target := h.Way, h.URL
+	var header http.Header
+	if h.Header != nil {
+		header = h.Header.Clone()
+	}
+	frames := h.replayFrames()
+	m.lock.Unlock()
+	if len(frames) < 1 {
+		return nil, fmt.Errorf("会话 %d 没有客户端发送的数据", Theology)
+	}
+	if opt.Wait <= 0 {
+		opt.Wait = time.Second
+	}
+	res := &ReplayResult{Theology: Theology, Way: way}
+	var err error
+	switch {
+	case way == "Websocket":
+		err = replayWS(ctx, res, target, header, frames, Port, opt)
+	case way == "UDP":
+		err = replayUDP(ctx, res, target, frames, Port, opt)
+	case strings.Contains(strings.ToUpper(way), "TCP"):
+		err = replayTCP(ctx, res, target, strings.Contains(strings.ToUpper(way), "TLS"), frames, Port, opt)
+	default:
+		return nil, fmt.Errorf("会话 %d 不是 TCP、UDP 或 Websocket 会话", Theology)
+	}
+	if err != nil && res.Frames == 0 {
+		return nil, err
+	}
+	return res, err
+}
+
+// replayFrames 按顺序取出客户端发出的数据及其时间，须在持有锁时调用
+func (r *Request) replayFrames() []*replayFrame {
+	var list []*replayFrame
+	day := r.created
+	if day.IsZero() {
+		day = time.Now()
+	}
+	last, _ := clockOn(day, r.SendTime)
+	for _, d := range r.SocketData {
+		if d == nil || d.Info == nil || d.Info.Index < 0 {
+			continue
+		}
+		last = socketTime(last, d.Info.Time)
+		if d.Info.Ico != "上行" || d.Info.WsType == "Close" || (len(d.Body) == 0 && d.Info.WsType == "") {
+			continue
+		}
+		list = append(list, &replayFrame{body: append([]byte(nil), d.Body...), wsType: d.Info.WsType, at: last})
+	}
+	return list
+}
+
+// sendFrames 依次发送，KeepTiming 时按记录的间隔等待
+func sendFrames(ctx context.Context, res *ReplayResult, frames []*replayFrame, opt ReplayOptions, send func(f *replayFrame) error) error {
+	for i, f := range frames {
+		if opt.KeepTiming && i > 0 {
+			if d := f.at.Sub(frames[i-1].at); d > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(d):
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(f); err != nil {
+			return err
+		}
+		res.Frames++
+		res.Sent += len(f.body)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(opt.Wait):
+	}
+	return nil
+}
+
+// remoteOf 由 "本地地址->远程地址" 取出远程地址
+func remoteOf(URL string) (SunnyNet.TargetInfo, error) {
+	addr := SunnyNet.TargetInfo{}
+	_t := strings.Split(URL, "->")
+	if len(_t) != 2 {
+		return addr, fmt.Errorf("无法解析会话地址: %s", URL)
+	}
+	addr.Parse(_t[1], 0)
+	if addr.Port == 0 {
+		return addr, fmt.Errorf("无法解析会话地址: %s", URL)
+	}
+	return addr, nil
+}
+
+func replayTCP(ctx context.Context, res *ReplayResult, URL string, _TLS bool, frames []*replayFrame, LocalSunnyNetPort int, opt ReplayOptions) error {
+	uAddr, err := remoteOf(URL)
+	if err != nil {
+		return err
+	}
+	Conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(LocalSunnyNetPort), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = Conn.Close() }()
+	key := Conn.LocalAddr().String()
+	link := expectReplay(key, res.Theology)
+	defer func() { res.Replay = link.done(key) }()
+	if GoWinHttp.ConnectS5(&Conn, &GoWinHttp.Proxy{}, uAddr.Host, uAddr.Port) == false {
+		return errors.New("通过本地代理连接目标失败")
+	}
+	if _TLS {
+		tlsConn := tls.Client(Conn, &tls.Config{ServerName: uAddr.Host, InsecureSkipVerify: true})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		Conn = tlsConn
+	}
+	var received int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		received, _ = io.Copy(io.Discard, Conn)
+	}()
+	err = sendFrames(ctx, res, frames, opt, func(f *replayFrame) error {
+		_, e := Conn.Write(f.body)
+		return e
+	})
+	_ = Conn.Close()
+	<-done
+	res.Received = int(received)
+	return err
+}
+
+func replayUDP(ctx context.Context, res *ReplayResult, URL string, frames []*replayFrame, LocalSunnyNetPort int, opt ReplayOptions) error {
+	uAddr, err := remoteOf(URL)
+	if err != nil {
+		return err
+	}
+	ctrl, relay, err := socks5UDPAssociate("127.0.0.1:" + strconv.Itoa(LocalSunnyNetPort))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ctrl.Close() }()
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	key := conn.LocalAddr().String()
+	link := expectReplay(key, res.Theology)
+	defer func() { res.Replay = link.done(key) }()
+	head := socks5UDPHeader(uAddr.Host, uAddr.Port)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer := make([]byte, 65535)
+		for {
+			n, e := conn.Read(buffer)
+			if e != nil {
+				return
+			}
+			if l := socks5UDPHeaderLen(buffer[:n]); l > 0 {
+				res.Received += n - l
+			}
+		}
+	}()
+	err = sendFrames(ctx, res, frames, opt, func(f *replayFrame) error {
+		_, e := conn.Write(append(append([]byte(nil), head...), f.body...))
+		return e
+	})
+	_ = conn.Close()
+	<-done
+	return err
+}
+
+// socks5UDPAssociate 向 Socket5 代理申请 UDP 转发，返回控制连接（转发持续到其关闭）与转发地址
+func socks5UDPAssociate(proxy string) (net.Conn, *net.UDPAddr, error) {
+	ctrl, err := net.DialTimeout("tcp", proxy, 10*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(e error) (net.Conn, *net.UDPAddr, error) {
+		_ = ctrl.Close()
+		return nil, nil, e
+	}
+	_ = ctrl.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 262)
+	if _, err = ctrl.Write([]byte{5, 1, 0}); err != nil {
+		return fail(err)
+	}
+	if _, err = io.ReadFull(ctrl, buf[:2]); err != nil {
+		return fail(err)
+	}
+	if buf[0] != 5 || buf[1] != 0 {
+		return fail(errors.New("本地代理不支持 Socket5 无认证方式"))
+	}
+	if _, err = ctrl.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return fail(err)
+	}
+	if _, err = io.ReadFull(ctrl, buf[:4]); err != nil {
+		return fail(err)
+	}
+	if buf[1] != 0 {
+		return fail(fmt.Errorf("本地代理拒绝 UDP 转发: %d", buf[1]))
+	}
+	var ip net.IP
+	switch buf[3] {
+	case 1:
+		_, err = io.ReadFull(ctrl, buf[:4])
+		ip = net.IP(append([]byte(nil), buf[:4]...))
+	case 4:
+		_, err = io.ReadFull(ctrl, buf[:16])
+		ip = net.IP(append([]byte(nil), buf[:16]...))
+	case 3:
+		if _, err = io.ReadFull(ctrl, buf[:1]); err == nil {
+			n := int(buf[0])
+			if _, err = io.ReadFull(ctrl, buf[:n]); err == nil {
+				ip = net.ParseIP(string(buf[:n]))
+			}
+		}
+	default:
+		err = errors.New("本地代理返回的地址类型无效")
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if _, err = io.ReadFull(ctrl, buf[:2]); err != nil {
+		return fail(err)
+	}
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	_ = ctrl.SetDeadline(time.Time{})
+	return ctrl, &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(buf[:2]))}, nil
+}
+
+// socks5UDPHeader Socket5 UDP 数据包的地址头
+func socks5UDPHeader(host string, port uint16) []byte {
+	b := []byte{0, 0, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, 1), ip4...)
+		} else {
+			b = append(append(b, 4), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, 3, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(b, port)
+}
+
+// socks5UDPHeaderLen 收到的 Socket5 UDP 数据包的地址头长度，无效时返回 0
+func socks5UDPHeaderLen(b []byte) int {
+	if len(b) < 4 {
+		return 0
+	}
+	n := 0
+	switch b[3] {
+	case 1:
+		n = 4 + 4 + 2
+	case 4:
+		n = 4 + 16 + 2
+	case 3:
+		if len(b) < 5 {
+			return 0
+		}
+		n = 4 + 1 + int(b[4]) + 2
+	default:
+		return 0
+	}
+	if len(b) < n {
+		return 0
+	}
+	return n
+}
+
+// Websocket 握手时由 Dialer 生成的请求头，不能从记录中复制
+var replayWSSkipHeader = map[string]bool{
+	"Upgrade": true, "Connection": true, "Sec-Websocket-Key": true, "Sec-Websocket-Version": true,
+	"Sec-Websocket-Extensions": true, "Content-Length": true, "Transfer-Encoding": true, "Proxy-Connection": true,
+}
+
+func replayWS(ctx context.Context, res *ReplayResult, URL string, header http.Header, frames []*replayFrame, SunnyNetServerPort int, opt ReplayOptions) error {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	h := make(http.Header)
+	for k, v := range header {
+		if !replayWSSkipHeader[http.CanonicalHeaderKey(k)] {
+			h[k] = v
+		}
+	}
+	key := "ws-" + strconv.Itoa(res.Theology) + "-" + strconv.FormatInt(atomic.AddInt64(&replaySeq, 1), 10)
+	h.Set(ReplayHeader, key)
+	link := expectReplay(key, res.Theology)
+	defer func() { res.Replay = link.done(key) }()
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(SunnyNetServerPort)}),
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+		HandshakeTimeout: 10 * time.Second,
+	}
+	Conn, resp, err := dialer.DialContext(ctx, u.String(), h)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, msg, e := Conn.ReadMessage()
+			if e != nil {
+				return
+			}
+			res.Received += len(msg)
+		}
+	}()
+	err = sendFrames(ctx, res, frames, opt, func(f *replayFrame) error {
+		switch op := wsOpcode(f.wsType); op {
+		case websocket.PingMessage, websocket.PongMessage:
+			return Conn.WriteControl(op, f.body, time.Now().Add(10*time.Second))
+		case websocket.BinaryMessage:
+			return Conn.WriteMessage(op, f.body)
+		default:
+			return Conn.WriteMessage(websocket.TextMessage, f.body)
+		}
+	})
+	_ = Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	_ = Conn.Close()
+	<-done
+	return err
+}
@@ -0,0 +1,485 @@
+package MapHash
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/qtgolang/SunnyNet/public"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 磁盘存储：会话信息保留在内存中，请求体、响应体和Socket数据写入只追加的分段文件，
+// 索引文件按 theology 记录会话信息与数据体位置，重启后可以恢复。
+// 读取会话（GetRequest、GetRequestWeb、SearchContext、SaveToFile）时数据体自动从磁盘加载，
+// 空闲且未被 GetRequest 固定的会话由 SpillIdle 重新写回磁盘并释放内存。
+
+const (
+	storeIndexName     = "index.jsonl"
+	storeSegmentPrefix = "segment-"
+	storeSegmentSuffix = ".dat"
+	storeSegmentSize   = 256 << 20 //单个分段文件的大小上限
+)
+
+// bodyRef 数据体在分段文件中的位置，nil 表示数据体为空
+type bodyRef struct {
+	Seg int   `json:"s"`
+	Off int64 `json:"o"`
+	Len int   `json:"l"`
+}
+
+// spillState 会话数据体的落盘状态
+type spillState struct {
+	req        *bodyRef
+	resp       *bodyRef
+	socket     []*bodyRef //与 SocketData 的前 len(socket) 项一一对应
+	onDisk     bool       //数据体当前不在内存中
+	body       []byte     //加载或写入时的请求体，用于判断之后是否被修改
+	respBody   []byte     //加载或写入时的响应体
+	lastAccess time.Time
+}
+
+// indexRecord 索引文件中的一行，同一 theology 以最后一行为准
+type indexRecord struct {
+	Theology int        `json:"t"`
+	Deleted  bool       `json:"d,omitempty"` //会话已删除
+	Reset    bool       `json:"x,omitempty"` //清空，之前的记录全部作废
+	Request  *Request   `json:"r,omitempty"` //会话信息（不含数据体）
+	Req      *bodyRef   `json:"b,omitempty"`
+	Resp     *bodyRef   `json:"rb,omitempty"`
+	Socket   []*bodyRef `json:"sb,omitempty"`
+	Created  int64      `json:"c,omitempty"` //会话进入存储的时间（Unix 毫秒）
+}
+
+// Store 分段文件与索引
+type Store struct {
+	dir     string
+	lock    sync.Mutex
+	seg     *os.File
+	segID   int
+	segSize int64
+	size    int64 //全部分段文件的大小
+	readers map[int]*os.File
+	index   *os.File
+	indexW  *bufio.Writer
+}
+
+func segmentPath(dir string, id int) string {
+	return filepath.Join(dir, storeSegmentPrefix+strconv.Itoa(id)+storeSegmentSuffix)
+}
+
+// openStore 打开存储目录，回放并压缩索引，返回每个会话的最后一条记录
+func openStore(dir string) (*Store, map[int]*indexRecord, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	records := make(map[int]*indexRecord)
+	indexPath := filepath.Join(dir, storeIndexName)
+	if f, err := os.Open(indexPath); err == nil {
+		r := bufio.NewReader(f)
+		for {
+			line, e := r.ReadBytes('\n')
+			if len(line) > 0 && line[len(line)-1] == '\n' {
+				rec := &indexRecord{}
+				//写入中断的最后一行直接丢弃
+				if json.Unmarshal(line, rec) == nil {
+					switch {
+					case rec.Reset:
+						records = make(map[int]*indexRecord)
+					case rec.Deleted:
+						delete(records, rec.Theology)
+					case rec.Request != nil:
+						records[rec.Theology] = rec
+					}
+				}
+			}
+			if e != nil {
+				break
+			}
+		}
+		_ = f.Close()
+	}
+
+	//压缩索引：只保留每个会话的最后一条记录
+	keys := make([]int, 0, len(records))
+	used := make(map[int]bool)
+	for k, rec := range records {
+		keys = append(keys, k)
+		for _, ref := range append([]*bodyRef{rec.Req, rec.Resp}, rec.Socket...) {
+			if ref != nil {
+				used[ref.Seg] = true
+			}
+		}
+	}
+	sort.Ints(keys)
+	tmp := indexPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := bufio.NewWriter(f)
+	for _, k := range keys {
+		bs, _ := json.Marshal(records[k])
+		_, _ = w.Write(append(bs, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.Rename(tmp, indexPath); err != nil {
+		return nil, nil, err
+	}
+
+	s := &Store{dir: dir, readers: make(map[int]*os.File)}
+	//删除不再被引用的分段文件，新数据写入新的分段
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, storeSegmentPrefix) || !strings.HasSuffix(name, storeSegmentSuffix) {
+			continue
+		}
+		id, e1 := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, storeSegmentPrefix), storeSegmentSuffix))
+		if e1 != nil {
+			continue
+		}
+		if id > s.segID {
+			s.segID = id
+		}
+		if !used[id] {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if info, e2 := e.Info(); e2 == nil {
+			s.size += info.Size()
+		}
+	}
+	s.index, err = os.OpenFile(indexPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.indexW = bufio.NewWriter(s.index)
+	return s, records, nil
+}
+
+// write 追加数据体到当前分段文件
+func (s *Store) write(b []byte) (*bodyRef, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.seg == nil || (s.segSize > 0 && s.segSize+int64(len(b)) > storeSegmentSize) {
+		if s.seg != nil {
+			_ = s.seg.Close()
+		}
+		s.segID++
+		f, err := os.OpenFile(segmentPath(s.dir, s.segID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			s.seg = nil
+			return nil, err
+		}
+		s.seg = f
+		s.segSize = 0
+	}
+	if _, err := s.seg.Write(b); err != nil {
+		return nil, err
+	}
+	ref := &bodyRef{Seg: s.segID, Off: s.segSize, Len: len(b)}
+	s.segSize += int64(len(b))
+	s.size += int64(len(b))
+	return ref, nil
+}
+
+// read 读取数据体
+func (s *Store) read(ref *bodyRef) ([]byte, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f := s.readers[ref.Seg]
+	if f == nil {
+		var err error
+		f, err = os.Open(segmentPath(s.dir, ref.Seg))
+		if err != nil {
+			return nil, err
+		}
+		s.readers[ref.Seg] = f
+	}
+	b := make([]byte, ref.Len)
+	if _, err := f.ReadAt(b, ref.Off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// record 追加一条索引记录
+func (s *Store) record(rec *indexRecord) {
+	if rec.Request != nil && !rec.Request.created.IsZero() {
+		rec.Created = rec.Request.created.UnixMilli()
+	}
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	_, _ = s.indexW.Write(append(bs, '\n'))
+	s.lock.Unlock()
+}
+
+func (s *Store) flush() {
+	s.lock.Lock()
+	_ = s.indexW.Flush()
+	s.lock.Unlock()
+}
+
+func (s *Store) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_ = s.indexW.Flush()
+	_ = s.index.Close()
+	if s.seg != nil {
+		_ = s.seg.Close()
+		s.seg = nil
+	}
+	for id, f := range s.readers {
+		_ = f.Close()
+		delete(s.readers, id)
+	}
+}
+
+// sameBytes 是否为同一段数据（加载后未被替换）
+func sameBytes(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
+
+// bodyChanged 数据体在加载或上次写入后是否被修改
+func bodyChanged(cur, prev []byte, onDisk bool) bool {
+	if onDisk {
+		return len(cur) > 0
+	}
+	return !sameBytes(cur, prev)
+}
+
+// OpenStore 启用磁盘存储并恢复上次保存的会话，返回恢复的会话数
+func (m *Map) OpenStore(dir string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.store != nil {
+		return 0, fmt.Errorf("磁盘存储已打开: %s", m.store.dir)
+	}
+	s, records, err := openStore(dir)
+	if err != nil {
+		return 0, err
+	}
+	m.store = s
+	maxID := 0
+	for k, rec := range records {
+		h := rec.Request
+		h.spill = &spillState{req: rec.Req, resp: rec.Resp, socket: rec.Socket, onDisk: true}
+		h.restoreCreated(rec.Created)
+		m.Request[k] = h
+		if k > maxID {
+			maxID = k
+		}
+	}
+	//新会话的ID从恢复的会话之后开始
+	if maxID > 0 {
+		public.AddTheology(int64(maxID))
+	}
+	return len(records), nil
+}
+
+// CloseStore 把内存中的数据体全部写入磁盘并关闭存储
+func (m *Map) CloseStore() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.store == nil {
+		return
+	}
+	for k, h := range m.Request {
+		if h != nil && !h.waiting() {
+			m.unload(k, h, true)
+		}
+	}
+	m.store.close()
+	m.store = nil
+}
+
+// SpillIdle 把超过 idle 未被访问的会话的数据体写入磁盘并释放内存
+func (m *Map) SpillIdle(idle time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.store == nil {
+		return
+	}
+	deadline := time.Now().Add(-idle)
+	for k, h := range m.Request {
+		if h == nil || h.waiting() {
+			continue
+		}
+		//HTTP会话完成（收到响应或失败）前仍在被回调修改，被 GetRequest 取出的会话仍在被读取
+		if h.Conn != nil || h.refs > 0 {
+			continue
+		}
+		last := h.created
+		if h.spill != nil {
+			if h.spill.onDisk && len(h.SocketData) <= len(h.spill.socket) && len(h.Body) == 0 && len(h.Response.Body) == 0 {
+				continue
+			}
+			if !h.spill.lastAccess.IsZero() {
+				last = h.spill.lastAccess
+			}
+		}
+		if last.After(deadline) {
+			continue
+		}
+		m.unload(k, h, true)
+	}
+	m.store.flush()
+}
+
+// load 把会话的数据体从磁盘加载到内存
+func (m *Map) load(h *Request) {
+	if h == nil || h.spill == nil {
+		return
+	}
+	sp := h.spill
+	sp.lastAccess = time.Now()
+	if m.store == nil || !sp.onDisk {
+		return
+	}
+	if len(h.Body) == 0 && sp.req != nil {
+		h.Body, _ = m.store.read(sp.req)
+	}
+	if len(h.Response.Body) == 0 && sp.resp != nil {
+		h.Response.Body, _ = m.store.read(sp.resp)
+	}
+	for i, d := range h.SocketData {
+		if i >= len(sp.socket) {
+			break
+		}
+		if d != nil && d.Body == nil {
+			d.Body, _ = m.store.read(sp.socket[i])
+		}
+	}
+	sp.body = h.Body
+	sp.respBody = h.Response.Body
+	sp.onDisk = false
+}
+
+// unload 把会话中新的或被修改的数据体写入磁盘并释放内存；record 为 false 且数据未变化时不写索引
+func (m *Map) unload(k int, h *Request, record bool) {
+	if m.store == nil {
+		return
+	}
+	sp := h.spill
+	if sp == nil {
+		sp = &spillState{}
+	}
+	changed := false
+	if bodyChanged(h.Body, sp.body, sp.onDisk) {
+		ref, err := m.store.write(h.Body)
+		if err != nil {
+			return
+		}
+		sp.req = ref
+		changed = true
+	}
+	if bodyChanged(h.Response.Body, sp.respBody, sp.onDisk) {
+		ref, err := m.store.write(h.Response.Body)
+		if err != nil {
+			return
+		}
+		sp.resp = ref
+		changed = true
+	}
+	if len(sp.socket) > len(h.SocketData) {
+		sp.socket = nil
+	}
+	for i := len(sp.socket); i < len(h.SocketData); i++ {
+		var ref *bodyRef
+		if d := h.SocketData[i]; d != nil {
+			var err error
+			if ref, err = m.store.write(d.Body); err != nil {
+				return
+			}
+		}
+		sp.socket = append(sp.socket, ref)
+		changed = true
+	}
+	h.Body = nil
+	h.Response.Body = nil
+	for _, d := range h.SocketData {
+		if d != nil {
+			d.Body = nil
+		}
+	}
+	sp.body = nil
+	sp.respBody = nil
+	sp.onDisk = true
+	h.spill = sp
+	if changed || record {
+		m.store.record(&indexRecord{Theology: k, Request: h, Req: sp.req, Resp: sp.resp, Socket: sp.socket})
+	}
+}
+
+// persist 会话信息变化后更新索引（数据体已在磁盘上时）
+func (m *Map) persist(k int, h *Request) {
+	if m.store != nil && h.spill != nil && h.spill.onDisk {
+		m.store.record(&indexRecord{Theology: k, Request: h, Req: h.spill.req, Resp: h.spill.resp, Socket: h.spill.socket})
+	}
+}
+
+// recordTrimmed 会话的数据体被淘汰后更新索引，重启后不再从分段文件中恢复被丢弃的数据
+func (m *Map) recordTrimmed(k int, h *Request) {
+	if m.store != nil && h.spill != nil {
+		m.store.record(&indexRecord{Theology: k, Request: h, Req: h.spill.req, Resp: h.spill.resp, Socket: h.spill.socket})
+	}
+}
+
+// forget 会话已从存储中删除
+func (m *Map) forget(k int) {
+	if m.store != nil {
+		m.store.record(&indexRecord{Theology: k, Deleted: true})
+	}
+}
+
+// resetSocketSpill Socket数据被清空后丢弃对应的磁盘位置
+func (h *Request) resetSocketSpill() {
+	if h.spill != nil {
+		h.spill.socket = nil
+	}
+}
+
+// ResponseBodyLen 响应体长度（数据体在磁盘上时不加载）
+func (r *Request) ResponseBodyLen() int {
+	if r.spill != nil && r.spill.onDisk && len(r.Response.Body) == 0 {
+		if r.spill.resp == nil {
+			return 0
+		}
+		return r.spill.resp.Len
+	}
+	return len(r.Response.Body)
+}
+
+// SearchMeta 遍历所有会话信息，不从磁盘加载数据体
+func (m *Map) SearchMeta(callSearch func(int, *Request)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for k, v := range m.Request {
+		callSearch(k, v)
+	}
+}
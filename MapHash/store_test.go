@@ -0,0 +1,107 @@
+package MapHash
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, dir string) *Map {
+	t.Helper()
+	m := NewHashMap()
+	if _, err := m.OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.CloseStore)
+	return m
+}
+
+func TestSpillIdleAfterRelease(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestStore(t, dir)
+	m.SetRequest(1, &Request{Method: "POST", URL: "http://a.com/", Display: true, Way: "HTTP", Body: []byte("request-body")})
+
+	h := m.GetRequest(1)
+	m.SpillIdle(-1)
+	if string(h.Body) != "request-body" {
+		t.Fatal("固定的会话不应被写回磁盘")
+	}
+	m.Release(h)
+	m.SpillIdle(-1)
+	if h.Body != nil || h.spill == nil || !h.spill.onDisk || h.spill.req == nil {
+		t.Fatal("释放后的会话应被写回磁盘")
+	}
+	seg, err := os.ReadFile(segmentPath(dir, h.spill.req.Seg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := h.spill.req
+	if !bytes.Equal(seg[ref.Off:ref.Off+int64(ref.Len)], []byte("request-body")) {
+		t.Fatal("分段文件中的数据体不正确")
+	}
+
+	h = m.GetRequest(1)
+	defer m.Release(h)
+	if string(h.Body) != "request-body" {
+		t.Fatal("读取时应从磁盘加载数据体")
+	}
+}
+
+func TestStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	m := NewHashMap()
+	if _, err := m.OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	h := &Request{Method: "GET", URL: "http://a.com/", Display: true, Way: "HTTP"}
+	h.Response.Body = []byte("response-body")
+	m.SetRequest(7, h)
+	m.CloseStore()
+
+	m2 := openTestStore(t, dir)
+	h = m2.GetRequest(7)
+	defer m2.Release(h)
+	if h == nil || string(h.Response.Body) != "response-body" {
+		t.Fatal("重启后应恢复会话与数据体")
+	}
+}
+
+func TestRestoreKeepsCreated(t *testing.T) {
+	dir := t.TempDir()
+	m := NewHashMap()
+	if _, err := m.OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	h := &Request{Method: "GET", URL: "http://a.com/", Display: true, Way: "HTTP", Body: []byte("hello")}
+	h.created = created
+	m.SetRequest(1, h)
+	m.CloseStore()
+
+	m2 := openTestStore(t, dir)
+	if got := m2.Request[1].created; !got.Equal(created) {
+		t.Fatalf("重启后会话进入存储的时间应保持不变: %v != %v", got, created)
+	}
+}
+
+func TestTrimmedBodiesStayEvicted(t *testing.T) {
+	dir := t.TempDir()
+	m := NewHashMap()
+	if _, err := m.OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	m.SetRequest(1, &Request{Method: "GET", URL: "http://a.com/", Display: true, Way: "HTTP", Body: []byte("hello")})
+	m.SpillIdle(-1)
+	m.Release(m.GetRequest(1))
+	m.SetLimits(Limits{MaxBytes: 1, Policy: EvictBodies})
+	m.Enforce()
+	m.CloseStore()
+
+	m2 := openTestStore(t, dir)
+	h := m2.GetRequest(1)
+	defer m2.Release(h)
+	if h == nil || len(h.Body) != 0 || !h.BodiesEvicted {
+		t.Fatal("被丢弃的数据体不应在重启后恢复")
+	}
+}
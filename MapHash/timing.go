@@ -0,0 +1,184 @@
+package MapHash
+
+import (
+	"time"
+)
+
+// 会话计时的各个阶段
+const (
+	TimingConnect   = iota //与上游建立连接完成
+	TimingTLS              //与上游 TLS 握手完成
+	TimingSent             //请求已发往上游
+	TimingFirstByte        //收到响应的第一个字节
+	TimingLastByte         //收到响应的最后一个字节
+)
+
+// Timing 会话的计时。Start 为开始时的墙上时间，其余为相对 Start 的微秒数，由单调时钟计算，-1 表示未记录
+type Timing struct {
+	Start     time.Time `json:"Start"`
+	Connect   int64     `json:"Connect"`
+	TLS       int64     `json:"TLS"`
+	Sent      int64     `json:"Sent"`
+	FirstByte int64     `json:"FirstByte"`
+	LastByte  int64     `json:"LastByte"`
+}
+
+// TimingPhase 瀑布图中的一段，单位毫秒
+type TimingPhase struct {
+	Name     string  `json:"name"` //blocked/connect/ssl/send/wait/receive
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// StartTiming 以 t 作为会话开始时间重新计时，t 应来自 time.Now() 以保留单调时钟读数
+func (r *Request) StartTiming(t time.Time) {
+	r.Timing = &Timing{Start: t, Connect: -1, TLS: -1, Sent: -1, FirstByte: -1, LastByte: -1}
+}
+
+// MarkTiming 记录阶段 phase 在 t 时刻完成，尚未开始计时的会话忽略
+func (r *Request) MarkTiming(phase int, t time.Time) {
+	if f := r.Timing.field(phase); f != nil {
+		*f = r.Timing.since(t)
+	}
+}
+
+// MarkTimingOnce 阶段 phase 尚未记录时记录为 t，用于 TCP 会话的首次发送与首次接收
+func (r *Request) MarkTimingOnce(phase int, t time.Time) {
+	if f := r.Timing.field(phase); f != nil && *f < 0 {
+		*f = r.Timing.since(t)
+	}
+}
+
+func (t *Timing) field(phase int) *int64 {
+	if t == nil {
+		return nil
+	}
+	switch phase {
+	case TimingConnect:
+		return &t.Connect
+	case TimingTLS:
+		return &t.TLS
+	case TimingSent:
+		return &t.Sent
+	case TimingFirstByte:
+		return &t.FirstByte
+	case TimingLastByte:
+		return &t.LastByte
+	}
+	return nil
+}
+
+func (t *Timing) since(at time.Time) int64 {
+	if us := at.Sub(t.Start).Microseconds(); us > 0 {
+		return us
+	}
+	return 0
+}
+
+// Total 开始到收到最后一个字节的耗时，未结束时返回 -1
+func (t *Timing) Total() time.Duration {
+	if t == nil || t.LastByte < 0 {
+		return -1
+	}
+	return time.Duration(t.LastByte) * time.Microsecond
+}
+
+// Phases 按时间顺序列出已记录的阶段。
+// 有连接时间时 connect 从开始算起；没有连接与握手时间时开始到发出请求记为 blocked（脚本与断点处理）。
+func (t *Timing) Phases() []TimingPhase {
+	if t == nil {
+		return nil
+	}
+	var list []TimingPhase
+	var cur int64
+	add := func(name string, end int64) {
+		if end < cur {
+			end = cur
+		}
+		list = append(list, TimingPhase{Name: name, Start: usToMs(cur), Duration: usToMs(end - cur)})
+		cur = end
+	}
+	if t.Connect >= 0 {
+		add("connect", t.Connect)
+	}
+	if t.TLS >= 0 {
+		add("ssl", t.TLS)
+	}
+	if t.Sent >= 0 {
+		if t.Connect < 0 && t.TLS < 0 {
+			add("blocked", t.Sent)
+		} else {
+			add("send", t.Sent)
+		}
+	}
+	if t.FirstByte >= 0 {
+		add("wait", t.FirstByte)
+		if t.LastByte >= 0 {
+			add("receive", t.LastByte)
+		}
+	} else if t.LastByte >= 0 {
+		add("wait", t.LastByte)
+	}
+	return list
+}
+
+// harTimings 转换为 HAR 的 timings，HAR 中 connect 包含 ssl
+func (t *Timing) harTimings() HARTimings {
+	h := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	for _, p := range t.Phases() {
+		switch p.Name {
+		case "blocked":
+			h.Blocked = p.Duration
+		case "connect":
+			h.Connect = p.Duration
+		case "ssl":
+			h.SSL = p.Duration
+			if h.Connect < 0 {
+				h.Connect = 0
+			}
+			h.Connect += p.Duration
+		case "send":
+			h.Send = p.Duration
+		case "wait":
+			h.Wait = p.Duration
+		case "receive":
+			h.Receive = p.Duration
+		}
+	}
+	return h
+}
+
+// timingOf 由 HAR 的 timings 还原计时
+func timingOf(start time.Time, h HARTimings) *Timing {
+	t := &Timing{Start: start, Connect: -1, TLS: -1, Sent: -1, FirstByte: -1, LastByte: -1}
+	us := func(v float64) int64 {
+		if v < 0 {
+			return 0
+		}
+		return int64(v * 1000)
+	}
+	off := us(h.Blocked)
+	if h.Connect >= 0 {
+		ssl := int64(0)
+		if h.SSL >= 0 && h.SSL <= h.Connect {
+			ssl = us(h.SSL)
+		}
+		off += us(h.Connect) - ssl
+		t.Connect = off
+		if h.SSL >= 0 {
+			off += ssl
+			t.TLS = off
+		}
+	}
+	off += us(h.Send)
+	t.Sent = off
+	off += us(h.Wait)
+	t.FirstByte = off
+	off += us(h.Receive)
+	t.LastByte = off
+	return t
+}
+
+func usToMs(us int64) float64 {
+	return float64(us) / 1000
+}
@@ -22,6 +22,52 @@ type ReplaceRules struct {
 
 var _ReplaceRules []ReplaceRules
 
+// buildReplaceRules 把配置中的替换规则转换为运行时规则，返回无效规则的Hash
+func buildReplaceRules(list []ConfigReplaceRules) ([]ReplaceRules, []string) {
+	var _Rules []ReplaceRules
+	var failHash []string
+	for _, v := range list {
+		_source := v.Src
+		_target := v.Dest
+		if _source == "" {
+			failHash = append(failHash, v.Hash)
+			continue
+		}
+		switch v.Type {
+		case "Base64":
+			bs1, e1 := base64.StdEncoding.DecodeString(_source)
+			bs2, e2 := base64.StdEncoding.DecodeString(_target)
+			if e1 != nil || e2 != nil {
+				failHash = append(failHash, v.Hash)
+				continue
+			}
+			_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: bs1, target: bs2})
+		case "HEX":
+			bs1, e1 := hex.DecodeString(_source)
+			bs2, e2 := hex.DecodeString(_target)
+			if e1 != nil || e2 != nil {
+				failHash = append(failHash, v.Hash)
+				continue
+			}
+			_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: bs1, target: bs2})
+		case "String(UTF8)":
+			_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: []byte(_source), target: []byte(_target)})
+		case "String(GBK)":
+			_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: Utf8ToGBK([]byte(_source)), target: Utf8ToGBK([]byte(_target))})
+		case "响应文件":
+			bs1, e := os.ReadFile(_target)
+			if e != nil {
+				failHash = append(failHash, v.Hash)
+				continue
+			}
+			_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_File, source: []byte(_source), target: bs1})
+		default:
+			failHash = append(failHash, v.Hash)
+		}
+	}
+	return _Rules, failHash
+}
+
 func ReplaceRulesEvent(command string, args *JSON.SyJson) any {
 	switch command {
 	case "保存替换规则":
@@ -10,6 +10,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -75,14 +77,15 @@ type ListInfo struct {
 }
 
 type UpdateCurrentResponse struct {
-	Theology  int         `json:"Theology"` //唯一ID
-	Header    http.Header `json:"Header"`
-	Body      []byte      `json:"Body"`
-	StateText string      `json:"StateText"`
-	StateCode int         `json:"StateCode"`
-	Break     bool        `json:"断点状态"`
-	Error     bool        `json:"Error"`
-	WebSocket bool        `json:"WebSocket"`
+	Theology  int                   `json:"Theology"` //唯一ID
+	Header    http.Header           `json:"Header"`
+	Body      []byte                `json:"Body"`
+	StateText string                `json:"StateText"`
+	StateCode int                   `json:"StateCode"`
+	Break     bool                  `json:"断点状态"`
+	Error     bool                  `json:"Error"`
+	WebSocket bool                  `json:"WebSocket"`
+	Timing    []MapHash.TimingPhase `json:"Timing"` //耗时瀑布图
 }
 type UpdateICO struct {
 	Theology int    `json:"Theology"` //唯一ID
@@ -93,6 +96,10 @@ func init() {
 	go InsertList()
 	go UpdateResponseLength()
 	GlobalConfig.LoadLocalFile()
+	HashMap.SetLimits(GlobalConfig.CaptureLimits)
+	go EnforceCaptureLimits()
+	openCaptureStore()
+	openAutosave()
 }
 
 var Insert sync.Mutex
@@ -149,6 +156,7 @@ func SetStatusText(Text string) {
 	Insert.Lock()
 	StatusText = Text
 	Insert.Unlock()
+	MCPLog("debug", "status", Text) //状态栏在保存、加载时更新频繁，只作为调试日志
 }
 func UpdateResponseLength() {
 	for {
@@ -162,6 +170,186 @@ func UpdateResponseLength() {
 		Insert.Unlock()
 	}
 }
+
+// EnforceCaptureLimits 定时按抓包存储上限淘汰会话，并从界面列表中移除
+func EnforceCaptureLimits() {
+	for {
+		time.Sleep(2 * time.Second)
+		removed := HashMap.Enforce()
+		if len(removed) < 1 {
+			continue
+		}
+		Insert.Lock()
+		CallJs("移除列表", removed)
+		Insert.Unlock()
+		MCPLog("info", "capture", fmt.Sprintf("已按存储上限淘汰 %d 个会话", len(removed)))
+	}
+}
+
+// openCaptureStore 启用磁盘存储时恢复上次保存的会话，并定时把空闲会话的数据体写入磁盘
+func openCaptureStore() {
+	if !GlobalConfig.CaptureStore.Enable {
+		return
+	}
+	dir := GlobalConfig.CaptureStore.Dir
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Println("获取用户目录失败:", err)
+			return
+		}
+		dir = homeDir + "/Sunny/capture"
+	}
+	n, err := HashMap.OpenStore(dir)
+	if err != nil {
+		fmt.Println("打开磁盘存储失败:", err)
+		return
+	}
+	if n > 0 {
+		fmt.Printf("已从磁盘存储恢复 %d 个会话\n", n)
+	}
+	idle := time.Duration(GlobalConfig.CaptureStore.IdleSeconds) * time.Second
+	if idle <= 0 {
+		idle = 30 * time.Second
+	}
+	go func() {
+		for {
+			time.Sleep(5 * time.Second)
+			HashMap.SpillIdle(idle)
+		}
+	}()
+}
+
+// openAutosave 启用自动保存时从日志文件恢复上次的会话，并定时把有变化的会话追加到日志。
+// 已启用磁盘存储时会话由磁盘存储恢复，不再从日志恢复
+func openAutosave() {
+	if !GlobalConfig.Autosave.Enable {
+		return
+	}
+	Path := GlobalConfig.Autosave.Path
+	if Path == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Println("获取用户目录失败:", err)
+			return
+		}
+		_ = os.MkdirAll(homeDir+"/Sunny", 0755)
+		Path = homeDir + "/Sunny/autosave.syn"
+	}
+	keys, _, err := HashMap.StartAutosave(Path, !GlobalConfig.CaptureStore.Enable)
+	if len(keys) > 0 {
+		fmt.Printf("已从自动保存日志恢复 %d 个会话\n", len(keys))
+	}
+	if err != nil {
+		fmt.Println("开启自动保存失败:", err)
+		return
+	}
+	interval := time.Duration(GlobalConfig.Autosave.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := HashMap.Autosave(); err != nil {
+				fmt.Println("自动保存失败:", err)
+			}
+		}
+	}()
+}
+
+// closeAutosave 退出前写入最后的变化
+func closeAutosave() {
+	if err := HashMap.StopAutosave(); err != nil {
+		fmt.Println("自动保存失败:", err)
+	}
+}
+
+// InsertStoredList 把从磁盘存储或自动保存日志恢复的会话插入界面列表
+func InsertStoredList() {
+	var keys []int
+	stored := make(map[int]*MapHash.Request)
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if h != nil && h.Display {
+			keys = append(keys, theology)
+			stored[theology] = h
+		}
+	})
+	if len(keys) < 1 {
+		return
+	}
+	sort.Ints(keys)
+	list := make([]ListInfo, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, RequestListInfo(k, stored[k]))
+	}
+	Insert.Lock()
+	CallJs("插入列表", list)
+	Insert.Unlock()
+}
+
+// RequestListInfo 已保存会话（记录文件或磁盘存储）在列表中的显示信息
+func RequestListInfo(Theology int, v *MapHash.Request) ListInfo {
+	State := strconv.Itoa(v.Response.StateCode)
+	if !strings.Contains(strings.ToUpper(v.URL), "HTTP") {
+		State = "已断开"
+	}
+	ResponseType := ""
+	Method := v.Method
+	Ico := "websocket_close"
+	ResponseLen := ""
+	if v.Way == "Websocket" {
+		Method = "Websocket"
+		ResponseType = "Websocket"
+		ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
+	} else if v.Way == "UDP" {
+		Method = "UDP"
+		ResponseType = "UDP"
+		ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
+	} else if strings.Contains(strings.ToUpper(Method), "TCP") {
+		ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
+		ResponseType = Method
+	} else {
+		if v.Response.Header != nil {
+			_a := v.Response.Header["Content-Type"]
+			if len(_a) > 0 {
+				ResponseType = _a[0]
+			} else {
+				_a = v.Response.Header["content-type"]
+				if len(_a) > 0 {
+					ResponseType = _a[0]
+				}
+			}
+			if ResponseType != "" {
+				array := strings.Split(ResponseType+";", ";")
+				if len(array) > 0 {
+					ResponseType = array[0]
+				}
+			}
+		}
+		Ico = UpdateIco(v, ResponseType)
+		ResponseLen = strconv.Itoa(v.ResponseBodyLen())
+	}
+	tmp := ListInfo{
+		MessageId: -1,
+		Theology:  Theology,
+		State:     State,
+		URL:       v.URL,
+		ClientIP:  v.ClientIP,
+		PID:       v.PID,
+		Method:    Method,
+		Ico:       Ico,
+		Len:       ResponseLen,
+		Type:      ResponseType,
+		SendTime:  v.SendTime,
+		RecTime:   v.RecTime,
+		Notes:     v.Notes,
+	}
+	tmp.Color.TagColor = v.Color.TagColor
+	tmp.Color.Search = v.Color.Search
+	return tmp
+}
+
 func UpdateIco(conn *MapHash.Request, _ContentType string) string {
 	ContentType := strings.ToLower(_ContentType)
 	Method := strings.ToUpper(conn.Method)
@@ -235,6 +423,7 @@ func UpdateIco(conn *MapHash.Request, _ContentType string) string {
 	return "generic"
 }
 func HttpCallback(Conn SunnyNet.ConnHTTP) {
+	now := time.Now()
 	if Conn.URL() == "" {
 		return
 	}
@@ -246,10 +435,13 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		return
 	}
 	SunnyNetMode := 0
+	replayKey := ""
 	{
 		if Conn.Type() == public.HttpSendRequest {
 			SunnyNetMode, _ = strconv.Atoi(Conn.GetRequestHeader().Get("SunnyNetMode"))
 			Conn.GetRequestHeader().Del("SunnyNetMode")
+			replayKey = Conn.GetRequestHeader().Get(MapHash.ReplayHeader)
+			Conn.GetRequestHeader().Del(MapHash.ReplayHeader)
 			HostsRulesUrl(connURL)
 			u, b := ReplaceURL(connURL)
 			if len(b) > 0 {
@@ -352,9 +544,15 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 				return
 			}
 			h := HashMap.GetRequest(Conn.Theology())
+			defer HashMap.Release(h)
 			if h == nil {
 				return
 			}
+			h.StartTiming(now)
+			if replayKey != "" {
+				HashMap.LinkReplay(replayKey, Conn.Theology())
+			}
+			NotifyFlowAdded(Conn.Theology())
 			// 重新解析 URL（可能已被脚本修改）
 			parsedURL, _ := url.Parse(Conn.URL())
 			if parsedURL == nil {
@@ -362,6 +560,8 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 			}
 			h.SendTime = time.Now().Format("15:04:05.000")
 			if len(h.Response.Header) > 0 {
+				//脚本直接返回了响应，不会发往上游
+				h.MarkTiming(MapHash.TimingLastByte, time.Now())
 				h.RecTime = time.Now().Format("15:04:05.000")
 				AddInsertList(&ListInfo{
 					MessageId: -1,
@@ -385,6 +585,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 				if isUpdateRequestInfo {
 					CallJs("更新响应", &UpdateCurrentResponse{
 						Theology:  Conn.Theology(),
+						Timing:    h.Timing.Phases(),
 						Header:    h.Response.Header,
 						Body:      h.Response.Body,
 						StateText: http.StatusText(h.Response.StateCode),
@@ -498,6 +699,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 			if SunnyNetMode == 2 {
 				h.Break = 2
 			}
+			h.MarkTiming(MapHash.TimingSent, time.Now())
 		}
 	} else if Conn.Type() == public.HttpResponseOK {
 		Break := RunHTTPResponseScriptCode(Conn)
@@ -505,6 +707,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		isUpdateRequestInfo := currentlySelected == Conn.Theology()
 		Insert.Unlock()
 		h := HashMap.GetRequest(Conn.Theology())
+		defer HashMap.Release(h)
 		if h == nil {
 			return
 		}
@@ -517,6 +720,8 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		if Break {
 			h.Break = 2
 		}
+		//代理内核读完整个响应后才回调，首字节时间无法单独记录
+		h.MarkTiming(MapHash.TimingLastByte, now)
 		h.RecTime = time.Now().Format("15:04:05.000")
 		if breakpoint == 2 || h.Break == 2 {
 			IsBreak = 2
@@ -524,6 +729,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		if isUpdateRequestInfo {
 			CallJs("更新响应", &UpdateCurrentResponse{
 				Theology:  Conn.Theology(),
+				Timing:    h.Timing.Phases(),
 				Header:    h.Response.Header,
 				Body:      h.Response.Body,
 				StateText: http.StatusText(h.Response.StateCode),
@@ -569,6 +775,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		}
 		UpdateData = append(UpdateData, _tmp)
 		Insert.Unlock()
+		NotifyFlowUpdated(Conn.Theology())
 		if IsBreak == 2 {
 			h.Wait.Add(1)
 			h.Wait.Wait()
@@ -595,6 +802,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 	} else if Conn.Type() == public.HttpRequestFail {
 		RunHTTPErrorScriptCode(Conn)
 		h := HashMap.GetRequest(Conn.Theology())
+		defer HashMap.Release(h)
 		if h == nil {
 			return
 		}
@@ -604,6 +812,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 			return
 		}
 		h.Break = 0
+		h.MarkTiming(MapHash.TimingLastByte, now)
 		h.RecTime = time.Now().Format("15:04:05.000")
 		h.Response.Error = true
 		h.Response.StateCode = -1
@@ -614,6 +823,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		if isUpdateRequestInfo {
 			CallJs("更新响应", &UpdateCurrentResponse{
 				Theology:  Conn.Theology(),
+				Timing:    h.Timing.Phases(),
 				Body:      h.Response.Body,
 				StateText: "error",
 				StateCode: h.Response.StateCode,
@@ -640,6 +850,7 @@ func HttpCallback(Conn SunnyNet.ConnHTTP) {
 		}
 		UpdateData = append(UpdateData, _tmp)
 		Insert.Unlock()
+		NotifyFlowUpdated(Conn.Theology())
 		h.Conn = nil
 	}
 }
@@ -654,6 +865,7 @@ func WSCallback(Conn SunnyNet.ConnWebSocket) {
 		return
 	}
 	h := HashMap.GetRequest(Conn.Theology())
+	defer HashMap.Release(h)
 	if h == nil {
 		return
 	}
@@ -687,6 +899,7 @@ func WSCallback(Conn SunnyNet.ConnWebSocket) {
 		}
 		UpdateData = append(UpdateData, _tmp)
 		Insert.Unlock()
+		NotifyFlowUpdated(Conn.Theology())
 		return
 	}
 	if Conn.Type() == public.WebsocketUserSend || Conn.Type() == public.WebsocketServerSend {
@@ -764,6 +977,7 @@ func WSCallback(Conn SunnyNet.ConnWebSocket) {
 			},
 		}
 		HashMap.SetSocketData(Conn.Theology(), _update, Conn.Type() == public.WebsocketUserSend, len(Body))
+		NotifyFlowUpdated(Conn.Theology())
 		Insert.Lock()
 		_update.Info.Index = len(h.SocketData)
 		isUpdateRequestInfo := currentlySelected == Conn.Theology()
@@ -789,6 +1003,7 @@ func WSCallback(Conn SunnyNet.ConnWebSocket) {
 			Body: []byte("已断开连接"),
 		}
 		HashMap.SetSocketData(Conn.Theology(), _update, false, 0)
+		NotifyFlowUpdated(Conn.Theology())
 		Insert.Lock()
 		isUpdateRequestInfo := currentlySelected == Conn.Theology()
 		if isUpdateRequestInfo {
@@ -802,6 +1017,7 @@ func WSCallback(Conn SunnyNet.ConnWebSocket) {
 }
 func TcpCallback(Conn SunnyNet.ConnTCP) {
 	h := HashMap.GetRequest(Conn.Theology())
+	defer HashMap.Release(h)
 	if Conn.Type() == public.SunnyNetMsgTypeTCPClose {
 		//time.Sleep(2 * time.Second)
 		if h != nil {
@@ -812,6 +1028,8 @@ func TcpCallback(Conn SunnyNet.ConnTCP) {
 	} else if Conn.Type() == public.SunnyNetMsgTypeTCPAboutToConnect {
 		{
 			h = HashMap.SetRequestTCP(Conn.Theology(), Conn)
+			h.StartTiming(time.Now())
+			HashMap.LinkReplay(Conn.LocalAddress(), Conn.Theology())
 			h.Method = string(Conn.Body())
 			_ = RunTcpScriptCode(Conn)
 			hm := string(Conn.Body())
@@ -835,14 +1053,22 @@ func TcpCallback(Conn SunnyNet.ConnTCP) {
 	}
 	if Conn.Type() == public.SunnyNetMsgTypeTCPConnectOK {
 		//连接成功
+		h.MarkTiming(MapHash.TimingConnect, time.Now())
 		return
 	}
 	if Conn.Type() == public.SunnyNetMsgTypeTCPClientSend || Conn.Type() == public.SunnyNetMsgTypeTCPClientReceive {
 		h.Way = h.Method
+		isNewFlow := h.SendTime == ""
 		if h.SendTime == "" {
 			h.SendTime = time.Now().Format("15:04:05.000")
 		}
 		h.RecTime = time.Now().Format("15:04:05.000")
+		if Conn.Type() == public.SunnyNetMsgTypeTCPClientSend {
+			h.MarkTimingOnce(MapHash.TimingSent, time.Now())
+		} else {
+			h.MarkTimingOnce(MapHash.TimingFirstByte, time.Now())
+			h.MarkTiming(MapHash.TimingLastByte, time.Now())
+		}
 		_tmp := &ListInfo{
 			MessageId: -1,
 			URL:       h.URL,
@@ -862,6 +1088,9 @@ func TcpCallback(Conn SunnyNet.ConnTCP) {
 		AddInsertList(_tmp)
 		h.PID = _tmp.PID
 		h.ClientIP = Conn.LocalAddress()
+		if isNewFlow {
+			NotifyFlowAdded(Conn.Theology())
+		}
 		//客户端发送\接收
 		{
 			if Conn.Type() == public.SunnyNetMsgTypeTCPClientSend {
@@ -908,6 +1137,7 @@ func TcpCallback(Conn SunnyNet.ConnTCP) {
 				},
 			}
 			HashMap.SetSocketData(Conn.Theology(), _update, Conn.Type() == public.SunnyNetMsgTypeTCPClientSend, len(Body))
+			NotifyFlowUpdated(Conn.Theology())
 			Insert.Lock()
 			_update.Info.Index = len(h.SocketData)
 			isUpdateRequestInfo := currentlySelected == Conn.Theology()
@@ -937,6 +1167,7 @@ func TcpCallback(Conn SunnyNet.ConnTCP) {
 		go func() {
 			time.Sleep(2 * time.Second)
 			HashMap.SetSocketData(theology, _update, false, 0)
+			NotifyFlowUpdated(theology)
 			Insert.Lock()
 			isUpdateRequestInfo := currentlySelected == theology
 			if isUpdateRequestInfo {
@@ -972,6 +1203,7 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 	//捕获到数据可以修改,修改空数据,取消发送/接收
 	Theology := int(Conn.Theology())
 	h := HashMap.GetRequest(Theology)
+	defer HashMap.Release(h)
 	if public.SunnyNetUDPTypeSend == Conn.Type() || public.SunnyNetUDPTypeReceive == Conn.Type() {
 		{
 			{
@@ -979,6 +1211,7 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 					h = HashMap.SetRequestUDP(Theology, Conn)
 					h.URL = Conn.LocalAddress() + "->" + Conn.RemoteAddress()
 					h.Method = "UDP"
+					HashMap.LinkReplay(Conn.LocalAddress(), Theology)
 				}
 				if h.UdpConn == nil {
 					h = HashMap.SetRequestUDP(Theology, Conn)
@@ -1002,6 +1235,7 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 			}
 			h.Way = h.Method
 		}
+		isNewFlow := h.SendTime == ""
 		if h.SendTime == "" {
 			h.SendTime = time.Now().Format("15:04:05.000")
 		}
@@ -1025,6 +1259,9 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 		h.PID = _tmp.PID
 		h.ClientIP = Conn.LocalAddress()
 		AddInsertList(_tmp)
+		if isNewFlow {
+			NotifyFlowAdded(Theology)
+		}
 		Body := Conn.Body()
 		if len(Body) < 1 {
 			return
@@ -1052,6 +1289,7 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 			},
 		}
 		HashMap.SetSocketData(Theology, _update, Conn.Type() == public.SunnyNetUDPTypeSend, len(Body))
+		NotifyFlowUpdated(Theology)
 		Insert.Lock()
 		_update.Info.Index = len(h.SocketData)
 		isUpdateRequestInfo := currentlySelected == Theology
@@ -1083,6 +1321,7 @@ func UdpCallback(Conn SunnyNet.ConnUDP) {
 		go func() {
 			time.Sleep(2 * time.Second)
 			HashMap.SetSocketData(Theology, _update, false, 0)
+			NotifyFlowUpdated(Theology)
 			Insert.Lock()
 			isUpdateRequestInfo := currentlySelected == Theology
 			if isUpdateRequestInfo {
@@ -21,6 +21,8 @@ func NewApp() *App {
 	return &App{}
 }
 
+var app = NewApp()
+
 type Command struct {
 	Command string
 	Args    any
@@ -56,6 +58,10 @@ func (a *App) Do(arg any) any {
 	return event(command, sj)
 }
 func (a *App) CallDo(arg ...any) {
+	// 无界面模式下没有窗口上下文，界面事件直接丢弃
+	if a.ctx == nil {
+		return
+	}
 	runtime.EventsEmit(a.ctx, "Do", arg...)
 }
 func getFloat64(arg any) float64 {
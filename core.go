@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/qtgolang/SunnyNet/Api"
+	"github.com/qtgolang/SunnyNet/SunnyNet"
+	"os"
+)
+
+// startSunnyCore 按当前配置创建并启动代理核心（界面与无界面模式共用），返回启动错误文本
+func startSunnyCore() string {
+	app.App = SunnyNet.NewSunny()
+	app.App.DisableTCP(DisableTCP)
+	app.App.SetPort(GlobalConfig.Port)
+	//app.App.MustTcp(true)
+	app.App.SetGoCallback(HttpCallback, TcpCallback, WSCallback, UdpCallback)
+	err := app.App.Start().Error
+	errStr := ""
+	if err != nil {
+		errStr = err.Error()
+		MCPLog("error", "proxy", "代理服务启动失败: "+errStr)
+	}
+	app.App.Socket5VerifyUser(GlobalConfig.Authentication)
+	for k, v := range GlobalConfig.AuthenticationUserInfo {
+		app.App.Socket5AddUser(k, v)
+		_TmpLock.Lock()
+		SocketAuthentication = append(SocketAuthentication, k)
+		_TmpLock.Unlock()
+	}
+	//强制走TCP
+	{
+		app.App.MustTcp(GlobalConfig.MustTcp.Open)
+		_ = app.App.SetMustTcpRegexp(GlobalConfig.MustTcp.Rules, true)
+	}
+	//证书选择
+	{
+		if !GlobalConfig.Cert.Default {
+			id := Api.CreateCertificate()
+			defer Api.RemoveCertificate(id)
+			CaFilePath := GlobalConfig.Cert.CaPath
+			KeyFilePath := GlobalConfig.Cert.KeyPath
+			if !Api.LoadX509KeyPair(id, CaFilePath, KeyFilePath) {
+				bs1, e := os.ReadFile(CaFilePath)
+				if e != nil {
+					GlobalConfig.Cert.Default = true
+					_ = GlobalConfig.saveToFile()
+				} else {
+					bs2, e1 := os.ReadFile(KeyFilePath)
+					if e1 != nil {
+						GlobalConfig.Cert.Default = true
+						_ = GlobalConfig.saveToFile()
+					} else {
+						if !Api.LoadX509Certificate(id, "", string(bs1), string(bs2)) {
+							GlobalConfig.Cert.Default = true
+							_ = GlobalConfig.saveToFile()
+						} else {
+							app.App.Error = nil
+							e2 := app.App.SetCert(id).Error
+							if e2 != nil {
+								GlobalConfig.Cert.Default = true
+								_ = GlobalConfig.saveToFile()
+							}
+						}
+					}
+				}
+			} else {
+				app.App.Error = nil
+				e2 := app.App.SetCert(id).Error
+				if e2 != nil {
+					GlobalConfig.Cert.Default = true
+					_ = GlobalConfig.saveToFile()
+				}
+			}
+		}
+	}
+	//替换规则
+	_ReplaceRules, _ = buildReplaceRules(GlobalConfig.ReplaceRules)
+	//Hosts规则
+	_HostsRules, _ = buildHostsRules(GlobalConfig.HostsRules)
+	//请求证书规则初始化
+	{
+		var _Rules []ConfigRequestCertManager
+		for _, v := range GlobalConfig.RequestCertManager {
+			_Rules = append(_Rules, v)
+		}
+		GlobalConfig.RequestCertManager = make(map[int]ConfigRequestCertManager)
+		for i := 0; i < len(_Rules); i++ {
+			GlobalConfig.RequestCertManager[Api.CreateCertificate()] = _Rules[i]
+		}
+	}
+	return errStr
+}
@@ -541,6 +541,7 @@ func formatHex(data []byte) string {
 // DecryptTCPFlow 解密TCP数据流中的所有数据包
 func (c *CryptoAnalyzer) DecryptTCPFlow(theology int) ([]*DecryptedPacket, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -318,6 +318,25 @@ export async function EventsDo(arg) {
                 }
             )
             return
+        case "MCP工具确认":
+            ElMessageBox.confirm(
+                // 参数来自AI客户端，转义后再显示
+                Args.msg.replaceAll("&", "&amp;").replaceAll("<", "&lt;").replaceAll(">", "&gt;")
+                    .replaceAll("\r", "").replaceAll("\n", "<br>"),
+                "MCP工具调用确认",
+                {
+                    dangerouslyUseHTMLString: true,
+                    confirmButtonText: '允许',
+                    cancelButtonText: '拒绝',
+                    type: 'warning',
+                    closeOnClickModal: false,
+                }
+            ).then(() => {
+                CallGoDo("MCP工具确认结果", {Id: Args.id, Allow: true})
+            }).catch(() => {
+                CallGoDo("MCP工具确认结果", {Id: Args.id, Allow: false})
+            })
+            return
         case "有新版本":
             await ElMessageBox.alert(
                 Args,
@@ -396,6 +415,22 @@ export async function EventsDo(arg) {
                 //window.vm.List.agGridApi.setRowData(window.vm.List.RowData);
             }
             return
+        case "移除列表": {
+            //按抓包存储上限淘汰的会话
+            let array = []
+            for (let i = 0; i < Args.length; i++) {
+                let obj = window.vm.List.RowDataHashMap[Args[i]]
+                if (obj) {
+                    array.push(obj.data)
+                    delete window.vm.List.RowDataHashMap[Args[i]]
+                }
+            }
+            if (array.length > 0) {
+                window.vm.List.agGridApi.applyTransaction({remove: array});
+                IsRefreshList = true
+            }
+        }
+            return
         case "更新响应长度": {
             //let array = []
             for (let i = 0; i < Args.length; i++) {
@@ -8,6 +8,7 @@ require (
 	github.com/Trisia/gosysproxy v1.1.0
 	github.com/andybalholm/brotli v1.1.1
 	github.com/atotto/clipboard v0.1.4
+	github.com/gorilla/websocket v1.5.3
 	github.com/lwch/rdesktop v1.2.2
 	github.com/mitchellh/go-ps v1.0.0
 	github.com/qtgolang/SunnyNet v1.0.0
@@ -23,7 +24,6 @@ require (
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/google/gopacket v1.1.19 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/jchv/go-winloader v0.0.0-20210711035445-715c2860da7e // indirect
 	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/labstack/echo/v4 v4.13.3 // indirect
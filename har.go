@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+)
+
+// exportHARFile 把选中的会话（All 为 true 时为全部）导出为 HAR 1.2 文件，返回导出的会话数；onProgress 可以为 nil
+func exportHARFile(ctx context.Context, Path string, All bool, TheologyArray []int, onProgress func(done, total int)) (int, error) {
+	if Path == "" {
+		return 0, errors.New("文件路径不能为空")
+	}
+	SetStatusText("正在导出HAR文件")
+	h, n, err := HashMap.ExportHARContext(ctx, All, TheologyArray, strconv.Itoa(Version), onProgress)
+	if err != nil {
+		SetStatusText("导出HAR文件失败：" + err.Error())
+		return 0, err
+	}
+	if n < 1 {
+		SetStatusText("没有可导出的HTTP或Websocket会话")
+		return 0, errors.New("没有可导出的HTTP或Websocket会话")
+	}
+	bs, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		SetStatusText("HAR序列化失败：" + err.Error())
+		return 0, err
+	}
+	if err = os.WriteFile(Path, bs, 0644); err != nil {
+		SetStatusText("保存HAR文件失败：" + err.Error())
+		return 0, err
+	}
+	SetStatusText("已导出 " + strconv.Itoa(n) + " 条记录到HAR文件：" + Path)
+	return n, nil
+}
+
+// importHARData 把 HAR 内容导入到会话列表，返回新会话的 theology
+func importHARData(data []byte) ([]int, error) {
+	keys, list, err := HashMap.ImportHAR(data)
+	if err != nil {
+		SetStatusText("解析HAR文件失败：" + err.Error())
+		return nil, err
+	}
+	info := make([]ListInfo, 0, len(keys))
+	for i, Theology := range keys {
+		info = append(info, RequestListInfo(Theology, list[i]))
+		NotifyFlowAdded(Theology)
+	}
+	SetStatusText("导入完成: " + strconv.Itoa(len(keys)) + " 条记录")
+	if len(info) > 0 {
+		CallJs("插入列表", info)
+	}
+	return keys, nil
+}
+
+// importHARFile 读取并导入 HAR 文件
+func importHARFile(Path string) ([]int, error) {
+	if Path == "" {
+		return nil, errors.New("文件路径不能为空")
+	}
+	SetStatusText("正在读取文件:" + Path)
+	bs, err := os.ReadFile(Path)
+	if err != nil {
+		SetStatusText("读取文件失败:" + err.Error())
+		return nil, err
+	}
+	return importHARData(bs)
+}
@@ -1,3 +1,5 @@
+//go:build !headless
+
 package main
 
 import (
@@ -9,7 +11,6 @@ import (
 
 //go:embed all:frontend/dist
 var assets embed.FS
-var app = NewApp()
 
 func main() {
 	// Create an instance of the app structure
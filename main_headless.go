@@ -0,0 +1,76 @@
+//go:build headless
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// 无界面模式：不依赖 Wails 窗口，加载用户配置启动代理核心，并通过 stdio 或 HTTP 提供MCP服务
+// 编译: go build -tags headless
+func main() {
+	transport := flag.String("transport", "http", "MCP传输方式: http 或 stdio")
+	mcpPort := flag.Int("mcp-port", 29999, "HTTP传输时MCP服务的端口")
+	port := flag.Int("port", 0, "代理端口（默认使用配置中的端口）")
+	profile := flag.String("profile", "", "启动后应用的配置文件（JSON），默认读取环境变量 SUNNYNET_PROFILE")
+	flag.Parse()
+
+	if *transport != "http" && *transport != "stdio" {
+		fmt.Fprintf(os.Stderr, "不支持的传输方式: %s\n", *transport)
+		os.Exit(2)
+	}
+	// stdio 模式下标准输出只用于MCP消息，其余输出改写到标准错误
+	protocolOut := os.Stdout
+	if *transport == "stdio" {
+		os.Stdout = os.Stderr
+	}
+
+	if *port > 0 {
+		configLock.Lock()
+		GlobalConfig.Port = *port
+		configLock.Unlock()
+	}
+	if errStr := startSunnyCore(); errStr != "" {
+		fmt.Fprintf(os.Stderr, "代理服务启动失败: %s\n", errStr)
+		os.Exit(1)
+	}
+	defer app.App.Close()
+	if err := applyStartupProfile(*profile); err != nil {
+		fmt.Fprintf(os.Stderr, "应用配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	// 命令行指定的端口优先于配置文件
+	if *port > 0 && app.App.Port() != *port {
+		if _, err := ApplyProfile(&Profile{Port: port}); err != nil {
+			fmt.Fprintf(os.Stderr, "代理服务启动失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "代理服务已启动，端口: %d\n", app.App.Port())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mcpServer = NewMCPServer(*mcpPort)
+	if *transport == "stdio" {
+		if err := mcpServer.ServeStdio(ctx, os.Stdin, protocolOut); err != nil {
+			fmt.Fprintf(os.Stderr, "MCP stdio 传输错误: %v\n", err)
+		}
+		closeAutosave()
+		HashMap.CloseStore()
+		return
+	}
+	if err := mcpServer.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	<-ctx.Done()
+	_ = mcpServer.Stop()
+	closeAutosave()
+	HashMap.CloseStore()
+}
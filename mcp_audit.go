@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计日志文件（~/Sunny/mcp_audit.jsonl），超过大小后轮转为 mcp_audit.1.jsonl ... mcp_audit.N.jsonl
+const (
+	mcpAuditFileName = "mcp_audit"
+	mcpAuditMaxSize  = 10 << 20
+	mcpAuditMaxFiles = 5
+)
+
+// AuditEntry 一次工具调用的审计记录
+type AuditEntry struct {
+	Time       string                 `json:"time"`                 // RFC3339，精确到毫秒
+	Session    string                 `json:"session,omitempty"`    // 客户端会话ID
+	Client     string                 `json:"client,omitempty"`     // 客户端名称
+	Tool       string                 `json:"tool"`                 // 工具名
+	Arguments  map[string]interface{} `json:"arguments"`            // 参数（请求体等内容已隐藏）
+	DurationMs int64                  `json:"durationMs"`           // 耗时（毫秒）
+	Success    bool                   `json:"success"`              // 是否成功
+	Error      string                 `json:"error,omitempty"`      // 失败原因
+	Theologies []int                  `json:"theologies,omitempty"` // 涉及的会话ID
+}
+
+// AuditQueryResult audit_log_query 的返回值
+type AuditQueryResult struct {
+	Entries []AuditEntry `json:"entries"` // 最新的在前
+	Total   int          `json:"total"`   // 符合条件的总数
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+}
+
+// auditLogger 审计日志写入
+type auditLogger struct {
+	file      *os.File
+	size      int64
+	rotations int // 轮转次数，查询时用来判断读取期间文件是否被改名
+	mu        sync.Mutex
+}
+
+// auditLogFile 查询时要读取的日志文件
+type auditLogFile struct {
+	path string
+	size int64 // 记录快照时的大小，只读取这部分
+}
+
+var mcpAudit = &auditLogger{}
+
+// auditLogDir 审计日志所在目录
+func auditLogDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, "Sunny")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// auditLogPath 第n个日志文件（0为当前文件）
+func auditLogPath(dir string, n int) string {
+	if n == 0 {
+		return filepath.Join(dir, mcpAuditFileName+".jsonl")
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.%d.jsonl", mcpAuditFileName, n))
+}
+
+// write 追加一条记录，文件超过大小时先轮转
+func (a *auditLogger) write(entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dir, err := auditLogDir()
+	if err != nil {
+		return err
+	}
+	if a.file == nil {
+		if err = a.open(dir); err != nil {
+			return err
+		}
+	}
+	if a.size > 0 && a.size+int64(len(line)) > mcpAuditMaxSize {
+		a.rotate(dir)
+		if err = a.open(dir); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+func (a *auditLogger) open(dir string) error {
+	f, err := os.OpenFile(auditLogPath(dir, 0), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	a.file = f
+	a.size = info.Size()
+	return nil
+}
+
+// rotate 关闭当前文件并依次改名，最旧的文件被删除
+func (a *auditLogger) rotate(dir string) {
+	if a.file != nil {
+		_ = a.file.Close()
+		a.file = nil
+	}
+	_ = os.Remove(auditLogPath(dir, mcpAuditMaxFiles))
+	for i := mcpAuditMaxFiles - 1; i >= 0; i-- {
+		_ = os.Rename(auditLogPath(dir, i), auditLogPath(dir, i+1))
+	}
+	a.rotations++
+}
+
+// snapshot 列出现有的日志文件（从最旧的开始）与当前的轮转次数
+func (a *auditLogger) snapshot(dir string) ([]auditLogFile, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	files := make([]auditLogFile, 0, mcpAuditMaxFiles+1)
+	for n := mcpAuditMaxFiles; n >= 0; n-- {
+		path := auditLogPath(dir, n)
+		if info, err := os.Stat(path); err == nil {
+			files = append(files, auditLogFile{path: path, size: info.Size()})
+		}
+	}
+	return files, a.rotations
+}
+
+// rotatedSince 快照之后是否发生过轮转
+func (a *auditLogger) rotatedSince(rotations int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rotations != rotations
+}
+
+// auditToolCall 记录一次 tools/call（写入失败只发送日志通知，不影响调用结果）
+func auditToolCall(ctx context.Context, tool string, args map[string]interface{}, start time.Time, result interface{}, callErr error) {
+	entry := &AuditEntry{
+		Time:       start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Tool:       tool,
+		Arguments:  redactAuditArgs(args),
+		DurationMs: time.Since(start).Milliseconds(),
+		Success:    callErr == nil,
+		Theologies: auditTheologies(args, result),
+	}
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+	if session := sessionFromContext(ctx); session != nil {
+		entry.Session = session.ID
+		session.mu.Lock()
+		entry.Client, _ = session.ClientInfo["name"].(string)
+		session.mu.Unlock()
+	}
+	if err := mcpAudit.write(entry); err != nil {
+		MCPLog("warning", "audit", "写入审计日志失败: "+err.Error())
+	}
+}
+
+// 参数名包含这些关键字（或以 _key、_iv 结尾）时只记录长度；header/cookie/text 用于内联 HAR 等嵌套数据
+var auditRedactKeys = []string{"body", "data", "token", "password", "secret", "header", "cookie", "text"}
+
+// redactAuditArgs 复制参数并隐藏请求体、密钥等内容
+func redactAuditArgs(args map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		if auditShouldRedact(k) {
+			out[k] = auditRedacted(v)
+			continue
+		}
+		out[k] = redactAuditValue(v)
+	}
+	return out
+}
+
+// redactAuditValue 递归处理嵌套在对象与数组中的参数
+func redactAuditValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return redactAuditArgs(val)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = redactAuditValue(item)
+		}
+		return out
+	case string:
+		return truncateText(val, 1024)
+	}
+	return v
+}
+
+func auditShouldRedact(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, "_key") || strings.HasSuffix(key, "_iv") {
+		return true
+	}
+	for _, k := range auditRedactKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func auditRedacted(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("[已隐藏 %d 字节]", len(s))
+	}
+	return "[已隐藏]"
+}
+
+// auditTheologies 收集参数与结果中涉及的会话ID
+func auditTheologies(args map[string]interface{}, result interface{}) []int {
+	seen := make(map[int]bool)
+	list := make([]int, 0)
+	add := func(v interface{}) {
+		switch n := v.(type) {
+		case float64:
+			if n > 0 && !seen[int(n)] {
+				seen[int(n)] = true
+				list = append(list, int(n))
+			}
+		case int:
+			if n > 0 && !seen[n] {
+				seen[n] = true
+				list = append(list, n)
+			}
+		case []interface{}:
+			for _, item := range n {
+				if f, ok := item.(float64); ok && f > 0 && !seen[int(f)] {
+					seen[int(f)] = true
+					list = append(list, int(f))
+				}
+			}
+		}
+	}
+	for k, v := range args {
+		if k == "theology" || k == "theologies" {
+			add(v)
+		}
+	}
+	// 修改类工具的结果中带有实际操作的会话ID
+	if m, ok := result.(map[string]interface{}); ok {
+		add(m["theology"])
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// AuditQuery 审计日志查询条件
+type AuditQuery struct {
+	Tool       string
+	Session    string
+	Theology   int
+	Since      time.Time
+	Until      time.Time
+	ErrorsOnly bool
+	Offset     int
+	Limit      int
+}
+
+// match 判断记录是否符合条件
+func (q *AuditQuery) match(e *AuditEntry) bool {
+	if q.Tool != "" && e.Tool != q.Tool {
+		return false
+	}
+	if q.Session != "" && e.Session != q.Session {
+		return false
+	}
+	if q.ErrorsOnly && e.Success {
+		return false
+	}
+	if q.Theology > 0 {
+		found := false
+		for _, id := range e.Theologies {
+			if id == q.Theology {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil {
+			return false
+		}
+		if !q.Since.IsZero() && t.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && t.After(q.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// toolAuditLogQuery 按条件查询审计日志（最新的在前）
+func toolAuditLogQuery(ctx context.Context, q AuditQuery) (interface{}, error) {
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	if q.Limit > 1000 {
+		q.Limit = 1000
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	dir, err := auditLogDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// 先写入的记录在旧文件中，从最旧的文件开始读取。
+	// 只在记录文件列表时持有锁，读取期间不阻塞工具调用；读取期间发生轮转时重新读取
+	var matched []AuditEntry
+	for attempt := 0; ; attempt++ {
+		files, rotations := mcpAudit.snapshot(dir)
+		matched, err = scanAuditFiles(ctx, files, q)
+		if err != nil {
+			return nil, err
+		}
+		if attempt >= 2 || !mcpAudit.rotatedSince(rotations) {
+			break
+		}
+	}
+
+	result := AuditQueryResult{Entries: make([]AuditEntry, 0), Total: len(matched), Offset: q.Offset, Limit: q.Limit}
+	for i := len(matched) - 1 - q.Offset; i >= 0 && len(result.Entries) < q.Limit; i-- {
+		result.Entries = append(result.Entries, matched[i])
+	}
+	return result, nil
+}
+
+// scanAuditFiles 依次读取日志文件中符合条件的记录
+func scanAuditFiles(ctx context.Context, files []auditLogFile, q AuditQuery) ([]AuditEntry, error) {
+	matched := make([]AuditEntry, 0)
+	for i, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ReportProgress(ctx, float64(i), float64(len(files)), "读取审计日志")
+		f, err := os.Open(lf.path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(io.LimitReader(f, lf.size))
+		scanner.Buffer(make([]byte, 0, 64*1024), mcpAuditMaxSize)
+		for scanner.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) != nil {
+				continue
+			}
+			if q.match(&e) {
+				matched = append(matched, e)
+			}
+		}
+		_ = f.Close()
+	}
+	return matched, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testAuditDir 把审计日志目录指向临时目录
+func testAuditDir(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	dir, err := auditLogDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestAuditRotation(t *testing.T) {
+	dir := testAuditDir(t)
+	a := &auditLogger{}
+	t.Cleanup(func() {
+		if a.file != nil {
+			_ = a.file.Close()
+		}
+	})
+	if err := a.write(&AuditEntry{Tool: "first", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	// 当前文件已满，下一条记录写入前轮转
+	a.size = mcpAuditMaxSize
+	if err := a.write(&AuditEntry{Tool: "second", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	for n, tool := range map[int]string{0: "second", 1: "first"} {
+		bs, err := os.ReadFile(auditLogPath(dir, n))
+		if err != nil || !strings.Contains(string(bs), `"tool":"`+tool+`"`) {
+			t.Fatalf("第 %d 个日志文件应包含 %s: %v %s", n, tool, err, bs)
+		}
+	}
+
+	files, rotations := a.snapshot(dir)
+	if rotations != 1 || len(files) != 2 {
+		t.Fatalf("快照: %d %+v", rotations, files)
+	}
+	entries, err := scanAuditFiles(context.Background(), files, AuditQuery{})
+	if err != nil || len(entries) != 2 || entries[0].Tool != "first" || entries[1].Tool != "second" {
+		t.Fatalf("应从最旧的文件开始读取: %v %+v", err, entries)
+	}
+	if a.rotatedSince(rotations) {
+		t.Fatal("快照之后没有轮转")
+	}
+
+	// 超过文件数上限时删除最旧的文件
+	for i := 0; i < mcpAuditMaxFiles+1; i++ {
+		a.mu.Lock()
+		a.size = mcpAuditMaxSize
+		a.mu.Unlock()
+		if err := a.write(&AuditEntry{Tool: "more"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.rotatedSince(rotations) {
+		t.Fatal("应记录轮转次数")
+	}
+	if _, err := os.Stat(auditLogPath(dir, mcpAuditMaxFiles+1)); !os.IsNotExist(err) {
+		t.Fatal("日志文件数不应超过上限")
+	}
+	files, _ = a.snapshot(dir)
+	entries, _ = scanAuditFiles(context.Background(), files, AuditQuery{Tool: "first"})
+	if len(files) != mcpAuditMaxFiles+1 || len(entries) != 0 {
+		t.Fatalf("最旧的记录应已删除: %d %+v", len(files), entries)
+	}
+}
+
+func TestRedactAuditArgs(t *testing.T) {
+	var args map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"theology": 3,
+		"url": "http://a.com/",
+		"cert_key": "k",
+		"har": {"log": {"entries": [{
+			"request": {
+				"url": "http://a.com/",
+				"headers": [{"name": "Cookie", "value": "sid=1"}],
+				"cookies": [{"name": "sid", "value": "1"}],
+				"postData": {"mimeType": "text/plain", "text": "secret"}
+			},
+			"response": {"status": 200, "content": {"size": 6, "text": "abcdef"}}
+		}]}},
+		"rules": [{"X-Auth-Token": "t", "Set-Cookie": "c", "name": "n"}]
+	}`), &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := redactAuditArgs(args)
+	bs, _ := json.Marshal(out)
+	for _, secret := range []string{"sid=1", `"value":"1"`, "secret", "abcdef", `"k"`, `"t"`, `"c"`} {
+		if strings.Contains(string(bs), secret) {
+			t.Fatalf("审计参数中不应出现 %s: %s", secret, bs)
+		}
+	}
+	for _, kept := range []string{`"theology":3`, `"url":"http://a.com/"`, `"status":200`, `"name":"n"`} {
+		if !strings.Contains(string(bs), kept) {
+			t.Fatalf("审计参数中应保留 %s: %s", kept, bs)
+		}
+	}
+	if out["cert_key"] != "[已隐藏 1 字节]" {
+		t.Fatalf("以 _key 结尾的参数应隐藏: %v", out["cert_key"])
+	}
+	// 原参数不应被修改
+	if args["cert_key"] != "k" {
+		t.Fatal("redactAuditArgs 不应修改原参数")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// originAllowed 判断跨域来源是否在允许列表中（没有 Origin 头的非浏览器请求始终允许）
+func (m *MCPServer) originAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.TrimRight(origin, "/")
+	for _, o := range m.allowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetToken 更换访问令牌（立即生效）
+func (m *MCPServer) SetToken(token string) {
+	m.mu.Lock()
+	m.token = token
+	m.mu.Unlock()
+}
+
+// authorized 校验 Authorization: Bearer 令牌
+func (m *MCPServer) authorized(r *http.Request) bool {
+	m.mu.RLock()
+	expected := m.token
+	m.mu.RUnlock()
+	if expected == "" {
+		return true
+	}
+	// 旧版SSE客户端的消息端点不携带令牌：只放行由通过认证的 GET /mcp/sse 创建、推送通道仍在的会话，
+	// Streamable HTTP 会话与 stdio 会话的ID不能代替令牌
+	if r.URL.Path == "/mcp/message" && m.legacySSESession(r.URL.Query().Get("sessionId")) {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// legacySSESession 会话是否为仍在连接的旧版SSE会话
+func (m *MCPServer) legacySSESession(id string) bool {
+	session := m.getSession(id)
+	if session == nil {
+		return false
+	}
+	session.mu.Lock()
+	legacy := session.legacySSE
+	session.mu.Unlock()
+	if !legacy {
+		return false
+	}
+	m.clientsMu.RLock()
+	_, connected := m.clients[id]
+	m.clientsMu.RUnlock()
+	return connected
+}
+
+// writeUnauthorized 返回401
+func (m *MCPServer) writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="SunnyNet-MCP"`)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    -32001,
+			Message: "未授权",
+			Data:    "缺少或错误的访问令牌，请在请求头中携带 Authorization: Bearer <令牌>",
+		},
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"changeme/CommAnd"
+	"changeme/MapHash"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completion/complete 每次最多返回的候选数量（协议上限）
+const mcpCompletionMaxValues = 100
+
+// MCPCompletionsCapability 参数补全能力（空对象）
+type MCPCompletionsCapability struct{}
+
+// MCPCompletion 补全结果
+type MCPCompletion struct {
+	Values  []string `json:"values"`
+	Total   int      `json:"total"`
+	HasMore bool     `json:"hasMore"`
+}
+
+// MCPCompleteResult completion/complete 的返回值
+type MCPCompleteResult struct {
+	Completion MCPCompletion `json:"completion"`
+	// Meta 候选值的说明（如会话ID对应的请求地址），键为候选值
+	Meta map[string]interface{} `json:"_meta,omitempty"`
+}
+
+// 补全的参数类型
+const (
+	completeTheology     = "theology"      // 捕获的会话ID
+	completeHost         = "host"          // 捕获的主机名
+	completeCryptoConfig = "crypto_config" // 加密配置名称
+	completeProcess      = "process"       // 进程名
+	completeReplaceHash  = "replace_hash"  // 替换规则Hash
+)
+
+// handleCompletionComplete 处理 completion/complete
+func (m *MCPServer) handleCompletionComplete(request JSONRPCRequest) JSONRPCResponse {
+	ref, _ := request.Params["ref"].(map[string]interface{})
+	argument, _ := request.Params["argument"].(map[string]interface{})
+	refType, _ := ref["type"].(string)
+	argName, _ := argument["name"].(string)
+	value, _ := argument["value"].(string)
+	if ref == nil || argName == "" {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    "缺少 ref 或 argument.name",
+			},
+		}
+	}
+
+	var refName string
+	switch refType {
+	case "ref/prompt", "ref/tool":
+		refName, _ = ref["name"].(string)
+	case "ref/resource":
+		refName, _ = ref["uri"].(string)
+	default:
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    fmt.Sprintf("不支持的 ref 类型: %s", refType),
+			},
+		}
+	}
+
+	values, labels := completeArgument(refType, refName, argName, value)
+	result := MCPCompleteResult{Completion: MCPCompletion{Values: values, Total: len(values)}}
+	if len(values) > mcpCompletionMaxValues {
+		result.Completion.Values = values[:mcpCompletionMaxValues]
+		result.Completion.HasMore = true
+	}
+	if len(labels) > 0 {
+		shown := make(map[string]string, len(result.Completion.Values))
+		for _, v := range result.Completion.Values {
+			if label, ok := labels[v]; ok {
+				shown[v] = label
+			}
+		}
+		result.Meta = map[string]interface{}{"labels": shown}
+	}
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}
+}
+
+// completeArgument 返回参数的候选值（已按输入过滤）及候选值的说明
+func completeArgument(refType, refName, argName, value string) ([]string, map[string]string) {
+	switch completionKindOf(refType, refName, argName) {
+	case completeTheology:
+		return completeTheologyValues(value)
+	case completeHost:
+		return completeHostValues(value), nil
+	case completeCryptoConfig:
+		return completeCryptoConfigValues(value), nil
+	case completeProcess:
+		return completeProcessValues(value), nil
+	case completeReplaceHash:
+		return completeReplaceHashValues(value)
+	}
+	// 工具参数声明了 enum 时按枚举补全
+	if refType == "ref/tool" {
+		if tool := FindTool(refName); tool != nil {
+			properties, _ := tool.InputSchema["properties"].(map[string]interface{})
+			prop, _ := properties[argName].(map[string]interface{})
+			if enum, ok := schemaList(prop["enum"]); ok {
+				list := make([]string, 0, len(enum))
+				for _, e := range enum {
+					list = append(list, fmt.Sprint(e))
+				}
+				return filterCompletion(list, value), nil
+			}
+		}
+	}
+	return []string{}, nil
+}
+
+// completionKindOf 根据引用与参数名判断参数类型
+func completionKindOf(refType, refName, argName string) string {
+	switch argName {
+	case "theology":
+		return completeTheology
+	case "host":
+		return completeHost
+	case "crypto_config":
+		return completeCryptoConfig
+	}
+	switch refType {
+	case "ref/tool":
+		switch {
+		case argName == "name" && (refName == "process_add_name" || refName == "process_remove_name"):
+			return completeProcess
+		case argName == "name" && refName == "crypto_config_set":
+			return completeCryptoConfig
+		case argName == "hash" && refName == "replace_rules_remove":
+			return completeReplaceHash
+		}
+	case "ref/prompt":
+		// 用户模板中 {{flow:参数}} 为会话ID，{{host:参数}} 为主机名
+		if t := findUserPrompt(refName); t != nil {
+			for _, match := range promptPlaceholderRegexp.FindAllStringSubmatch(t.Template, -1) {
+				if match[2] != argName {
+					continue
+				}
+				switch match[1] {
+				case "flow":
+					return completeTheology
+				case "host":
+					return completeHost
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// completeTheologyValues 最近的会话ID（最新在前），可按ID前缀或请求地址中的关键字过滤
+func completeTheologyValues(value string) ([]string, map[string]string) {
+	value = strings.TrimSpace(value)
+	keyword := strings.ToLower(value)
+	var ids []int
+	labels := make(map[string]string)
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if h == nil || !h.Display {
+			return
+		}
+		id := strconv.Itoa(theology)
+		if value != "" && !strings.HasPrefix(id, value) && !strings.Contains(strings.ToLower(h.URL), keyword) {
+			return
+		}
+		ids = append(ids, theology)
+		labels[id] = strings.TrimSpace(h.Method + " " + h.URL)
+	})
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, strconv.Itoa(id))
+	}
+	return values, labels
+}
+
+// completeHostValues 捕获过的主机名（按会话数量排序）
+func completeHostValues(value string) []string {
+	counts := make(map[string]int)
+	HashMap.SearchMeta(func(_ int, h *MapHash.Request) {
+		if h == nil || !h.Display {
+			return
+		}
+		if host := requestHostOf(h.URL); host != "" {
+			counts[host]++
+		}
+	})
+	hosts := make([]string, 0, len(counts))
+	for host := range counts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if counts[hosts[i]] != counts[hosts[j]] {
+			return counts[hosts[i]] > counts[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+	return filterCompletion(hosts, value)
+}
+
+// completeCryptoConfigValues 加密配置名称
+func completeCryptoConfigValues(value string) []string {
+	if cryptoAnalyzer == nil {
+		return []string{}
+	}
+	names := make([]string, 0)
+	for _, cfg := range cryptoAnalyzer.GetAllConfigs() {
+		names = append(names, cfg.Name)
+	}
+	sort.Strings(names)
+	return filterCompletion(names, value)
+}
+
+// completeProcessValues 当前运行的进程名（去重）
+func completeProcessValues(value string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, name := range CommAnd.EnumerateProcesses() {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	return filterCompletion(names, value)
+}
+
+// completeReplaceHashValues 替换规则Hash，说明为规则的源内容
+func completeReplaceHashValues(value string) ([]string, map[string]string) {
+	configLock.Lock()
+	rules := make([]ConfigReplaceRules, len(GlobalConfig.ReplaceRules))
+	copy(rules, GlobalConfig.ReplaceRules)
+	configLock.Unlock()
+
+	hashes := make([]string, 0, len(rules))
+	labels := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		hashes = append(hashes, rule.Hash)
+		labels[rule.Hash] = rule.Type + ": " + truncateText(rule.Src, 80)
+	}
+	return filterCompletion(hashes, value), labels
+}
+
+// filterCompletion 保留以输入开头的候选值（不区分大小写），其次是包含输入的候选值
+func filterCompletion(list []string, value string) []string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return list
+	}
+	prefix := make([]string, 0)
+	contains := make([]string, 0)
+	for _, item := range list {
+		lower := strings.ToLower(item)
+		if strings.HasPrefix(lower, value) {
+			prefix = append(prefix, item)
+		} else if strings.Contains(lower, value) {
+			contains = append(contains, item)
+		}
+	}
+	return append(prefix, contains...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 运行时发现文件（~/Sunny/mcp_runtime.json）：MCP服务启动时写入地址与令牌，停止时删除
+// mcp_standalone 等桥接程序读取该文件找到当前运行的主程序
+const mcpRuntimeFileName = "mcp_runtime.json"
+
+// MCPRuntimeInfo 发现文件内容
+type MCPRuntimeInfo struct {
+	PID       int    `json:"pid"`
+	URL       string `json:"url"`    // Streamable HTTP 端点
+	SSEURL    string `json:"sseUrl"` // 旧SSE端点
+	Port      int    `json:"port"`
+	Bind      string `json:"bindAddress"`
+	Token     string `json:"token,omitempty"`
+	StartedAt string `json:"startedAt"`
+}
+
+// mcpRuntimeFilePath 发现文件路径
+func mcpRuntimeFilePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, "Sunny")
+	return filepath.Join(dir, mcpRuntimeFileName), os.MkdirAll(dir, 0755)
+}
+
+// writeMCPRuntimeFile 写入发现文件（包含令牌，只允许当前用户读取）
+func writeMCPRuntimeFile(bindAddr string, port int, token string) error {
+	path, err := mcpRuntimeFilePath()
+	if err != nil {
+		return err
+	}
+	// 监听所有地址时本机客户端通过回环地址连接
+	host := bindAddr
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	base := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+	bs, err := json.MarshalIndent(MCPRuntimeInfo{
+		PID:       os.Getpid(),
+		URL:       base + "/mcp",
+		SSEURL:    base + "/mcp/sse",
+		Port:      port,
+		Bind:      bindAddr,
+		Token:     token,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeMCPRuntimeFile 删除本进程写入的发现文件（其他进程写入的保持不变）
+func removeMCPRuntimeFile() {
+	path, err := mcpRuntimeFilePath()
+	if err != nil {
+		return
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var info MCPRuntimeInfo
+	if json.Unmarshal(bs, &info) == nil && info.PID != os.Getpid() {
+		return
+	}
+	_ = os.Remove(path)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// 单次POST允许的最大请求体
+const mcpMaxRequestBody = 16 << 20
+
+// jsonrpcMessage 客户端发来的原始消息（请求、通知或对服务端请求的响应）
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// parsedMessage 解析后的消息
+type parsedMessage struct {
+	Request      JSONRPCRequest
+	Notification bool          // 没有id，不需要响应
+	IsResponse   bool          // 客户端对服务端请求的响应
+	Invalid      *JSONRPCError // 消息本身无效
+	Result       json.RawMessage
+	Error        *JSONRPCError // 客户端响应中的错误
+}
+
+// parseJSONRPCBody 解析请求体，返回消息列表与是否为批量请求；整个请求体无法解析时返回错误
+func parseJSONRPCBody(body []byte) ([]parsedMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false, errors.New("请求体为空")
+	}
+	if body[0] != '[' {
+		var raw json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, false, err
+		}
+		return []parsedMessage{parseJSONRPCMessage(raw)}, false, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, true, err
+	}
+	list := make([]parsedMessage, 0, len(items))
+	for _, raw := range items {
+		list = append(list, parseJSONRPCMessage(raw))
+	}
+	return list, true, nil
+}
+
+// parseJSONRPCMessage 解析单条消息
+func parseJSONRPCMessage(raw json.RawMessage) parsedMessage {
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return parsedMessage{Invalid: &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "消息必须是JSON对象"}}
+	}
+
+	var id interface{}
+	hasID := len(msg.ID) > 0
+	if hasID {
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			return parsedMessage{Invalid: &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "无效的id"}}
+		}
+		switch id.(type) {
+		case string, float64, nil:
+		default:
+			return parsedMessage{Invalid: &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "id必须是字符串或数字"}}
+		}
+	}
+	p := parsedMessage{Request: JSONRPCRequest{JSONRPC: msg.JSONRPC, ID: id, Method: msg.Method}}
+
+	if msg.JSONRPC != "2.0" {
+		p.Invalid = &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "必须使用JSON-RPC 2.0"}
+		return p
+	}
+	if msg.Method == "" {
+		if hasID && (len(msg.Result) > 0 || len(msg.Error) > 0) {
+			p.IsResponse = true
+			p.Result = msg.Result
+			if len(msg.Error) > 0 {
+				p.Error = &JSONRPCError{}
+				if err := json.Unmarshal(msg.Error, p.Error); err != nil {
+					p.Error = &JSONRPCError{Code: -32603, Message: string(msg.Error)}
+				}
+			}
+			return p
+		}
+		p.Invalid = &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "缺少method"}
+		return p
+	}
+	p.Notification = !hasID
+	if len(msg.Params) > 0 && string(msg.Params) != "null" {
+		if err := json.Unmarshal(msg.Params, &p.Request.Params); err != nil {
+			p.Invalid = &JSONRPCError{Code: -32602, Message: "无效的参数", Data: "params必须是对象"}
+		}
+	}
+	return p
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseJSONRPCBatch(t *testing.T) {
+	body := `[
+		{"jsonrpc":"2.0","id":1,"method":"tools/list"},
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":"e1","result":{"action":"accept"}},
+		{"jsonrpc":"1.0","id":2,"method":"ping"},
+		{"jsonrpc":"2.0","id":3,"method":"tools/call","params":[1]},
+		{"jsonrpc":"2.0","id":{},"method":"ping"},
+		1
+	]`
+	messages, batch, err := parseJSONRPCBody([]byte(body))
+	if err != nil || !batch || len(messages) != 7 {
+		t.Fatalf("批量请求解析失败: %v %v %d", err, batch, len(messages))
+	}
+	if m := messages[0]; m.Invalid != nil || m.Notification || m.Request.ID != float64(1) || m.Request.Method != "tools/list" {
+		t.Fatalf("请求: %+v", m)
+	}
+	if m := messages[1]; m.Invalid != nil || !m.Notification {
+		t.Fatalf("通知: %+v", m)
+	}
+	if m := messages[2]; m.Invalid != nil || !m.IsResponse || string(m.Result) != `{"action":"accept"}` {
+		t.Fatalf("客户端响应: %+v", m)
+	}
+	for i, code := range map[int]int{3: -32600, 4: -32602, 5: -32600, 6: -32600} {
+		if m := messages[i]; m.Invalid == nil || m.Invalid.Code != code {
+			t.Fatalf("第 %d 条应返回错误 %d: %+v", i, code, m)
+		}
+	}
+	if messages[3].Request.ID != float64(2) {
+		t.Fatal("版本错误的请求应保留id以便回复")
+	}
+}
+
+func TestParseJSONRPCBody(t *testing.T) {
+	messages, batch, err := parseJSONRPCBody([]byte(`{"jsonrpc":"2.0","id":"a","method":"ping"}`))
+	if err != nil || batch || len(messages) != 1 || messages[0].Request.ID != "a" {
+		t.Fatalf("单条请求解析失败: %v %v %+v", err, batch, messages)
+	}
+	messages, batch, err = parseJSONRPCBody([]byte(` [] `))
+	if err != nil || !batch || len(messages) != 0 {
+		t.Fatalf("空批量请求: %v %v %d", err, batch, len(messages))
+	}
+	for _, body := range []string{"", "{", `[{"jsonrpc":"2.0"`} {
+		if _, _, err := parseJSONRPCBody([]byte(body)); err == nil {
+			t.Fatalf("%q 应返回解析错误", body)
+		}
+	}
+}
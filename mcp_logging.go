@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MCP日志级别（RFC 5424，由低到高）
+var mcpLogLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// 客户端未调用 logging/setLevel 时的默认级别
+const mcpDefaultLogLevel = "info"
+
+// MCPLoggingCapability 日志能力（空对象）
+type MCPLoggingCapability struct{}
+
+// mcpLogLevelIndex 返回级别的序号，无效级别返回-1
+func mcpLogLevelIndex(level string) int {
+	for i, v := range mcpLogLevels {
+		if v == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetLogLevel 设置会话接收日志的最低级别
+func (s *MCPSession) SetLogLevel(level string) {
+	s.mu.Lock()
+	s.logLevel = level
+	s.mu.Unlock()
+}
+
+// wantsLog 判断会话是否接收该级别的日志
+func (s *MCPSession) wantsLog(level int) bool {
+	s.mu.Lock()
+	min := s.logLevel
+	s.mu.Unlock()
+	if min == "" {
+		min = mcpDefaultLogLevel
+	}
+	return level >= mcpLogLevelIndex(min)
+}
+
+// handleLoggingSetLevel 处理 logging/setLevel
+func (m *MCPServer) handleLoggingSetLevel(session *MCPSession, request JSONRPCRequest) JSONRPCResponse {
+	level, _ := request.Params["level"].(string)
+	level = strings.ToLower(level)
+	if mcpLogLevelIndex(level) < 0 {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    "level 必须是 " + strings.Join(mcpLogLevels, "、") + " 之一",
+			},
+		}
+	}
+	if session != nil {
+		session.SetLogLevel(level)
+	}
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  map[string]interface{}{},
+	}
+}
+
+// MCPLog 向已连接的客户端发送 notifications/message（MCP服务未运行时忽略）
+func MCPLog(level, logger string, data interface{}) {
+	if mcpServer == nil || !mcpServer.IsRunning() {
+		return
+	}
+	index := mcpLogLevelIndex(level)
+	if index < 0 {
+		return
+	}
+	params := map[string]interface{}{
+		"level":  level,
+		"logger": logger,
+		"data":   data,
+	}
+	for _, session := range mcpServer.allSessions() {
+		if session.wantsLog(index) {
+			mcpServer.Notify(session, "notifications/message", params)
+		}
+	}
+}
+
+// scriptLogHook 脚本中调用 Log() 时转发到MCP客户端
+func scriptLogHook(msg ...interface{}) {
+	if len(msg) < 1 {
+		return
+	}
+	MCPLog("info", "script", strings.TrimSuffix(fmt.Sprintln(msg...), "\n"))
+}
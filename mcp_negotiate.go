@@ -0,0 +1,35 @@
+package main
+
+import "regexp"
+
+// 本文件不依赖其他文件，stdio 桥接程序编译时一起带上：go build mcp_stdio.go mcp_negotiate.go
+
+// 支持的MCP协议版本（最新的在前）
+var mcpSupportedVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// mcpLatestVersion 支持的最新协议版本
+var mcpLatestVersion = mcpSupportedVersions[0]
+
+var protocolVersionRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// isSupportedVersion 是否是支持的协议版本
+func isSupportedVersion(version string) bool {
+	for _, v := range mcpSupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateVersion 协商协议版本：支持客户端请求的版本时使用该版本，
+// 不支持的版本（更旧或更新）按规范回复支持的最新版本，由客户端决定是否继续；版本号格式不正确时返回 false
+func negotiateVersion(requested string) (string, bool) {
+	if isSupportedVersion(requested) {
+		return requested, true
+	}
+	if protocolVersionRegexp.MatchString(requested) {
+		return mcpLatestVersion, true
+	}
+	return "", false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 捕获列表资源URI（订阅后可收到列表变化的 notifications/resources/updated）
+const mcpCaptureListURI = "sunnynet://requests"
+
+// 资源变化通知的合并间隔，避免抓包高峰时刷屏
+const mcpResourceNotifyInterval = 500 * time.Millisecond
+
+// JSONRPCNotification JSON-RPC 2.0 通知结构（没有id，不需要响应）
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// resourceChanges 待发送的资源变化
+type resourceChanges struct {
+	mu      sync.Mutex
+	added   bool
+	updated map[int]bool
+}
+
+var pendingResourceChanges = &resourceChanges{updated: make(map[int]bool)}
+
+// NotifyFlowAdded 新会话加入捕获列表时调用
+func NotifyFlowAdded(theology int) {
+	markFlowChanged(theology, true)
+}
+
+// NotifyFlowUpdated 已有会话发生变化（收到响应、新的Socket数据、断开等）时调用
+func NotifyFlowUpdated(theology int) {
+	markFlowChanged(theology, false)
+}
+
+func markFlowChanged(theology int, added bool) {
+	if mcpServer == nil || !mcpServer.IsRunning() {
+		return
+	}
+	pendingResourceChanges.mu.Lock()
+	if added {
+		pendingResourceChanges.added = true
+	}
+	pendingResourceChanges.updated[theology] = true
+	pendingResourceChanges.mu.Unlock()
+}
+
+// take 取出并清空待发送的变化
+func (c *resourceChanges) take() (bool, []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	added := c.added
+	ids := make([]int, 0, len(c.updated))
+	for id := range c.updated {
+		ids = append(ids, id)
+	}
+	c.added = false
+	c.updated = make(map[int]bool)
+	return added, ids
+}
+
+// newNotification 序列化一条通知
+func newNotification(method string, params interface{}) []byte {
+	bs, _ := json.Marshal(JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+	return bs
+}
+
+// Notify 向指定会话发送通知
+func (m *MCPServer) Notify(session *MCPSession, method string, params interface{}) bool {
+	if session == nil {
+		return false
+	}
+	return m.SendToClient(session.ID, newNotification(method, params))
+}
+
+// NotifyAll 向所有已连接的客户端广播通知
+func (m *MCPServer) NotifyAll(method string, params interface{}) {
+	m.BroadcastToAll(newNotification(method, params))
+}
+
+// resourceNotifyLoop 定时把合并后的资源变化推送给客户端
+func (m *MCPServer) resourceNotifyLoop(stop chan struct{}) {
+	ticker := time.NewTicker(mcpResourceNotifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			added, ids := pendingResourceChanges.take()
+			if !added && len(ids) == 0 {
+				continue
+			}
+			m.flushResourceChanges(added, ids)
+		}
+	}
+}
+
+// flushResourceChanges 发送 list_changed 与各订阅者的 updated 通知
+func (m *MCPServer) flushResourceChanges(added bool, ids []int) {
+	if added {
+		m.NotifyAll("notifications/resources/list_changed", nil)
+	}
+	for _, session := range m.allSessions() {
+		for _, uri := range session.Subscriptions() {
+			if uri == mcpCaptureListURI {
+				m.Notify(session, "notifications/resources/updated", map[string]interface{}{"uri": uri})
+				continue
+			}
+			theology, _, err := parseResourceURI(uri)
+			if err != nil {
+				continue
+			}
+			for _, id := range ids {
+				if id == theology {
+					m.Notify(session, "notifications/resources/updated", map[string]interface{}{"uri": uri})
+					break
+				}
+			}
+		}
+	}
+}
+
+// validSubscriptionURI 判断URI是否可以订阅
+func validSubscriptionURI(uri string) bool {
+	if uri == mcpCaptureListURI {
+		return true
+	}
+	if !strings.HasPrefix(uri, mcpResourceScheme) {
+		return false
+	}
+	_, _, err := parseResourceURI(uri)
+	return err == nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MCPToolAnnotations 工具行为提示（tools/list 中返回）
+type MCPToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    bool   `json:"readOnlyHint"`    // 只读取数据，不改变任何状态
+	DestructiveHint bool   `json:"destructiveHint"` // 可能中断或修改实时流量、删除数据或改变系统状态
+}
+
+// 工具策略
+const (
+	toolPolicyAllow   = "allow"   // 直接执行
+	toolPolicyDeny    = "deny"    // 禁止执行
+	toolPolicyConfirm = "confirm" // 每次执行前需要用户确认
+)
+
+// 等待用户确认的最长时间，超时按拒绝处理
+const toolConfirmTimeout = 2 * time.Minute
+
+// 只读的内置工具
+var readOnlyTools = map[string]bool{
+	"proxy_get_status":   true,
+	"request_list":       true,
+	"request_get":        true,
+	"request_search":     true,
+	"process_list":       true,
+	"config_get":         true,
+	"decrypt_packet":     true,
+	"parse_protobuf":     true,
+	"crypto_config_get":  true,
+	"crypto_config_list": true,
+	"decrypt_tcp_flow":   true,
+	"replace_rules_list": true,
+	"audit_log_query":    true,
+	"profile_export":     true,
+	"har_export":         true,
+	"pcap_export":        true,
+}
+
+// 会中断或修改实时流量、删除规则或改变系统状态的内置工具
+var destructiveTools = map[string]bool{
+	"proxy_stop":             true,
+	"proxy_set_port":         true,
+	"request_modify_header":  true,
+	"request_modify_body":    true,
+	"response_modify_header": true,
+	"response_modify_body":   true,
+	"request_block":          true,
+	"request_release_all":    true,
+	"cert_install":           true,
+	"process_remove_name":    true,
+	"replace_rules_remove":   true,
+	"replace_rules_clear":    true,
+	"profile_apply":          true,
+	"capture_set_limits":     true,
+	"session_replay":         true,
+	"har_import":             true,
+}
+
+// builtinToolAnnotations 内置工具的行为提示
+func builtinToolAnnotations(name string) *MCPToolAnnotations {
+	if readOnlyTools[name] {
+		return &MCPToolAnnotations{ReadOnlyHint: true}
+	}
+	return &MCPToolAnnotations{DestructiveHint: destructiveTools[name]}
+}
+
+// defaultToolAnnotations 未声明行为提示的动态工具按可能有破坏性处理
+func defaultToolAnnotations() *MCPToolAnnotations {
+	return &MCPToolAnnotations{DestructiveHint: true}
+}
+
+// toolPolicyOf 返回工具的执行策略：配置中单独设置的策略优先，其次是只读模式
+func toolPolicyOf(tool *MCPTool) string {
+	configLock.Lock()
+	policy := GlobalConfig.MCP.ToolPolicy[tool.Name]
+	readOnly := GlobalConfig.MCP.ReadOnly
+	configLock.Unlock()
+	switch policy {
+	case toolPolicyAllow, toolPolicyDeny, toolPolicyConfirm:
+		return policy
+	}
+	if readOnly && (tool.Annotations == nil || !tool.Annotations.ReadOnlyHint) {
+		return toolPolicyDeny
+	}
+	return toolPolicyAllow
+}
+
+// enforceToolPolicy 执行前检查工具策略，需要确认时向用户确认
+// 返回的上下文标记已确认，自定义工具内部再调用其他工具时不重复确认
+func enforceToolPolicy(ctx context.Context, tool *MCPTool, args map[string]interface{}) (context.Context, error) {
+	switch toolPolicyOf(tool) {
+	case toolPolicyDeny:
+		return ctx, fmt.Errorf("工具 %s 已被策略禁止调用", tool.Name)
+	case toolPolicyConfirm:
+		if confirmed, _ := ctx.Value(toolConfirmedKey).(bool); confirmed {
+			return ctx, nil
+		}
+		if err := confirmToolCall(ctx, tool, args); err != nil {
+			return ctx, err
+		}
+		return context.WithValue(ctx, toolConfirmedKey, true), nil
+	}
+	return ctx, nil
+}
+
+// confirmToolCall 请求用户确认：客户端支持 elicitation 时由客户端询问，否则在界面中弹出确认框
+func confirmToolCall(ctx context.Context, tool *MCPTool, args map[string]interface{}) error {
+	argsJSON, _ := json.Marshal(args)
+	message := fmt.Sprintf("AI 请求调用工具 %s\n%s\n参数: %s", tool.Name, tool.Description, argsJSON)
+
+	session := sessionFromContext(ctx)
+	if mcpServer != nil && session != nil && session.SupportsElicitation() {
+		allow, err := mcpServer.elicitConfirmation(ctx, message)
+		if err == nil {
+			if !allow {
+				return fmt.Errorf("用户拒绝了工具 %s 的调用", tool.Name)
+			}
+			return nil
+		}
+		if !errors.Is(err, errNoClientChannel) {
+			return err
+		}
+	}
+	if app == nil || app.ctx == nil {
+		return fmt.Errorf("工具 %s 需要用户确认，但客户端不支持 elicitation 且没有可用的界面", tool.Name)
+	}
+	allow, err := guiConfirmToolCall(ctx, tool.Name, string(argsJSON), message)
+	if err != nil {
+		return err
+	}
+	if !allow {
+		return fmt.Errorf("用户拒绝了工具 %s 的调用", tool.Name)
+	}
+	return nil
+}
+
+// ============ MCP elicitation ============
+
+// errNoClientChannel 当前请求没有可以向客户端发送请求的通道
+var errNoClientChannel = errors.New("没有可用的客户端通道")
+
+// clientSender 向客户端发送服务端请求，发送失败返回false
+type clientSender func(message []byte) bool
+
+// withClientSender 设置请求处理期间向客户端发送服务端请求的方式
+func withClientSender(ctx context.Context, send clientSender) context.Context {
+	return context.WithValue(ctx, clientSenderKey, send)
+}
+
+// pendingClientRequest 等待客户端响应的服务端请求，只接受发出请求的会话的响应
+type pendingClientRequest struct {
+	ch      chan parsedMessage
+	session string
+}
+
+// pendingClientRequests 等待客户端响应的服务端请求
+var (
+	pendingClientRequests   = make(map[string]*pendingClientRequest)
+	pendingClientRequestsMu sync.Mutex
+	clientRequestSeq        int64
+)
+
+// sessionIDOf 会话ID，无会话（stdio 等）时为空
+func sessionIDOf(session *MCPSession) string {
+	if session == nil {
+		return ""
+	}
+	return session.ID
+}
+
+// requestClient 向客户端发送请求并等待响应
+func (m *MCPServer) requestClient(ctx context.Context, method string, params interface{}) (parsedMessage, error) {
+	send, _ := ctx.Value(clientSenderKey).(clientSender)
+	if send == nil {
+		return parsedMessage{}, errNoClientChannel
+	}
+	id := fmt.Sprintf("sunnynet-%d", atomic.AddInt64(&clientRequestSeq, 1))
+	ch := make(chan parsedMessage, 1)
+	pendingClientRequestsMu.Lock()
+	pendingClientRequests[id] = &pendingClientRequest{ch: ch, session: sessionIDOf(sessionFromContext(ctx))}
+	pendingClientRequestsMu.Unlock()
+	defer func() {
+		pendingClientRequestsMu.Lock()
+		delete(pendingClientRequests, id)
+		pendingClientRequestsMu.Unlock()
+	}()
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if !send(data) {
+		return parsedMessage{}, errNoClientChannel
+	}
+	timer := time.NewTimer(toolConfirmTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return parsedMessage{}, ctx.Err()
+	case <-timer.C:
+		return parsedMessage{}, errors.New("等待客户端响应超时")
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp, fmt.Errorf("客户端返回错误: %s", resp.Error.Message)
+		}
+		return resp, nil
+	}
+}
+
+// deliverClientResponse 把客户端响应交给等待中的请求，没有对应请求或响应来自其他会话时忽略
+func deliverClientResponse(session *MCPSession, msg parsedMessage) {
+	id, ok := msg.Request.ID.(string)
+	if !ok {
+		return
+	}
+	pendingClientRequestsMu.Lock()
+	p := pendingClientRequests[id]
+	pendingClientRequestsMu.Unlock()
+	if p == nil || p.session != sessionIDOf(session) {
+		return
+	}
+	select {
+	case p.ch <- msg:
+	default:
+	}
+}
+
+// elicitConfirmation 通过 elicitation/create 让用户确认
+func (m *MCPServer) elicitConfirmation(ctx context.Context, message string) (bool, error) {
+	resp, err := m.requestClient(ctx, "elicitation/create", map[string]interface{}{
+		"message": message,
+		"requestedSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"confirm": map[string]interface{}{
+					"type":        "boolean",
+					"title":       "允许执行",
+					"description": "是否允许执行该工具",
+				},
+			},
+			"required": []string{"confirm"},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	var result struct {
+		Action  string                 `json:"action"`
+		Content map[string]interface{} `json:"content"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return false, fmt.Errorf("无效的 elicitation 响应: %v", err)
+	}
+	confirm, _ := result.Content["confirm"].(bool)
+	return result.Action == "accept" && confirm, nil
+}
+
+// ============ 界面确认 ============
+
+// ToolConfirmRequest 发送给界面的确认请求
+type ToolConfirmRequest struct {
+	Id      int    `json:"id"`
+	Tool    string `json:"tool"`
+	Args    string `json:"args"`
+	Message string `json:"msg"`
+}
+
+var (
+	pendingToolConfirms   = make(map[int]chan bool)
+	pendingToolConfirmsMu sync.Mutex
+	toolConfirmSeq        int
+)
+
+// guiConfirmToolCall 在界面中弹出确认框并等待结果
+func guiConfirmToolCall(ctx context.Context, name, args, message string) (bool, error) {
+	ch := make(chan bool, 1)
+	pendingToolConfirmsMu.Lock()
+	toolConfirmSeq++
+	id := toolConfirmSeq
+	pendingToolConfirms[id] = ch
+	pendingToolConfirmsMu.Unlock()
+	defer func() {
+		pendingToolConfirmsMu.Lock()
+		delete(pendingToolConfirms, id)
+		pendingToolConfirmsMu.Unlock()
+	}()
+
+	CallJs("MCP工具确认", &ToolConfirmRequest{Id: id, Tool: name, Args: args, Message: message})
+	timer := time.NewTimer(toolConfirmTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-timer.C:
+		return false, fmt.Errorf("等待用户确认工具 %s 超时", name)
+	case allow := <-ch:
+		return allow, nil
+	}
+}
+
+// ResolveToolConfirmation 界面返回确认结果
+func ResolveToolConfirmation(id int, allow bool) bool {
+	pendingToolConfirmsMu.Lock()
+	ch := pendingToolConfirms[id]
+	pendingToolConfirmsMu.Unlock()
+	if ch == nil {
+		return false
+	}
+	select {
+	case ch <- allow:
+	default:
+	}
+	return true
+}
+
+// toolsNeedConfirmation 批量消息中是否有需要确认的工具调用
+func toolsNeedConfirmation(messages []parsedMessage) bool {
+	for _, msg := range messages {
+		if msg.Invalid != nil || msg.Notification || msg.IsResponse || msg.Request.Method != "tools/call" {
+			continue
+		}
+		name, _ := msg.Request.Params["name"].(string)
+		if tool := FindTool(name); tool != nil && toolPolicyOf(tool) == toolPolicyConfirm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type mcpContextKey int
+
+const (
+	notifySinkKey      mcpContextKey = iota // 当前请求的通知发送通道
+	progressKey                             // 当前请求的进度汇报
+	sessionKey                              // 当前请求所属的会话
+	clientSenderKey                         // 向客户端发送服务端请求的通道
+	toolConfirmedKey                        // 用户已确认本次工具调用
+	protocolVersionKey                      // 无会话请求在请求头中声明的协议版本
+)
+
+// notifySink 发送一条已序列化的通知
+type notifySink func(message []byte)
+
+// progressReporter 进度汇报（同一进度值只发送一次）
+type progressReporter struct {
+	token interface{}
+	send  notifySink
+	last  float64
+	mu    sync.Mutex
+}
+
+// withNotifySink 设置请求处理期间通知的发送方式
+func withNotifySink(ctx context.Context, sink notifySink) context.Context {
+	return context.WithValue(ctx, notifySinkKey, sink)
+}
+
+// withProgress 调用方提供了 progressToken 时启用进度汇报
+func withProgress(ctx context.Context, token interface{}) context.Context {
+	if token == nil {
+		return ctx
+	}
+	sink, _ := ctx.Value(notifySinkKey).(notifySink)
+	if sink == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey, &progressReporter{token: token, send: sink, last: -1})
+}
+
+// ReportProgress 向调用方汇报进度，调用方没有提供 progressToken 时忽略
+func ReportProgress(ctx context.Context, progress, total float64, message string) {
+	if ctx == nil {
+		return
+	}
+	p, _ := ctx.Value(progressKey).(*progressReporter)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	// 进度必须递增
+	if progress <= p.last {
+		p.mu.Unlock()
+		return
+	}
+	p.last = progress
+	p.mu.Unlock()
+
+	params := map[string]interface{}{
+		"progressToken": p.token,
+		"progress":      progress,
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	if message != "" && versionSupports(protocolVersionOf(ctx), featureProgressMessage) {
+		params["message"] = message
+	}
+	p.send(newNotification("notifications/progress", params))
+}
+
+// progressInterval 异步进度汇报的最小间隔
+const progressInterval = 200 * time.Millisecond
+
+// asyncProgress 进度先放入队列，由单独的 goroutine 限速发送，汇报方（如持有抓包锁的搜索）不会被慢客户端阻塞；
+// 队列中只保留最新的进度。stop 在汇报结束后调用，等待最后一次进度发出
+func asyncProgress(ctx context.Context, total float64) (report func(progress float64), stop func()) {
+	if ctx == nil || ctx.Value(progressKey) == nil {
+		return func(float64) {}, func() {}
+	}
+	queue := make(chan float64, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for progress := range queue {
+			ReportProgress(ctx, progress, total, "")
+			select {
+			case <-ctx.Done():
+			case <-time.After(progressInterval):
+			}
+		}
+	}()
+	report = func(progress float64) {
+		for {
+			select {
+			case queue <- progress:
+				return
+			default:
+			}
+			// 丢弃尚未发出的旧进度
+			select {
+			case <-queue:
+			default:
+			}
+		}
+	}
+	stop = func() {
+		close(queue)
+		<-done
+	}
+	return report, stop
+}
+
+// progressTokenOf 读取请求参数 _meta.progressToken
+func progressTokenOf(params map[string]interface{}) interface{} {
+	meta, _ := params["_meta"].(map[string]interface{})
+	if meta == nil {
+		return nil
+	}
+	switch token := meta["progressToken"].(type) {
+	case string, float64:
+		return token
+	}
+	return nil
+}
+
+// inflightKey 进行中请求的索引（同一会话内请求ID唯一）
+func inflightKey(session *MCPSession, id interface{}) string {
+	return fmt.Sprintf("%s|%#v", session.ID, id)
+}
+
+// inflightRequest 进行中的请求
+type inflightRequest struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// trackRequest 登记进行中的请求，返回可被 notifications/cancelled 取消的上下文
+// 无会话的请求无法区分调用方，不登记也不可取消
+func (m *MCPServer) trackRequest(ctx context.Context, session *MCPSession, id interface{}) (context.Context, string) {
+	if session == nil {
+		return ctx, ""
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	key := inflightKey(session, id)
+	m.inflightMu.Lock()
+	m.inflight[key] = &inflightRequest{cancel: cancel}
+	m.inflightMu.Unlock()
+	return ctx, key
+}
+
+// untrackRequest 请求处理完成，返回该请求是否已被客户端取消
+func (m *MCPServer) untrackRequest(key string) bool {
+	if key == "" {
+		return false
+	}
+	m.inflightMu.Lock()
+	r := m.inflight[key]
+	delete(m.inflight, key)
+	m.inflightMu.Unlock()
+	if r == nil {
+		return false
+	}
+	r.cancel()
+	return r.cancelled
+}
+
+// cancelRequest 处理 notifications/cancelled
+func (m *MCPServer) cancelRequest(session *MCPSession, id interface{}) bool {
+	if session == nil {
+		return false
+	}
+	m.inflightMu.Lock()
+	defer m.inflightMu.Unlock()
+	r := m.inflight[inflightKey(session, id)]
+	if r == nil {
+		return false
+	}
+	r.cancelled = true
+	r.cancel()
+	return true
+}
+
+// hasCancellation 是否包含取消通知
+func hasCancellation(messages []parsedMessage) bool {
+	for _, msg := range messages {
+		if msg.Invalid == nil && msg.Notification && msg.Request.Method == "notifications/cancelled" {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsProgress 是否有请求需要进度通知
+func wantsProgress(messages []parsedMessage) bool {
+	for _, msg := range messages {
+		if msg.Invalid == nil && !msg.Notification && !msg.IsResponse && progressTokenOf(msg.Request.Params) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// postEventStream 以SSE形式返回POST请求的结果（先推送进度通知，最后是响应）
+type postEventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+}
+
+func newPostEventStream(w http.ResponseWriter) *postEventStream {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	// 长时间运行的工具可能超过服务器写超时
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &postEventStream{w: w, flusher: flusher}
+}
+
+// send 发送一条SSE消息
+func (s *postEventStream) send(message []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "event: message\ndata: %s\n\n", message)
+	s.flusher.Flush()
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"changeme/MapHash"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 提示词中单个Body最多展示的字节数
+const mcpPromptBodyLimit = 4096
+
+// 登录流程提示词最多列出的会话数量
+const mcpPromptFlowLimit = 50
+
+// 请求的提示词不存在
+var errPromptNotFound = errors.New("提示词不存在")
+
+// MCPPrompt MCP提示词定义
+type MCPPrompt struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Arguments   []MCPPromptArgument `json:"arguments,omitempty"`
+}
+
+// MCPPromptArgument 提示词参数
+type MCPPromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// MCPPromptMessage 提示词消息
+type MCPPromptMessage struct {
+	Role    string     `json:"role"`
+	Content MCPContent `json:"content"`
+}
+
+// MCPPromptsListResult prompts/list 结果
+type MCPPromptsListResult struct {
+	Prompts []MCPPrompt `json:"prompts"`
+}
+
+// MCPPromptsGetResult prompts/get 结果
+type MCPPromptsGetResult struct {
+	Description string             `json:"description,omitempty"`
+	Messages    []MCPPromptMessage `json:"messages"`
+}
+
+// builtinPrompt 内置提示词（render 从抓包数据中生成内容）
+type builtinPrompt struct {
+	MCPPrompt
+	render func(args map[string]string) (string, error)
+}
+
+var builtinPrompts = []builtinPrompt{
+	{
+		MCPPrompt: MCPPrompt{
+			Name:        "explain_login_flow",
+			Description: "分析指定主机的登录流程（按时间顺序列出该主机的会话，登录相关请求附带完整报文）",
+			Arguments: []MCPPromptArgument{
+				{Name: "host", Description: "主机名，例如 api.example.com", Required: true},
+			},
+		},
+		render: renderLoginFlowPrompt,
+	},
+	{
+		MCPPrompt: MCPPrompt{
+			Name:        "find_auth_tokens",
+			Description: "在指定会话中查找认证凭据（Token、Cookie、签名等）",
+			Arguments: []MCPPromptArgument{
+				{Name: "theology", Description: "请求唯一ID", Required: true},
+			},
+		},
+		render: renderAuthTokensPrompt,
+	},
+	{
+		MCPPrompt: MCPPrompt{
+			Name:        "decode_tcp_session",
+			Description: "使用指定的加密配置解密TCP会话并解读协议",
+			Arguments: []MCPPromptArgument{
+				{Name: "theology", Description: "TCP会话的请求唯一ID", Required: true},
+				{Name: "crypto_config", Description: "加密配置名称，不填则使用当前配置"},
+			},
+		},
+		render: renderDecodeTcpPrompt,
+	},
+}
+
+// 看起来与登录相关的路径
+var loginPathRegexp = regexp.MustCompile(`(?i)login|logon|signin|sign_in|auth|token|oauth|passport|session|account|verify|captcha|sso`)
+
+// GetPromptsList 获取内置与用户自定义的提示词列表
+func GetPromptsList() []MCPPrompt {
+	list := builtinPromptDefinitions()
+	for _, t := range userPromptTemplates() {
+		if findBuiltinPrompt(t.Name) != nil {
+			continue
+		}
+		list = append(list, userPromptDefinition(t))
+	}
+	return list
+}
+
+// GetPrompt 根据名称与参数生成提示词
+func GetPrompt(name string, args map[string]string) (*MCPPromptsGetResult, error) {
+	var def MCPPrompt
+	var render func(map[string]string) (string, error)
+	if p := findBuiltinPrompt(name); p != nil {
+		def, render = p.MCPPrompt, p.render
+	} else if t := findUserPrompt(name); t != nil {
+		tpl := *t
+		def = userPromptDefinition(tpl)
+		render = func(a map[string]string) (string, error) {
+			return renderUserPrompt(tpl, a)
+		}
+	} else {
+		return nil, errPromptNotFound
+	}
+
+	for _, arg := range def.Arguments {
+		if arg.Required && strings.TrimSpace(args[arg.Name]) == "" {
+			return nil, fmt.Errorf("缺少必填参数: %s", arg.Name)
+		}
+	}
+	text, err := render(args)
+	if err != nil {
+		return nil, err
+	}
+	return &MCPPromptsGetResult{
+		Description: def.Description,
+		Messages: []MCPPromptMessage{
+			{Role: "user", Content: MCPContent{Type: "text", Text: text}},
+		},
+	}, nil
+}
+
+// notifyPromptsChanged 自定义提示词变化后通知客户端重新获取列表
+func notifyPromptsChanged() {
+	if mcpServer == nil || !mcpServer.IsRunning() {
+		return
+	}
+	mcpServer.NotifyAll("notifications/prompts/list_changed", nil)
+}
+
+// builtinPromptDefinitions 内置提示词定义
+func builtinPromptDefinitions() []MCPPrompt {
+	list := make([]MCPPrompt, 0, len(builtinPrompts))
+	for _, p := range builtinPrompts {
+		list = append(list, p.MCPPrompt)
+	}
+	return list
+}
+
+func findBuiltinPrompt(name string) *builtinPrompt {
+	for i := range builtinPrompts {
+		if builtinPrompts[i].Name == name {
+			return &builtinPrompts[i]
+		}
+	}
+	return nil
+}
+
+// userPromptTemplates 读取用户自定义的提示词模板
+func userPromptTemplates() []ConfigPromptTemplate {
+	configLock.Lock()
+	defer configLock.Unlock()
+	list := make([]ConfigPromptTemplate, len(GlobalConfig.PromptTemplates))
+	copy(list, GlobalConfig.PromptTemplates)
+	return list
+}
+
+func findUserPrompt(name string) *ConfigPromptTemplate {
+	for _, t := range userPromptTemplates() {
+		if t.Name == name {
+			return &t
+		}
+	}
+	return nil
+}
+
+func userPromptDefinition(t ConfigPromptTemplate) MCPPrompt {
+	p := MCPPrompt{Name: t.Name, Description: t.Description}
+	for _, a := range t.Arguments {
+		p.Arguments = append(p.Arguments, MCPPromptArgument{Name: a.Name, Description: a.Description, Required: a.Required})
+	}
+	return p
+}
+
+// 用户模板占位符：{{参数}} 原样替换，{{flow:参数}} 替换为该ID的会话内容，{{host:参数}} 替换为该主机的会话列表
+var promptPlaceholderRegexp = regexp.MustCompile(`\{\{\s*(?:(flow|host):)?\s*([^{}\s]+)\s*\}\}`)
+
+// renderUserPrompt 填充用户自定义模板
+func renderUserPrompt(t ConfigPromptTemplate, args map[string]string) (string, error) {
+	var renderErr error
+	text := promptPlaceholderRegexp.ReplaceAllStringFunc(t.Template, func(s string) string {
+		m := promptPlaceholderRegexp.FindStringSubmatch(s)
+		value := args[m[2]]
+		switch m[1] {
+		case "flow":
+			theology, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				renderErr = fmt.Errorf("参数 %s 不是有效的请求ID", m[2])
+				return s
+			}
+			h := HashMap.GetRequest(theology)
+			defer HashMap.Release(h)
+			if h == nil {
+				renderErr = fmt.Errorf("请求 %d 不存在", theology)
+				return s
+			}
+			return promptFlowText(theology, h, true)
+		case "host":
+			return promptHostFlowsText(value, false)
+		}
+		return value
+	})
+	return text, renderErr
+}
+
+// renderLoginFlowPrompt 登录流程分析
+func renderLoginFlowPrompt(args map[string]string) (string, error) {
+	host := strings.TrimSpace(args["host"])
+	flows := promptHostFlowsText(host, true)
+	if flows == "" {
+		return "", fmt.Errorf("没有捕获到主机 %s 的请求", host)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "下面是 SunnyNet 抓取到的主机 %s 的请求（按时间顺序）。请解释该主机的登录流程：\n", host)
+	b.WriteString("1. 登录涉及哪些请求，各自的作用与先后关系；\n")
+	b.WriteString("2. 提交了哪些凭据/参数，是否有加密或签名，签名可能如何生成；\n")
+	b.WriteString("3. 服务端下发了哪些会话凭据（Cookie、Token 等），后续请求如何携带；\n")
+	b.WriteString("4. 可能存在的安全问题。\n\n")
+	b.WriteString(flows)
+	return b.String(), nil
+}
+
+// renderAuthTokensPrompt 认证凭据查找
+func renderAuthTokensPrompt(args map[string]string) (string, error) {
+	theology, err := strconv.Atoi(strings.TrimSpace(args["theology"]))
+	if err != nil {
+		return "", errors.New("theology 必须是数字")
+	}
+	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
+	if h == nil {
+		return "", fmt.Errorf("请求 %d 不存在", theology)
+	}
+	var b strings.Builder
+	b.WriteString("请在下面这个会话中找出所有认证相关的凭据：Authorization 头、Cookie/Set-Cookie、Token、Session ID、API Key、签名参数等。")
+	b.WriteString("对每一项说明所在位置（请求/响应、头/参数/Body）、可能的格式（JWT、随机串、签名等），能解码的（如JWT）请解码说明其中的字段与过期时间。\n\n")
+	b.WriteString(promptFlowText(theology, h, true))
+	return b.String(), nil
+}
+
+// renderDecodeTcpPrompt TCP会话解密分析
+func renderDecodeTcpPrompt(args map[string]string) (string, error) {
+	theology, err := strconv.Atoi(strings.TrimSpace(args["theology"]))
+	if err != nil {
+		return "", errors.New("theology 必须是数字")
+	}
+	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
+	if h == nil {
+		return "", fmt.Errorf("请求 %d 不存在", theology)
+	}
+	if cryptoAnalyzer == nil {
+		return "", errors.New("加密分析器未初始化")
+	}
+	var config *CryptoConfig
+	if name := strings.TrimSpace(args["crypto_config"]); name != "" {
+		config = cryptoAnalyzer.GetConfig(name)
+		if config == nil {
+			return "", fmt.Errorf("加密配置 '%s' 不存在", name)
+		}
+	} else {
+		config = cryptoAnalyzer.GetCurrentConfig()
+		if config == nil {
+			return "", errors.New("未选择加密配置")
+		}
+	}
+	// 使用独立的分析器，避免修改全局的当前配置
+	analyzer := NewCryptoAnalyzer()
+	analyzer.AddConfig(config)
+	_ = analyzer.SetCurrentConfig(config.Name)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "下面是 SunnyNet 抓取的 TCP 会话 #%d（%s），已使用加密配置「%s」（AES-CBC，头部 %d 字节）解密。", theology, h.URL, config.Name, config.HeaderSize)
+	b.WriteString("请根据消息ID、解密后的数据与Protobuf结构解读该协议：每种消息的含义、字段含义、请求与响应的对应关系；解密失败的包请分析可能的原因。\n\n")
+	count := 0
+	for i, sd := range h.SocketData {
+		if sd == nil || sd.Info == nil || len(sd.Body) == 0 {
+			continue
+		}
+		count++
+		fmt.Fprintf(&b, "--- 包 %d [%s] %s 长度 %d ---\n", i, sd.Info.Ico, sd.Info.Time, len(sd.Body))
+		packet, e := analyzer.ParsePacket(sd.Body)
+		if e != nil {
+			fmt.Fprintf(&b, "解析失败: %v\n原始数据: %s\n\n", e, truncateText(bytesToHexString(sd.Body), mcpPromptBodyLimit))
+			continue
+		}
+		fmt.Fprintf(&b, "消息ID: %d", packet.Header.MsgID)
+		if packet.Header.MsgName != "" {
+			fmt.Fprintf(&b, "（%s）", packet.Header.MsgName)
+		}
+		fmt.Fprintf(&b, " Seq: %d/%d\n", packet.Header.Seq1, packet.Header.Seq2)
+		if packet.Error != "" {
+			fmt.Fprintf(&b, "错误: %s\n", packet.Error)
+		}
+		fmt.Fprintf(&b, "解密数据: %s\n", truncateText(packet.DecryptedHex, mcpPromptBodyLimit))
+		if packet.ProtobufTree != "" {
+			fmt.Fprintf(&b, "Protobuf:\n%s\n", truncateText(packet.ProtobufTree, mcpPromptBodyLimit))
+		}
+		b.WriteString("\n")
+	}
+	if count == 0 {
+		return "", fmt.Errorf("请求 %d 没有捕获到Socket数据", theology)
+	}
+	return b.String(), nil
+}
+
+// promptHostFlowsText 列出某主机的会话，loginDetail 为 true 时登录相关的会话附带完整报文
+func promptHostFlowsText(host string, loginDetail bool) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return ""
+	}
+	var keys []int
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if h != nil && h.Display && strings.Contains(strings.ToLower(requestHostOf(h.URL)), host) {
+			keys = append(keys, theology)
+		}
+	})
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Ints(keys)
+	if len(keys) > mcpPromptFlowLimit {
+		keys = keys[len(keys)-mcpPromptFlowLimit:]
+	}
+	var b strings.Builder
+	for _, theology := range keys {
+		h := HashMap.GetRequest(theology)
+		if h == nil {
+			continue
+		}
+		if loginDetail && loginPathRegexp.MatchString(requestPathOf(h.URL)) {
+			b.WriteString(promptFlowText(theology, h, true))
+		} else {
+			fmt.Fprintf(&b, "#%d %s %s -> %d\n", theology, h.Method, h.URL, h.Response.StateCode)
+		}
+		HashMap.Release(h)
+	}
+	return b.String()
+}
+
+// promptFlowText 会话的文本形式（请求/响应头与Body，Socket会话为数据帧）
+func promptFlowText(theology int, h *MapHash.Request, withBody bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "===== #%d %s %s =====\n", theology, h.Method, h.URL)
+	if isSocketRequest(h) {
+		fmt.Fprintf(&b, "类型: %s 发送 %d 字节 接收 %d 字节\n", h.Way, h.SendNum, h.RecNum)
+		for i, sd := range h.SocketData {
+			if sd == nil || sd.Info == nil {
+				continue
+			}
+			fmt.Fprintf(&b, "[%d %s %s] %s\n", i, sd.Info.Ico, sd.Info.Time, promptBodyText(sd.Body))
+		}
+		b.WriteString("\n")
+		return b.String()
+	}
+	b.WriteString("-- 请求头 --\n")
+	writePromptHeaders(&b, h.Header)
+	if withBody && len(h.Body) > 0 {
+		fmt.Fprintf(&b, "-- 请求Body --\n%s\n", promptBodyText(h.Body))
+	}
+	fmt.Fprintf(&b, "-- 响应 %d --\n", h.Response.StateCode)
+	writePromptHeaders(&b, h.Response.Header)
+	if withBody && len(h.Response.Body) > 0 {
+		fmt.Fprintf(&b, "-- 响应Body --\n%s\n", promptBodyText(h.Response.Body))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func writePromptHeaders(b *strings.Builder, header map[string][]string) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(b, "%s: %s\n", k, v)
+		}
+	}
+}
+
+// promptBodyText Body的文本形式（非UTF8时使用HEX）
+func promptBodyText(body []byte) string {
+	if len(body) > mcpPromptBodyLimit {
+		if utf8.Valid(body[:mcpPromptBodyLimit]) {
+			return string(body[:mcpPromptBodyLimit]) + fmt.Sprintf("...(共 %d 字节，已截断)", len(body))
+		}
+		return bytesToHexString(body[:mcpPromptBodyLimit]) + fmt.Sprintf("...(共 %d 字节，已截断)", len(body))
+	}
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return "HEX: " + bytesToHexString(body)
+}
+
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...(已截断)"
+}
+
+// requestHostOf 从请求地址中取主机名（兼容Socket会话的 host:port 形式）
+func requestHostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return rawURL
+}
+
+func requestPathOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Path
+	}
+	return rawURL
+}
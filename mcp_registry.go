@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MCPToolHandler 工具的执行函数
+type MCPToolHandler func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+
+// 工具来源
+const (
+	toolSourceBuiltin = "builtin" // 内置工具，不能被覆盖或删除
+	toolSourceScript  = "script"  // Go脚本中通过 RegisterMCPTool 注册
+	toolSourceConfig  = "config"  // 用户配置中的自定义工具
+	toolSourceRuntime = "runtime" // 运行时通过 RegisterTool 注册
+)
+
+// registeredTool 注册表中的工具
+type registeredTool struct {
+	Tool    MCPTool
+	Handler MCPToolHandler
+	Source  string
+}
+
+// ToolArgumentError 工具不存在或参数不符合 inputSchema（协议错误 -32602）
+type ToolArgumentError struct {
+	Tool    string
+	Message string
+}
+
+func (e *ToolArgumentError) Error() string {
+	return e.Message
+}
+
+// toolRegistry 工具注册表，保持注册顺序
+type toolRegistry struct {
+	tools map[string]*registeredTool
+	order []string
+	mu    sync.RWMutex
+}
+
+var (
+	mcpTools        = &toolRegistry{tools: make(map[string]*registeredTool)}
+	mcpToolsOnce    sync.Once
+	configToolsOnce sync.Once
+)
+
+// registry 返回已注册内置工具的注册表
+func registry() *toolRegistry {
+	mcpToolsOnce.Do(func() {
+		for _, t := range buildToolsList() {
+			name := t.Name
+			t.Annotations = builtinToolAnnotations(name)
+			mcpTools.set(&registeredTool{Tool: t, Source: toolSourceBuiltin, Handler: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+				return callBuiltinTool(ctx, name, args)
+			}})
+		}
+	})
+	return mcpTools
+}
+
+// loadedRegistry 返回同时加载了配置中自定义工具的注册表
+// 配置在 configLock 下加载时会执行Go脚本（脚本可能注册工具），所以自定义工具延迟到首次查询时加载
+func loadedRegistry() *toolRegistry {
+	r := registry()
+	configToolsOnce.Do(func() {
+		reloadConfigTools()
+	})
+	return r
+}
+
+// set 添加或替换工具（调用方负责加锁或在初始化阶段调用）
+func (r *toolRegistry) set(t *registeredTool) {
+	if _, ok := r.tools[t.Tool.Name]; !ok {
+		r.order = append(r.order, t.Tool.Name)
+	}
+	r.tools[t.Tool.Name] = t
+}
+
+// remove 删除工具（调用方负责加锁）
+func (r *toolRegistry) remove(name string) {
+	delete(r.tools, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// checkName 检查名称是否可以由该来源注册（调用方负责加锁）
+func (r *toolRegistry) checkName(name, source string) error {
+	if name == "" {
+		return errors.New("工具名称不能为空")
+	}
+	if strings.ContainsAny(name, " \t\r\n") {
+		return errors.New("工具名称不能包含空白字符")
+	}
+	if old := r.tools[name]; old != nil && old.Source != source {
+		return fmt.Errorf("工具 %s 已存在（来源: %s）", name, old.Source)
+	}
+	return nil
+}
+
+// RegisterTool 在运行时注册工具，已有同来源的同名工具时替换
+func RegisterTool(tool MCPTool, source string, handler MCPToolHandler) error {
+	if handler == nil {
+		return errors.New("工具处理函数不能为空")
+	}
+	if source == "" || source == toolSourceBuiltin {
+		source = toolSourceRuntime
+	}
+	if tool.InputSchema == nil {
+		tool.InputSchema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	if tool.Annotations == nil {
+		tool.Annotations = defaultToolAnnotations()
+	}
+	r := registry()
+	r.mu.Lock()
+	if err := r.checkName(tool.Name, source); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.set(&registeredTool{Tool: tool, Handler: handler, Source: source})
+	r.mu.Unlock()
+	notifyToolsChanged()
+	return nil
+}
+
+// UnregisterTool 删除运行时注册的工具，内置工具不能删除
+func UnregisterTool(name string) bool {
+	r := registry()
+	r.mu.Lock()
+	t := r.tools[name]
+	if t == nil || t.Source == toolSourceBuiltin {
+		r.mu.Unlock()
+		return false
+	}
+	r.remove(name)
+	r.mu.Unlock()
+	notifyToolsChanged()
+	return true
+}
+
+// replaceToolsBySource 用新列表替换某个来源的全部工具（与已有其他来源重名的工具被跳过）
+func replaceToolsBySource(source string, tools []*registeredTool) []string {
+	r := registry()
+	skipped := make([]string, 0)
+	r.mu.Lock()
+	changed := false
+	for _, name := range append([]string(nil), r.order...) {
+		if r.tools[name].Source == source {
+			r.remove(name)
+			changed = true
+		}
+	}
+	for _, t := range tools {
+		if err := r.checkName(t.Tool.Name, source); err != nil {
+			skipped = append(skipped, t.Tool.Name)
+			continue
+		}
+		t.Source = source
+		r.set(t)
+		changed = true
+	}
+	r.mu.Unlock()
+	if changed {
+		notifyToolsChanged()
+	}
+	return skipped
+}
+
+// GetToolsList 返回当前所有MCP工具定义
+func GetToolsList() []MCPTool {
+	r := loadedRegistry()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]MCPTool, 0, len(r.order))
+	for _, name := range r.order {
+		list = append(list, r.tools[name].Tool)
+	}
+	return list
+}
+
+// FindTool 根据名称查找工具定义
+func FindTool(name string) *MCPTool {
+	r := loadedRegistry()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t := r.tools[name]; t != nil {
+		tool := t.Tool
+		return &tool
+	}
+	return nil
+}
+
+// toolSourceOf 返回工具来源，工具不存在时返回空
+func toolSourceOf(name string) string {
+	r := loadedRegistry()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t := r.tools[name]; t != nil {
+		return t.Source
+	}
+	return ""
+}
+
+// CallTool 调用指定的MCP工具
+// 参数先按工具的 inputSchema 校验；ctx 在客户端取消请求时被取消，耗时的工具通过 ReportProgress 汇报进度
+func CallTool(ctx context.Context, name string, args map[string]interface{}) (interface{}, error) {
+	r := loadedRegistry()
+	r.mu.RLock()
+	t := r.tools[name]
+	r.mu.RUnlock()
+	if t == nil {
+		return nil, &ToolArgumentError{Tool: name, Message: fmt.Sprintf("未知的工具: %s", name)}
+	}
+	if args == nil {
+		args = make(map[string]interface{})
+	}
+	if err := validateSchemaValue(t.Tool.InputSchema, args, "arguments"); err != nil {
+		return nil, &ToolArgumentError{Tool: name, Message: err.Error()}
+	}
+	ctx, err := enforceToolPolicy(ctx, &t.Tool, args)
+	if err != nil {
+		return nil, err
+	}
+	return t.Handler(ctx, args)
+}
+
+// notifyToolsChanged 工具列表变化后通知客户端重新获取
+func notifyToolsChanged() {
+	if mcpServer == nil || !mcpServer.IsRunning() {
+		return
+	}
+	mcpServer.NotifyAll("notifications/tools/list_changed", nil)
+}
+
+// ============ Go脚本注册的工具 ============
+
+// scriptToolHook 脚本中调用 RegisterMCPTool / UnregisterMCPTool 时执行（handler 为nil表示删除）
+func scriptToolHook(name, description string, inputSchema map[string]interface{}, handler func(args map[string]interface{}) (interface{}, error)) string {
+	if handler == nil {
+		r := registry()
+		r.mu.RLock()
+		t := r.tools[name]
+		r.mu.RUnlock()
+		if t == nil || t.Source != toolSourceScript {
+			return "找不到脚本注册的工具: " + name
+		}
+		UnregisterTool(name)
+		return ""
+	}
+	tool := MCPTool{Name: name, Description: description, InputSchema: inputSchema}
+	err := RegisterTool(tool, toolSourceScript, func(ctx context.Context, args map[string]interface{}) (res interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("脚本工具执行失败: %v", p)
+			}
+		}()
+		return handler(args)
+	})
+	if err != nil {
+		MCPLog("warning", "script", "注册MCP工具失败: "+err.Error())
+		return err.Error()
+	}
+	return ""
+}
+
+// resetScriptTools 重新加载脚本前删除上一次脚本注册的工具
+func resetScriptTools() {
+	replaceToolsBySource(toolSourceScript, nil)
+}
+
+// ============ 用户配置中的自定义工具 ============
+
+// configTools 把配置中的自定义工具转换为注册表项
+func configTools() []*registeredTool {
+	configLock.Lock()
+	list := make([]ConfigMCPTool, len(GlobalConfig.CustomTools))
+	copy(list, GlobalConfig.CustomTools)
+	configLock.Unlock()
+
+	tools := make([]*registeredTool, 0, len(list))
+	for _, c := range list {
+		c := c
+		schema := c.InputSchema
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		// 调用已有工具时沿用其行为提示
+		annotations := defaultToolAnnotations()
+		if c.ScriptFunc == "" && c.Tool != "" {
+			r := registry()
+			r.mu.RLock()
+			if target := r.tools[c.Tool]; target != nil && target.Tool.Annotations != nil {
+				a := *target.Tool.Annotations
+				annotations = &a
+			}
+			r.mu.RUnlock()
+		}
+		tools = append(tools, &registeredTool{
+			Tool:   MCPTool{Name: c.Name, Description: c.Description, InputSchema: schema, Annotations: annotations},
+			Source: toolSourceConfig,
+			Handler: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+				return callConfigTool(ctx, c, args)
+			},
+		})
+	}
+	return tools
+}
+
+// reloadConfigTools 自定义工具配置修改后重新注册
+func reloadConfigTools() []string {
+	return replaceToolsBySource(toolSourceConfig, configTools())
+}
+
+// callConfigTool 执行自定义工具：调用内置工具（固定参数覆盖传入参数）或Go脚本中的函数
+func callConfigTool(ctx context.Context, c ConfigMCPTool, args map[string]interface{}) (interface{}, error) {
+	merged := make(map[string]interface{}, len(args)+len(c.Arguments))
+	for k, v := range args {
+		merged[k] = v
+	}
+	for k, v := range c.Arguments {
+		merged[k] = v
+	}
+	if c.ScriptFunc != "" {
+		return callScriptFunc(c.ScriptFunc, merged)
+	}
+	if c.Tool == "" {
+		return nil, fmt.Errorf("自定义工具 %s 未指定要调用的工具或脚本函数", c.Name)
+	}
+	if c.Tool == c.Name {
+		return nil, fmt.Errorf("自定义工具 %s 不能调用自身", c.Name)
+	}
+	// 记录调用链，防止自定义工具之间循环调用
+	chain, _ := ctx.Value(configToolChainKey{}).([]string)
+	for _, name := range chain {
+		if name == c.Name {
+			return nil, &ToolArgumentError{Tool: c.Name, Message: fmt.Sprintf("自定义工具循环调用: %s -> %s", strings.Join(chain, " -> "), c.Name)}
+		}
+	}
+	if len(chain) >= maxConfigToolDepth {
+		return nil, &ToolArgumentError{Tool: c.Name, Message: fmt.Sprintf("自定义工具嵌套调用超过 %d 层", maxConfigToolDepth)}
+	}
+	next := make([]string, len(chain), len(chain)+1)
+	copy(next, chain)
+	ctx = context.WithValue(ctx, configToolChainKey{}, append(next, c.Name))
+	return CallTool(ctx, c.Tool, merged)
+}
+
+// maxConfigToolDepth 自定义工具调用其他自定义工具的最大嵌套层数
+const maxConfigToolDepth = 8
+
+// configToolChainKey ctx 中保存当前自定义工具调用链的键
+type configToolChainKey struct{}
+
+// checkConfigToolCycle 保存自定义工具 t 前沿 Tool 链检查是否形成循环
+func checkConfigToolCycle(t ConfigMCPTool) error {
+	configLock.Lock()
+	tools := make(map[string]ConfigMCPTool, len(GlobalConfig.CustomTools)+1)
+	for _, c := range GlobalConfig.CustomTools {
+		tools[c.Name] = c
+	}
+	configLock.Unlock()
+	tools[t.Name] = t
+
+	chain := []string{t.Name}
+	for cur := t; cur.ScriptFunc == "" && cur.Tool != ""; {
+		for _, name := range chain {
+			if name == cur.Tool {
+				return fmt.Errorf("自定义工具循环调用: %s -> %s", strings.Join(chain, " -> "), cur.Tool)
+			}
+		}
+		next, ok := tools[cur.Tool]
+		if !ok {
+			break
+		}
+		chain = append(chain, cur.Tool)
+		if len(chain) > maxConfigToolDepth {
+			return fmt.Errorf("自定义工具嵌套调用超过 %d 层", maxConfigToolDepth)
+		}
+		cur = next
+	}
+	return nil
+}
+
+// callScriptFunc 调用Go脚本中签名为 func(args map[string]interface{}) (interface{}, error) 的函数
+func callScriptFunc(name string, args map[string]interface{}) (res interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("脚本函数 %s 执行失败: %v", name, p)
+		}
+	}()
+	lock.Lock()
+	if NewEval == nil {
+		lock.Unlock()
+		return nil, errors.New("Go脚本未加载")
+	}
+	v, e := NewEval.Eval("main." + name)
+	lock.Unlock()
+	if e != nil {
+		return nil, fmt.Errorf("找不到脚本函数 %s: %v", name, e)
+	}
+	f, ok := v.Interface().(func(map[string]interface{}) (interface{}, error))
+	if !ok || f == nil {
+		return nil, fmt.Errorf("脚本函数 %s 的签名必须是 func(args map[string]interface{}) (interface{}, error)", name)
+	}
+	return f(args)
+}
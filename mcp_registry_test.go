@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// setCustomTools 临时替换配置中的自定义工具
+func setCustomTools(t *testing.T, tools []ConfigMCPTool) {
+	t.Helper()
+	configLock.Lock()
+	old := GlobalConfig.CustomTools
+	GlobalConfig.CustomTools = tools
+	configLock.Unlock()
+	t.Cleanup(func() {
+		configLock.Lock()
+		GlobalConfig.CustomTools = old
+		configLock.Unlock()
+	})
+}
+
+func TestCheckConfigToolCycle(t *testing.T) {
+	setCustomTools(t, []ConfigMCPTool{
+		{Name: "a", Tool: "b"},
+		{Name: "c", Tool: "request_list"},
+	})
+	if err := checkConfigToolCycle(ConfigMCPTool{Name: "b", Tool: "a"}); err == nil || !strings.Contains(err.Error(), "b -> a -> b") {
+		t.Fatalf("应拒绝循环调用: %v", err)
+	}
+	if err := checkConfigToolCycle(ConfigMCPTool{Name: "b", Tool: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfigToolCycle(ConfigMCPTool{Name: "b", ScriptFunc: "Run", Tool: "a"}); err != nil {
+		t.Fatalf("调用脚本函数的工具不会调用其他工具: %v", err)
+	}
+}
+
+func TestCheckConfigToolDepth(t *testing.T) {
+	var tools []ConfigMCPTool
+	for i := 1; i <= maxConfigToolDepth; i++ {
+		tools = append(tools, ConfigMCPTool{Name: fmt.Sprintf("t%d", i), Tool: fmt.Sprintf("t%d", i+1)})
+	}
+	setCustomTools(t, tools)
+	if err := checkConfigToolCycle(ConfigMCPTool{Name: "t0", Tool: "t1"}); err == nil || !strings.Contains(err.Error(), "嵌套调用超过") {
+		t.Fatalf("应拒绝过深的调用链: %v", err)
+	}
+	if err := checkConfigToolCycle(ConfigMCPTool{Name: "t2", Tool: "t3"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallConfigToolChain(t *testing.T) {
+	ctx := context.WithValue(context.Background(), configToolChainKey{}, []string{"a", "b"})
+	_, err := callConfigTool(ctx, ConfigMCPTool{Name: "a", Tool: "b"}, nil)
+	if _, ok := err.(*ToolArgumentError); !ok || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Fatalf("运行时应拒绝循环调用: %v", err)
+	}
+
+	chain := make([]string, maxConfigToolDepth)
+	for i := range chain {
+		chain[i] = fmt.Sprintf("t%d", i)
+	}
+	ctx = context.WithValue(context.Background(), configToolChainKey{}, chain)
+	_, err = callConfigTool(ctx, ConfigMCPTool{Name: "x", Tool: "request_list"}, nil)
+	if _, ok := err.(*ToolArgumentError); !ok || !strings.Contains(err.Error(), "嵌套调用超过") {
+		t.Fatalf("运行时应限制嵌套层数: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"changeme/MapHash"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 资源URI前缀
+const mcpResourceScheme = "sunnynet://request/"
+
+// 子资源名称
+const (
+	resourceRequestBody  = "request-body"
+	resourceResponseBody = "response-body"
+	resourceSocketFrames = "socket-frames"
+)
+
+// resources/list 每页返回的最大数量
+const mcpResourcePageSize = 200
+
+// errResourceNotFound 资源不存在
+var errResourceNotFound = errors.New("资源不存在")
+
+// MCPResource MCP资源定义
+type MCPResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+// MCPResourceTemplate MCP资源模板定义
+type MCPResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// MCPResourceContents 资源内容（文本使用text，二进制使用blob）
+type MCPResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+type MCPResourcesListResult struct {
+	Resources  []MCPResource `json:"resources"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+type MCPResourceTemplatesListResult struct {
+	ResourceTemplates []MCPResourceTemplate `json:"resourceTemplates"`
+}
+
+type MCPResourcesReadResult struct {
+	Contents []MCPResourceContents `json:"contents"`
+}
+
+// GetResourceTemplates 返回所有资源模板
+func GetResourceTemplates() []MCPResourceTemplate {
+	return []MCPResourceTemplate{
+		{
+			URITemplate: mcpResourceScheme + "{theology}",
+			Name:        "捕获的会话",
+			Description: "指定会话的概要信息（请求行、请求头、响应头、长度、时间等）",
+			MimeType:    "application/json",
+		},
+		{
+			URITemplate: mcpResourceScheme + "{theology}/" + resourceRequestBody,
+			Name:        "请求体",
+			Description: "指定HTTP会话的原始请求体，MIME类型取自请求的Content-Type",
+		},
+		{
+			URITemplate: mcpResourceScheme + "{theology}/" + resourceResponseBody,
+			Name:        "响应体",
+			Description: "指定HTTP会话的原始响应体，MIME类型取自响应的Content-Type",
+		},
+		{
+			URITemplate: mcpResourceScheme + "{theology}/" + resourceSocketFrames,
+			Name:        "Socket数据帧",
+			Description: "指定TCP/UDP/Websocket会话的全部数据帧（方向、时间、长度、内容）",
+			MimeType:    "application/json",
+		},
+	}
+}
+
+// requestResourceURI 生成会话资源URI，sub为空时返回主资源
+func requestResourceURI(theology int, sub string) string {
+	if sub == "" {
+		return mcpResourceScheme + strconv.Itoa(theology)
+	}
+	return mcpResourceScheme + strconv.Itoa(theology) + "/" + sub
+}
+
+// parseResourceURI 解析资源URI，返回会话ID和子资源名称
+func parseResourceURI(uri string) (int, string, error) {
+	if !strings.HasPrefix(uri, mcpResourceScheme) {
+		return 0, "", fmt.Errorf("不支持的资源URI: %s", uri)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(uri, mcpResourceScheme), "/", 2)
+	theology, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("无效的会话ID: %s", parts[0])
+	}
+	sub := ""
+	if len(parts) == 2 {
+		sub = parts[1]
+	}
+	switch sub {
+	case "", resourceRequestBody, resourceResponseBody, resourceSocketFrames:
+		return theology, sub, nil
+	}
+	return 0, "", fmt.Errorf("未知的子资源: %s", sub)
+}
+
+// isSocketRequest 判断会话是否为TCP/UDP/Websocket
+func isSocketRequest(h *MapHash.Request) bool {
+	if h.TcpConn != nil || h.UdpConn != nil || h.WsConn != nil {
+		return true
+	}
+	way := strings.ToUpper(h.Way)
+	return way == "WEBSOCKET" || way == "UDP" || strings.Contains(way, "TCP")
+}
+
+// headerMimeType 从Header中取出不带参数的Content-Type
+func headerMimeType(header http.Header) string {
+	if header == nil {
+		return ""
+	}
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		if v := header["content-type"]; len(v) > 0 {
+			ct = v[0]
+		}
+	}
+	if ct == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return strings.TrimSpace(strings.Split(ct, ";")[0])
+	}
+	return mt
+}
+
+// isTextMimeType 判断MIME类型是否为文本
+func isTextMimeType(mt string) bool {
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+	for _, k := range []string{"json", "xml", "javascript", "x-www-form-urlencoded", "graphql", "yaml"} {
+		if strings.Contains(mt, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyResourceContents 将Body转换为资源内容，文本直接返回，二进制使用Base64
+func bodyResourceContents(uri, mt string, body []byte) MCPResourceContents {
+	if mt == "" {
+		if utf8.Valid(body) {
+			mt = "text/plain"
+		} else {
+			mt = "application/octet-stream"
+		}
+	}
+	c := MCPResourceContents{URI: uri, MimeType: mt}
+	if (isTextMimeType(mt) || mt == "text/plain") && utf8.Valid(body) {
+		c.Text = string(body)
+	} else {
+		c.Blob = base64.StdEncoding.EncodeToString(body)
+	}
+	return c
+}
+
+// listCaptureResources 列出捕获列表中的资源，cursor为上一页返回的偏移量
+func listCaptureResources(cursor string) (*MCPResourcesListResult, error) {
+	offset := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("无效的cursor: %s", cursor)
+		}
+		offset = n
+	}
+
+	var keys []int
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if h != nil && h.Display {
+			keys = append(keys, theology)
+		}
+	})
+	// 最新的在前
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+
+	result := &MCPResourcesListResult{Resources: make([]MCPResource, 0)}
+	if offset == 0 {
+		result.Resources = append(result.Resources, MCPResource{
+			URI:         mcpCaptureListURI,
+			Name:        "捕获列表",
+			Description: fmt.Sprintf("当前捕获的全部会话（%d 条），可订阅以接收新流量通知", len(keys)),
+			MimeType:    "application/json",
+		})
+	}
+	if offset > len(keys) {
+		offset = len(keys)
+	}
+	end := offset + mcpResourcePageSize
+	if end < len(keys) {
+		result.NextCursor = strconv.Itoa(end)
+	} else {
+		end = len(keys)
+	}
+
+	for _, theology := range keys[offset:end] {
+		h := HashMap.GetRequest(theology)
+		if h == nil {
+			continue
+		}
+		result.Resources = append(result.Resources, MCPResource{
+			URI:         requestResourceURI(theology, ""),
+			Name:        fmt.Sprintf("#%d %s %s", theology, h.Method, h.URL),
+			Description: fmt.Sprintf("%s 会话", h.Way),
+			MimeType:    "application/json",
+		})
+		if isSocketRequest(h) {
+			if len(h.SocketData) > 0 {
+				result.Resources = append(result.Resources, MCPResource{
+					URI:      requestResourceURI(theology, resourceSocketFrames),
+					Name:     fmt.Sprintf("#%d Socket数据帧", theology),
+					MimeType: "application/json",
+				})
+			}
+			HashMap.Release(h)
+			continue
+		}
+		if len(h.Body) > 0 {
+			result.Resources = append(result.Resources, MCPResource{
+				URI:      requestResourceURI(theology, resourceRequestBody),
+				Name:     fmt.Sprintf("#%d 请求体", theology),
+				MimeType: headerMimeType(h.Header),
+				Size:     len(h.Body),
+			})
+		}
+		if len(h.Response.Body) > 0 {
+			result.Resources = append(result.Resources, MCPResource{
+				URI:      requestResourceURI(theology, resourceResponseBody),
+				Name:     fmt.Sprintf("#%d 响应体", theology),
+				MimeType: headerMimeType(h.Response.Header),
+				Size:     len(h.Response.Body),
+			})
+		}
+		HashMap.Release(h)
+	}
+	return result, nil
+}
+
+// readCaptureResource 读取指定URI的资源内容
+func readCaptureResource(uri string) (*MCPResourcesReadResult, error) {
+	if uri == mcpCaptureListURI {
+		bs, e := json.Marshal(captureListSummary())
+		if e != nil {
+			return nil, e
+		}
+		return &MCPResourcesReadResult{Contents: []MCPResourceContents{{URI: uri, MimeType: "application/json", Text: string(bs)}}}, nil
+	}
+	theology, sub, err := parseResourceURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
+	if h == nil {
+		return nil, errResourceNotFound
+	}
+
+	var contents MCPResourceContents
+	switch sub {
+	case "":
+		bs, e := json.Marshal(requestResourceSummary(theology, h))
+		if e != nil {
+			return nil, e
+		}
+		contents = MCPResourceContents{URI: uri, MimeType: "application/json", Text: string(bs)}
+	case resourceRequestBody:
+		contents = bodyResourceContents(uri, headerMimeType(h.Header), h.Body)
+	case resourceResponseBody:
+		contents = bodyResourceContents(uri, headerMimeType(h.Response.Header), h.Response.Body)
+	case resourceSocketFrames:
+		bs, e := json.Marshal(socketFramesOf(h))
+		if e != nil {
+			return nil, e
+		}
+		contents = MCPResourceContents{URI: uri, MimeType: "application/json", Text: string(bs)}
+	}
+	return &MCPResourcesReadResult{Contents: []MCPResourceContents{contents}}, nil
+}
+
+// captureListSummary 捕获列表概要（最新的在前）
+func captureListSummary() []map[string]interface{} {
+	var keys []int
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if h != nil && h.Display {
+			keys = append(keys, theology)
+		}
+	})
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	list := make([]map[string]interface{}, 0, len(keys))
+	for _, theology := range keys {
+		h := HashMap.GetRequest(theology)
+		if h == nil {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"theology":   theology,
+			"uri":        requestResourceURI(theology, ""),
+			"method":     h.Method,
+			"url":        h.URL,
+			"way":        h.Way,
+			"statusCode": h.Response.StateCode,
+		})
+		HashMap.Release(h)
+	}
+	return list
+}
+
+// requestResourceSummary 会话概要信息（不含Body，Body通过子资源读取）
+func requestResourceSummary(theology int, h *MapHash.Request) map[string]interface{} {
+	summary := map[string]interface{}{
+		"theology": theology,
+		"method":   h.Method,
+		"url":      h.URL,
+		"proto":    h.Proto,
+		"way":      h.Way,
+		"clientIP": h.ClientIP,
+		"pid":      h.PID,
+		"sendTime": h.SendTime,
+		"recTime":  h.RecTime,
+		"notes":    h.Notes,
+	}
+	links := make([]string, 0)
+	if isSocketRequest(h) {
+		summary["sendBytes"] = h.SendNum
+		summary["recBytes"] = h.RecNum
+		summary["frames"] = len(h.SocketData)
+		links = append(links, requestResourceURI(theology, resourceSocketFrames))
+	} else {
+		summary["request"] = map[string]interface{}{
+			"headers":    h.Header,
+			"bodyLength": len(h.Body),
+			"mimeType":   headerMimeType(h.Header),
+		}
+		summary["response"] = map[string]interface{}{
+			"statusCode": h.Response.StateCode,
+			"headers":    h.Response.Header,
+			"bodyLength": len(h.Response.Body),
+			"mimeType":   headerMimeType(h.Response.Header),
+			"error":      h.Response.Error,
+		}
+		links = append(links, requestResourceURI(theology, resourceRequestBody), requestResourceURI(theology, resourceResponseBody))
+	}
+	summary["resources"] = links
+	return summary
+}
+
+// socketFramesOf 导出Socket数据帧列表
+func socketFramesOf(h *MapHash.Request) []map[string]interface{} {
+	frames := make([]map[string]interface{}, 0, len(h.SocketData))
+	for i, sd := range h.SocketData {
+		if sd == nil || sd.Info == nil {
+			continue
+		}
+		frame := map[string]interface{}{
+			"index":      i,
+			"direction":  sd.Info.Ico,
+			"time":       sd.Info.Time,
+			"length":     len(sd.Body),
+			"bodyBase64": base64.StdEncoding.EncodeToString(sd.Body),
+		}
+		if sd.Info.WsType != "" {
+			frame["wsType"] = sd.Info.WsType
+		}
+		if utf8.Valid(sd.Body) {
+			frame["text"] = string(sd.Body)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// outputSchemaOf 根据结果类型生成工具的 outputSchema（JSON Schema），字段名取自 json 标签
+func outputSchemaOf(v interface{}) map[string]interface{} {
+	return jsonSchemaOfType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// jsonSchemaOf 由Go类型生成JSON Schema（omitempty 的字段不是必填）
+func jsonSchemaOf(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	schema := jsonSchemaOfType(t, seen)
+	// 指针、切片与map为nil时序列化为null
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if typ, ok := schema["type"].(string); ok {
+			schema["type"] = []string{typ, "null"}
+		}
+	}
+	return schema
+}
+
+func jsonSchemaOfType(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// []byte 按 encoding/json 的规则序列化为Base64字符串
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		// 递归类型不再展开
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = jsonSchemaOf(f.Type, seen)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	}
+	// interface{} 等任意类型
+	return map[string]interface{}{}
+}
+
+// validateSchemaValue 按JSON Schema的常用子集校验值：type、required、properties、
+// additionalProperties(false)、items、enum、minimum/maximum、minLength/maxLength
+func validateSchemaValue(schema map[string]interface{}, value interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+	if types := schemaStrings(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if schemaTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s 必须是 %s 类型", path, strings.Join(types, " 或 "))
+		}
+	}
+	if enum, ok := schemaList(schema["enum"]); ok {
+		found := false
+		for _, e := range enum {
+			if schemaEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s 必须是 %v 之一", path, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("缺少必填参数 %s", schemaJoinPath(path, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, item := range v {
+			sub, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if extra, ok := schema["additionalProperties"].(bool); ok && !extra {
+					return fmt.Errorf("不支持的参数 %s", schemaJoinPath(path, name))
+				}
+				continue
+			}
+			if err := validateSchemaValue(sub, item, schemaJoinPath(path, name)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateSchemaValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			return fmt.Errorf("%s 不能小于 %v", path, min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			return fmt.Errorf("%s 不能大于 %v", path, max)
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := schemaNumber(schema["minLength"]); ok && n < min {
+			return fmt.Errorf("%s 的长度不能小于 %v", path, min)
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && n > max {
+			return fmt.Errorf("%s 的长度不能大于 %v", path, max)
+		}
+	}
+	return nil
+}
+
+// schemaTypeMatches 判断值是否符合JSON Schema类型（值来自 encoding/json 解码）
+func schemaTypeMatches(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// schemaStrings 读取字符串或字符串数组（schema 可能来自Go字面量或JSON配置）
+func schemaStrings(v interface{}) []string {
+	switch s := v.(type) {
+	case string:
+		return []string{s}
+	case []string:
+		return s
+	case []interface{}:
+		list := make([]string, 0, len(s))
+		for _, item := range s {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// schemaList 读取任意类型的数组
+func schemaList(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// schemaNumber 读取数值约束
+func schemaNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// schemaEqual 比较枚举值（数值统一按 float64 比较）
+func schemaEqual(a, b interface{}) bool {
+	if x, ok := schemaNumber(a); ok {
+		y, ok := schemaNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func schemaJoinPath(path, name string) string {
+	if path == "arguments" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchemaValue(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"required":             []string{"url"},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"url":    map[string]interface{}{"type": "string", "minLength": 1},
+			"method": map[string]interface{}{"type": "string", "enum": []string{"GET", "POST"}},
+			"count":  map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 10},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"header": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"name"},
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": []interface{}{"string", "null"}, "maxLength": 4},
+				},
+			},
+		},
+	}
+	cases := []struct {
+		args string
+		err  string
+	}{
+		{`{"url":"http://a.com/","method":"GET","count":3,"tags":["a"],"header":{"name":null}}`, ""},
+		{`{"method":"GET"}`, "缺少必填参数 url"},
+		{`{"url":""}`, "url 的长度不能小于 1"},
+		{`{"url":"x","method":"PUT"}`, "method 必须是 [GET POST] 之一"},
+		{`{"url":"x","count":1.5}`, "count 必须是 integer 类型"},
+		{`{"url":"x","count":11}`, "count 不能大于 10"},
+		{`{"url":"x","count":0}`, "count 不能小于 1"},
+		{`{"url":"x","tags":["a",1]}`, "tags[1] 必须是 string 类型"},
+		{`{"url":"x","extra":true}`, "不支持的参数 extra"},
+		{`{"url":"x","header":{}}`, "缺少必填参数 header.name"},
+		{`{"url":"x","header":{"name":"Cookie"}}`, "header.name 的长度不能大于 4"},
+		{`{"url":"x","header":{"name":1}}`, "header.name 必须是 string 或 null 类型"},
+	}
+	for _, c := range cases {
+		var args map[string]interface{}
+		if err := json.Unmarshal([]byte(c.args), &args); err != nil {
+			t.Fatal(err)
+		}
+		err := validateSchemaValue(schema, args, "arguments")
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: 期望 %q，实际 %v", c.args, c.err, err)
+		}
+	}
+}
+
+func TestValidateSchemaValueOutput(t *testing.T) {
+	// outputSchemaOf 生成的 schema 应能校验同一类型的值
+	schema := outputSchemaOf(RequestSearchResult{})
+	bs, _ := json.Marshal(RequestSearchResult{Success: true, Theology: []int{1, 2}, Total: 2})
+	var value interface{}
+	_ = json.Unmarshal(bs, &value)
+	if err := validateSchemaValue(schema, value, "structuredContent"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,6 +25,15 @@ type MCPServer struct {
 	mu         sync.RWMutex
 	clients    map[string]chan []byte // SSE客户端连接
 	clientsMu  sync.RWMutex
+	sessions   map[string]*MCPSession // 会话状态
+	sessionsMu sync.RWMutex
+	stopNotify chan struct{}               // 停止资源变化推送
+	inflight   map[string]*inflightRequest // 进行中的请求（用于取消）
+	inflightMu sync.Mutex
+
+	bindAddr       string   // 监听地址
+	token          string   // 访问令牌
+	allowedOrigins []string // 允许的跨域来源
 }
 
 // JSON-RPC 2.0 请求结构
@@ -32,10 +46,10 @@ type JSONRPCRequest struct {
 
 // JSON-RPC 2.0 响应结构
 type JSONRPCResponse struct {
-	JSONRPC string           `json:"jsonrpc"`
-	ID      interface{}      `json:"id,omitempty"`
-	Result  interface{}      `json:"result,omitempty"`
-	Error   *JSONRPCError    `json:"error,omitempty"`
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
 }
 
 // JSON-RPC 2.0 错误结构
@@ -52,8 +66,12 @@ type MCPServerInfo struct {
 }
 
 type MCPCapabilities struct {
-	Tools     *MCPToolsCapability     `json:"tools,omitempty"`
-	Resources *MCPResourcesCapability `json:"resources,omitempty"`
+	Tools        *MCPToolsCapability       `json:"tools,omitempty"`
+	Resources    *MCPResourcesCapability   `json:"resources,omitempty"`
+	Prompts      *MCPPromptsCapability     `json:"prompts,omitempty"`
+	Logging      *MCPLoggingCapability     `json:"logging,omitempty"`
+	Completions  *MCPCompletionsCapability `json:"completions,omitempty"`
+	Experimental map[string]interface{}    `json:"experimental,omitempty"`
 }
 
 type MCPToolsCapability struct {
@@ -65,6 +83,10 @@ type MCPResourcesCapability struct {
 	ListChanged bool `json:"listChanged,omitempty"`
 }
 
+type MCPPromptsCapability struct {
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
 type MCPInitializeResult struct {
 	ProtocolVersion string          `json:"protocolVersion"`
 	Capabilities    MCPCapabilities `json:"capabilities"`
@@ -76,8 +98,9 @@ type MCPToolsListResult struct {
 }
 
 type MCPToolCallResult struct {
-	Content []MCPContent `json:"content"`
-	IsError bool         `json:"isError,omitempty"`
+	Content           []MCPContent `json:"content"`
+	StructuredContent interface{}  `json:"structuredContent,omitempty"` // 工具定义了 outputSchema 时返回
+	IsError           bool         `json:"isError,omitempty"`
 }
 
 type MCPContent struct {
@@ -88,9 +111,11 @@ type MCPContent struct {
 // NewMCPServer 创建新的MCP服务器实例
 func NewMCPServer(port int) *MCPServer {
 	return &MCPServer{
-		port:    port,
-		running: false,
-		clients: make(map[string]chan []byte),
+		port:     port,
+		running:  false,
+		clients:  make(map[string]chan []byte),
+		sessions: make(map[string]*MCPSession),
+		inflight: make(map[string]*inflightRequest),
 	}
 }
 
@@ -103,24 +128,39 @@ func (m *MCPServer) Start() error {
 		return fmt.Errorf("MCP服务器已在运行")
 	}
 
+	// 读取认证与监听配置
+	m.token = GlobalConfig.GetMCPToken()
+	m.bindAddr = GlobalConfig.MCP.BindAddress
+	if m.bindAddr == "" {
+		m.bindAddr = "127.0.0.1"
+	}
+	m.allowedOrigins = append([]string{}, GlobalConfig.MCP.AllowedOrigins...)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mcp", m.handleMCP)
+	// Streamable HTTP：同一端点处理 POST/GET/DELETE，兼容旧的纯POST调用
+	mux.HandleFunc("/mcp", m.handleStreamableHTTP)
 	mux.HandleFunc("/mcp/sse", m.handleSSE)
 	mux.HandleFunc("/mcp/health", m.handleHealth)
 	// 添加根路径的消息端点（用于SSE会话的消息发送）
 	mux.HandleFunc("/mcp/message", m.handleMCP)
 
 	m.httpServer = &http.Server{
-		Addr:         fmt.Sprintf(":%d", m.port),
+		Addr:         net.JoinHostPort(m.bindAddr, strconv.Itoa(m.port)),
 		Handler:      m.corsMiddleware(mux),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// 先监听端口，端口被占用或地址无效时直接返回错误
+	listener, err := net.Listen("tcp", m.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("MCP服务器监听 %s 失败: %v", m.httpServer.Addr, err)
+	}
+
 	// 异步启动服务器
 	go func() {
-		err := m.httpServer.ListenAndServe()
+		err := m.httpServer.Serve(listener)
 		if err != nil && err != http.ErrServerClosed {
 			fmt.Printf("MCP服务器错误: %v\n", err)
 			m.mu.Lock()
@@ -129,8 +169,15 @@ func (m *MCPServer) Start() error {
 		}
 	}()
 
+	m.stopNotify = make(chan struct{})
+	go m.resourceNotifyLoop(m.stopNotify)
+
+	if err := writeMCPRuntimeFile(m.bindAddr, m.port, m.token); err != nil {
+		fmt.Printf("写入MCP发现文件失败: %v\n", err)
+	}
+
 	m.running = true
-	fmt.Printf("MCP服务器已启动，端口: %d\n", m.port)
+	fmt.Printf("MCP服务器已启动，地址: %s\n", m.httpServer.Addr)
 	return nil
 }
 
@@ -143,6 +190,11 @@ func (m *MCPServer) Stop() error {
 		return nil
 	}
 
+	if m.stopNotify != nil {
+		close(m.stopNotify)
+		m.stopNotify = nil
+	}
+
 	// 关闭所有SSE客户端连接
 	m.clientsMu.Lock()
 	for id, ch := range m.clients {
@@ -151,6 +203,10 @@ func (m *MCPServer) Stop() error {
 	}
 	m.clientsMu.Unlock()
 
+	m.sessionsMu.Lock()
+	m.sessions = make(map[string]*MCPSession)
+	m.sessionsMu.Unlock()
+
 	if m.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -158,6 +214,7 @@ func (m *MCPServer) Stop() error {
 		if err != nil {
 			return err
 		}
+		removeMCPRuntimeFile()
 	}
 
 	m.running = false
@@ -179,13 +236,30 @@ func (m *MCPServer) GetPort() int {
 	return m.port
 }
 
-// corsMiddleware CORS中间件
+// GetBindAddress 获取监听地址
+func (m *MCPServer) GetBindAddress() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.bindAddr
+}
+
+// corsMiddleware CORS与认证中间件
 func (m *MCPServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不在允许列表中的网页来源直接拒绝（防止网页或DNS重绑定访问本机服务）
+		origin := r.Header.Get("Origin")
+		if !m.originAllowed(origin) {
+			http.Error(w, "不允许的来源: "+origin, http.StatusForbidden)
+			return
+		}
 		// 设置CORS头
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, "+mcpSessionHeader)
+		w.Header().Set("Access-Control-Expose-Headers", mcpSessionHeader)
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		// 处理预检请求
@@ -194,6 +268,12 @@ func (m *MCPServer) corsMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// 健康检查无需认证
+		if r.URL.Path != "/mcp/health" && !m.authorized(r) {
+			m.writeUnauthorized(w)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -203,10 +283,11 @@ func (m *MCPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "ok",
-		"server":  "SunnyNet-MCP",
-		"version": "1.0.0",
-		"running": m.IsRunning(),
+		"status":   "ok",
+		"server":   "SunnyNet-MCP",
+		"version":  "1.0.0",
+		"running":  m.IsRunning(),
+		"sessions": m.SessionCount(),
 	})
 }
 
@@ -216,7 +297,6 @@ func (m *MCPServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// 检查是否支持刷新
 	flusher, ok := w.(http.Flusher)
@@ -225,25 +305,25 @@ func (m *MCPServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 创建客户端ID和通道
-	clientID := fmt.Sprintf("%d", time.Now().UnixNano())
-	messageChan := make(chan []byte, 100)
-
-	// 注册客户端
-	m.clientsMu.Lock()
-	m.clients[clientID] = messageChan
-	m.clientsMu.Unlock()
+	// 创建客户端ID和通道，SSE连接同时对应一个会话
+	clientID := newSessionID()
+	session := m.createSession(clientID)
+	session.mu.Lock()
+	session.legacySSE = true
+	session.mu.Unlock()
+	messageChan, _ := m.registerClient(clientID)
 
 	// 清理函数
 	defer func() {
-		m.clientsMu.Lock()
-		delete(m.clients, clientID)
-		close(messageChan)
-		m.clientsMu.Unlock()
+		m.unregisterClient(clientID, messageChan)
+		m.removeSession(clientID)
 	}()
 
+	// SSE为长连接，取消服务器的写超时
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
 	// 发送初始连接事件，包含消息端点URL
-	messageEndpoint := fmt.Sprintf("http://127.0.0.1:%d/mcp/message?sessionId=%s", m.port, clientID)
+	messageEndpoint := fmt.Sprintf("http://%s/mcp/message?sessionId=%s", r.Host, clientID)
 	initEvent := fmt.Sprintf("event: endpoint\ndata: %s\n\n", messageEndpoint)
 	w.Write([]byte(initEvent))
 	flusher.Flush()
@@ -269,39 +349,123 @@ func (m *MCPServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleMCP JSON-RPC请求端点
+// handleMCP JSON-RPC请求端点（支持批量请求；只包含通知/响应时返回202且没有响应体）
 func (m *MCPServer) handleMCP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "仅支持POST方法", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// 非流式响应要等工具执行完才写出，长时间运行的工具可能超过服务器写超时
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// 初始化之后的请求在请求头中声明协议版本，不支持的版本返回400
+	protocolVersion, ok := m.checkProtocolVersionHeader(w, r)
+	if !ok {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	// 解析JSON-RPC请求
-	var request JSONRPCRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	// 解析JSON-RPC请求（单条或批量）
+	body, err := io.ReadAll(io.LimitReader(r.Body, mcpMaxRequestBody))
+	if err != nil {
 		m.writeJSONRPCError(w, nil, -32700, "解析错误", err.Error())
 		return
 	}
-
-	// 验证JSON-RPC版本
-	if request.JSONRPC != "2.0" {
-		m.writeJSONRPCError(w, request.ID, -32600, "无效的请求", "必须使用JSON-RPC 2.0")
+	messages, batch, err := parseJSONRPCBody(body)
+	if err != nil {
+		m.writeJSONRPCError(w, nil, -32700, "解析错误", err.Error())
+		return
+	}
+	if batch && len(messages) == 0 {
+		m.writeJSONRPCError(w, nil, -32600, "无效的请求", "批量请求不能为空")
 		return
 	}
 
-	// 处理请求并获取响应
-	response := m.handleJSONRPC(request)
+	hasInitialize := false
+	var firstID interface{}
+	for _, msg := range messages {
+		if msg.Invalid == nil && msg.Request.Method == "initialize" {
+			hasInitialize = true
+			firstID = msg.Request.ID
+		}
+	}
 
 	// 获取sessionId（如果有的话，同时发送SSE响应）
 	sessionId := r.URL.Query().Get("sessionId")
+
+	// 解析会话：Streamable HTTP 使用 Mcp-Session-Id 头，旧SSE使用 sessionId 参数
+	// 指定的会话不存在（已过期或服务重启）时返回404，客户端据此重新初始化，不按无会话请求处理
+	var session *MCPSession
+	if headerID := r.Header.Get(mcpSessionHeader); headerID != "" && !hasInitialize {
+		session = m.getSession(headerID)
+	} else if sessionId != "" {
+		session = m.getSession(sessionId)
+	}
+	if session == nil && (sessionId != "" || (r.Header.Get(mcpSessionHeader) != "" && !hasInitialize)) {
+		if !batch {
+			firstID = messages[0].Request.ID
+		}
+		m.writeSessionNotFound(w, firstID)
+		return
+	}
+	if hasInitialize && session == nil {
+		session = m.createSession("")
+	}
+	if session != nil && sessionId == "" {
+		w.Header().Set(mcpSessionHeader, session.ID)
+	}
+
+	// 取消只对所在会话的请求生效，无会话时无法确定要取消的是哪个调用方的请求
+	if session == nil && hasCancellation(messages) {
+		w.WriteHeader(http.StatusBadRequest)
+		m.writeJSONRPCError(w, nil, -32600, "无效的请求", "notifications/cancelled 需要携带 Mcp-Session-Id 请求头")
+		return
+	}
+
+	// 请求需要进度通知且客户端接受SSE时，以SSE流返回进度通知与最终响应
+	// 需要通过 elicitation 确认的工具调用同样使用SSE流，以便在响应前向客户端发送请求
+	var stream *postEventStream
+	streaming := wantsProgress(messages) || (session != nil && session.SupportsElicitation() && toolsNeedConfirmation(messages))
+	if streaming && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		stream = newPostEventStream(w)
+	}
+	send := clientSender(func(message []byte) bool {
+		if stream != nil {
+			stream.send(message)
+			return true
+		}
+		return session != nil && m.SendToClient(session.ID, message)
+	})
+	ctx := withNotifySink(r.Context(), func(message []byte) { send(message) })
+	ctx = withClientSender(ctx, send)
+	ctx = withSession(ctx, session)
+	ctx = withProtocolVersion(ctx, protocolVersion)
+
+	// 处理请求并收集响应（通知与客户端响应不回复）
+	responses := make([]JSONRPCResponse, 0, len(messages))
+	for _, msg := range messages {
+		if response := m.handleMessage(ctx, session, msg); response != nil {
+			responses = append(responses, *response)
+		}
+	}
+	if len(responses) == 0 {
+		if stream == nil {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		return
+	}
+
+	var payload interface{} = responses
+	if !batch {
+		payload = responses[0]
+	}
+	respData, _ := json.Marshal(payload)
 	if sessionId != "" {
+		// 持有读锁发送，避免通道在会话删除时被关闭
 		m.clientsMu.RLock()
-		ch, exists := m.clients[sessionId]
-		m.clientsMu.RUnlock()
-		if exists {
-			respData, _ := json.Marshal(response)
+		if ch, exists := m.clients[sessionId]; exists {
 			select {
 			case ch <- respData:
 				// 同时发送到SSE通道
@@ -309,28 +473,103 @@ func (m *MCPServer) handleMCP(w http.ResponseWriter, r *http.Request) {
 				// 通道已满，跳过SSE
 			}
 		}
+		m.clientsMu.RUnlock()
 	}
 
 	// 始终返回HTTP响应（Cursor可能需要）
-	json.NewEncoder(w).Encode(response)
+	if stream != nil {
+		stream.send(respData)
+		return
+	}
+	w.Write(append(respData, '\n'))
+}
+
+// handleMessage 处理单条消息，不需要响应时返回nil
+func (m *MCPServer) handleMessage(ctx context.Context, session *MCPSession, msg parsedMessage) *JSONRPCResponse {
+	if msg.Invalid != nil {
+		// 通知的参数错误无法回复；结构无效的消息按规范以null id回复
+		if msg.Notification && msg.Invalid.Code != -32600 {
+			return nil
+		}
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: msg.Request.ID, Error: msg.Invalid}
+	}
+	if msg.IsResponse {
+		deliverClientResponse(session, msg)
+		return nil
+	}
+	if msg.Notification {
+		m.handleNotification(session, msg.Request)
+		return nil
+	}
+	// 登记请求以便客户端通过 notifications/cancelled 取消；被取消的请求不再响应
+	ctx, key := m.trackRequest(ctx, session, msg.Request.ID)
+	ctx = withProgress(ctx, progressTokenOf(msg.Request.Params))
+	response := m.handleJSONRPC(ctx, session, msg.Request)
+	if m.untrackRequest(key) {
+		return nil
+	}
+	return &response
+}
+
+// handleNotification 处理客户端通知
+func (m *MCPServer) handleNotification(session *MCPSession, request JSONRPCRequest) {
+	switch request.Method {
+	case "initialized", "notifications/initialized":
+		if session != nil {
+			session.mu.Lock()
+			session.Initialized = true
+			session.mu.Unlock()
+		}
+	case "notifications/cancelled":
+		if request.Params != nil {
+			m.cancelRequest(session, request.Params["requestId"])
+		}
+	}
 }
 
 // handleJSONRPC 处理JSON-RPC请求
-func (m *MCPServer) handleJSONRPC(request JSONRPCRequest) JSONRPCResponse {
+func (m *MCPServer) handleJSONRPC(ctx context.Context, session *MCPSession, request JSONRPCRequest) JSONRPCResponse {
 	switch request.Method {
 	case "initialize":
-		return m.handleInitialize(request)
-	case "initialized":
-		// 客户端确认初始化完成，返回空响应
+		return m.handleInitialize(session, request)
+	case "initialized", "notifications/initialized":
+		// 旧客户端以请求（带id）的形式发送初始化完成通知，返回空响应
+		if session != nil {
+			session.mu.Lock()
+			session.Initialized = true
+			session.mu.Unlock()
+		}
 		return JSONRPCResponse{
 			JSONRPC: "2.0",
 			ID:      request.ID,
 			Result:  map[string]interface{}{},
 		}
 	case "tools/list":
-		return m.handleToolsList(request)
+		return m.handleToolsList(ctx, request)
 	case "tools/call":
-		return m.handleToolsCall(request)
+		return m.handleToolsCall(ctx, request)
+	case "resources/list":
+		return m.handleResourcesList(request)
+	case "resources/templates/list":
+		return m.handleResourceTemplatesList(request)
+	case "resources/read":
+		return m.handleResourcesRead(request)
+	case "resources/subscribe":
+		return m.handleResourcesSubscribe(session, request, true)
+	case "resources/unsubscribe":
+		return m.handleResourcesSubscribe(session, request, false)
+	case "prompts/list":
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Result:  MCPPromptsListResult{Prompts: GetPromptsList()},
+		}
+	case "prompts/get":
+		return m.handlePromptsGet(request)
+	case "completion/complete":
+		return m.handleCompletionComplete(request)
+	case "logging/setLevel":
+		return m.handleLoggingSetLevel(session, request)
 	case "ping":
 		return JSONRPCResponse{
 			JSONRPC: "2.0",
@@ -350,14 +589,106 @@ func (m *MCPServer) handleJSONRPC(request JSONRPCRequest) JSONRPCResponse {
 	}
 }
 
+// handlePromptsGet 处理提示词获取请求
+func (m *MCPServer) handlePromptsGet(request JSONRPCRequest) JSONRPCResponse {
+	name := ""
+	args := make(map[string]string)
+	if request.Params != nil {
+		name, _ = request.Params["name"].(string)
+		if raw, ok := request.Params["arguments"].(map[string]interface{}); ok {
+			for k, v := range raw {
+				switch val := v.(type) {
+				case string:
+					args[k] = val
+				case float64:
+					args[k] = strconv.FormatFloat(val, 'f', -1, 64)
+				case nil:
+				default:
+					args[k] = fmt.Sprint(val)
+				}
+			}
+		}
+	}
+	if name == "" {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    "缺少提示词名称",
+			},
+		}
+	}
+
+	result, err := GetPrompt(name, args)
+	if err != nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    err.Error(),
+			},
+		}
+	}
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}
+}
+
 // handleInitialize 处理MCP初始化请求
-func (m *MCPServer) handleInitialize(request JSONRPCRequest) JSONRPCResponse {
+func (m *MCPServer) handleInitialize(session *MCPSession, request JSONRPCRequest) JSONRPCResponse {
+	requested, _ := request.Params["protocolVersion"].(string)
+	version, verr := negotiateProtocolVersion(requested)
+	if verr != nil {
+		// 新建的 Streamable HTTP 会话初始化失败时删除；旧版SSE会话保留，客户端可以重新初始化
+		if session != nil && !m.hasClient(session.ID) {
+			m.removeSession(session.ID)
+		}
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error:   verr,
+		}
+	}
+	if session != nil {
+		session.mu.Lock()
+		session.ProtocolVersion = version
+		session.ClientInfo, _ = request.Params["clientInfo"].(map[string]interface{})
+		session.Capabilities, _ = request.Params["capabilities"].(map[string]interface{})
+		session.mu.Unlock()
+	}
+
 	result := MCPInitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: version,
 		Capabilities: MCPCapabilities{
 			Tools: &MCPToolsCapability{
 				ListChanged: true,
 			},
+			Resources: &MCPResourcesCapability{
+				Subscribe:   true,
+				ListChanged: true,
+			},
+			Prompts: &MCPPromptsCapability{
+				ListChanged: true,
+			},
+			Logging:     &MCPLoggingCapability{},
+			Completions: &MCPCompletionsCapability{},
+			Experimental: map[string]interface{}{
+				"transports": map[string]interface{}{
+					"streamableHttp": map[string]interface{}{
+						"endpoint":      "/mcp",
+						"sessionHeader": mcpSessionHeader,
+					},
+					"sse": map[string]interface{}{
+						"endpoint": "/mcp/sse",
+					},
+				},
+			},
 		},
 		ServerInfo: MCPServerInfo{
 			Name:    "SunnyNet-MCP",
@@ -365,6 +696,10 @@ func (m *MCPServer) handleInitialize(request JSONRPCRequest) JSONRPCResponse {
 		},
 	}
 
+	if !versionSupports(version, featureCompletions) {
+		result.Capabilities.Completions = nil
+	}
+
 	return JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
@@ -373,8 +708,8 @@ func (m *MCPServer) handleInitialize(request JSONRPCRequest) JSONRPCResponse {
 }
 
 // handleToolsList 处理工具列表请求
-func (m *MCPServer) handleToolsList(request JSONRPCRequest) JSONRPCResponse {
-	tools := GetToolsList()
+func (m *MCPServer) handleToolsList(ctx context.Context, request JSONRPCRequest) JSONRPCResponse {
+	tools := toolsForVersion(GetToolsList(), protocolVersionOf(ctx))
 	result := MCPToolsListResult{
 		Tools: tools,
 	}
@@ -387,7 +722,7 @@ func (m *MCPServer) handleToolsList(request JSONRPCRequest) JSONRPCResponse {
 }
 
 // handleToolsCall 处理工具调用请求
-func (m *MCPServer) handleToolsCall(request JSONRPCRequest) JSONRPCResponse {
+func (m *MCPServer) handleToolsCall(ctx context.Context, request JSONRPCRequest) JSONRPCResponse {
 	// 从参数中提取工具名和参数
 	params := request.Params
 	if params == nil {
@@ -422,8 +757,22 @@ func (m *MCPServer) handleToolsCall(request JSONRPCRequest) JSONRPCResponse {
 		args = make(map[string]interface{})
 	}
 
-	// 调用工具
-	result, err := CallTool(toolName, args)
+	// 调用工具（无论成功与否都记录审计日志）
+	start := time.Now()
+	result, err := CallTool(ctx, toolName, args)
+	auditToolCall(ctx, toolName, args, start, result, err)
+	var argErr *ToolArgumentError
+	if errors.As(err, &argErr) {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    argErr.Message,
+			},
+		}
+	}
 	if err != nil {
 		// 返回错误结果
 		return JSONRPCResponse{
@@ -441,27 +790,175 @@ func (m *MCPServer) handleToolsCall(request JSONRPCRequest) JSONRPCResponse {
 		}
 	}
 
-	// 将结果转换为JSON字符串
+	// 将结果转换为JSON字符串（同时作为不支持 structuredContent 的客户端的文本结果）
 	resultJSON, err := json.Marshal(result)
 	if err != nil {
 		resultJSON = []byte(fmt.Sprintf("%v", result))
 	}
 
+	callResult := MCPToolCallResult{
+		Content: []MCPContent{
+			{
+				Type: "text",
+				Text: string(resultJSON),
+			},
+		},
+		IsError: false,
+	}
+	if tool := FindTool(toolName); tool != nil && tool.OutputSchema != nil && err == nil && versionSupports(protocolVersionOf(ctx), featureStructuredContent) {
+		callResult.StructuredContent = result
+	}
 	return JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
-		Result: MCPToolCallResult{
-			Content: []MCPContent{
-				{
-					Type: "text",
-					Text: string(resultJSON),
-				},
+		Result:  callResult,
+	}
+}
+
+// handleResourcesList 处理资源列表请求
+func (m *MCPServer) handleResourcesList(request JSONRPCRequest) JSONRPCResponse {
+	cursor := ""
+	if request.Params != nil {
+		cursor, _ = request.Params["cursor"].(string)
+	}
+	result, err := listCaptureResources(cursor)
+	if err != nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    err.Error(),
 			},
-			IsError: false,
+		}
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}
+}
+
+// handleResourceTemplatesList 处理资源模板列表请求
+func (m *MCPServer) handleResourceTemplatesList(request JSONRPCRequest) JSONRPCResponse {
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result: MCPResourceTemplatesListResult{
+			ResourceTemplates: GetResourceTemplates(),
 		},
 	}
 }
 
+// handleResourcesRead 处理资源读取请求
+func (m *MCPServer) handleResourcesRead(request JSONRPCRequest) JSONRPCResponse {
+	uri := ""
+	if request.Params != nil {
+		uri, _ = request.Params["uri"].(string)
+	}
+	if uri == "" {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    "缺少资源URI",
+			},
+		}
+	}
+
+	result, err := readCaptureResource(uri)
+	if err == errResourceNotFound {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32002,
+				Message: "资源不存在",
+				Data:    map[string]interface{}{"uri": uri},
+			},
+		}
+	}
+	if err != nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    err.Error(),
+			},
+		}
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}
+}
+
+// handleResourcesSubscribe 处理资源订阅/取消订阅请求
+func (m *MCPServer) handleResourcesSubscribe(session *MCPSession, request JSONRPCRequest, subscribe bool) JSONRPCResponse {
+	if session == nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32600,
+				Message: "无效的请求",
+				Data:    "订阅需要会话，请通过 Streamable HTTP 或 SSE 连接",
+			},
+		}
+	}
+	uri := ""
+	if request.Params != nil {
+		uri, _ = request.Params["uri"].(string)
+	}
+	if !validSubscriptionURI(uri) {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+			Error: &JSONRPCError{
+				Code:    -32602,
+				Message: "无效的参数",
+				Data:    fmt.Sprintf("无法订阅的资源URI: %s", uri),
+			},
+		}
+	}
+
+	if subscribe {
+		if uri != mcpCaptureListURI {
+			theology, _, _ := parseResourceURI(uri)
+			h := HashMap.GetRequest(theology)
+			HashMap.Release(h)
+			if h == nil {
+				return JSONRPCResponse{
+					JSONRPC: "2.0",
+					ID:      request.ID,
+					Error: &JSONRPCError{
+						Code:    -32002,
+						Message: "资源不存在",
+						Data:    map[string]interface{}{"uri": uri},
+					},
+				}
+			}
+		}
+		session.Subscribe(uri)
+	} else {
+		session.Unsubscribe(uri)
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  map[string]interface{}{},
+	}
+}
+
 // writeJSONRPCError 写入JSON-RPC错误响应
 func (m *MCPServer) writeJSONRPCError(w http.ResponseWriter, id interface{}, code int, message string, data interface{}) {
 	response := JSONRPCResponse{
@@ -478,10 +975,10 @@ func (m *MCPServer) writeJSONRPCError(w http.ResponseWriter, id interface{}, cod
 
 // SendToClient 向指定客户端发送消息
 func (m *MCPServer) SendToClient(clientID string, message []byte) bool {
+	// 持有读锁发送，避免通道在会话删除时被关闭
 	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
 	ch, exists := m.clients[clientID]
-	m.clientsMu.RUnlock()
-
 	if !exists {
 		return false
 	}
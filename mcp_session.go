@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Streamable HTTP 传输使用的会话头
+const mcpSessionHeader = "Mcp-Session-Id"
+
+// 会话空闲超时时间，超时后会话会被清理
+const mcpSessionIdleTimeout = 30 * time.Minute
+
+// MCPSession MCP会话状态（Streamable HTTP 与 SSE 共用）
+type MCPSession struct {
+	ID              string
+	ProtocolVersion string
+	ClientInfo      map[string]interface{}
+	Capabilities    map[string]interface{} // 客户端声明的能力
+	Initialized     bool
+	CreatedAt       time.Time
+	LastActive      time.Time
+	subscriptions   map[string]bool // 已订阅的资源URI
+	logLevel        string          // logging/setLevel 设置的最低日志级别
+	legacySSE       bool            // 由通过认证的 GET /mcp/sse 创建的旧版SSE会话
+	mu              sync.Mutex
+}
+
+// touch 更新会话的最后活动时间
+func (s *MCPSession) touch() {
+	s.mu.Lock()
+	s.LastActive = time.Now()
+	s.mu.Unlock()
+}
+
+// idleSince 返回会话最后一次活动的时间
+func (s *MCPSession) idleSince() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.LastActive
+}
+
+// Subscribe 订阅资源
+func (s *MCPSession) Subscribe(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string]bool)
+	}
+	s.subscriptions[uri] = true
+}
+
+// Unsubscribe 取消订阅资源
+func (s *MCPSession) Unsubscribe(uri string) {
+	s.mu.Lock()
+	delete(s.subscriptions, uri)
+	s.mu.Unlock()
+}
+
+// Subscriptions 返回当前订阅的资源URI列表
+func (s *MCPSession) Subscriptions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uris := make([]string, 0, len(s.subscriptions))
+	for uri := range s.subscriptions {
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// newSessionID 生成随机会话ID
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// createSession 创建并登记一个新会话
+func (m *MCPServer) createSession(id string) *MCPSession {
+	m.cleanupSessions()
+	if id == "" {
+		id = newSessionID()
+	}
+	now := time.Now()
+	session := &MCPSession{
+		ID:         id,
+		CreatedAt:  now,
+		LastActive: now,
+	}
+	m.sessionsMu.Lock()
+	m.sessions[id] = session
+	m.sessionsMu.Unlock()
+	return session
+}
+
+// getSession 根据ID获取会话
+func (m *MCPServer) getSession(id string) *MCPSession {
+	if id == "" {
+		return nil
+	}
+	m.sessionsMu.RLock()
+	session := m.sessions[id]
+	m.sessionsMu.RUnlock()
+	if session != nil {
+		session.touch()
+	}
+	return session
+}
+
+// removeSession 删除会话并关闭其推送通道
+func (m *MCPServer) removeSession(id string) bool {
+	m.sessionsMu.Lock()
+	_, exists := m.sessions[id]
+	delete(m.sessions, id)
+	m.sessionsMu.Unlock()
+
+	m.clientsMu.Lock()
+	if ch, ok := m.clients[id]; ok {
+		close(ch)
+		delete(m.clients, id)
+	}
+	m.clientsMu.Unlock()
+	return exists
+}
+
+// cleanupSessions 清理长时间无活动且没有推送连接的会话
+func (m *MCPServer) cleanupSessions() {
+	deadline := time.Now().Add(-mcpSessionIdleTimeout)
+	var expired []string
+	m.sessionsMu.RLock()
+	for id, session := range m.sessions {
+		if session.idleSince().Before(deadline) {
+			expired = append(expired, id)
+		}
+	}
+	m.sessionsMu.RUnlock()
+	for _, id := range expired {
+		m.clientsMu.RLock()
+		_, streaming := m.clients[id]
+		m.clientsMu.RUnlock()
+		if !streaming {
+			m.removeSession(id)
+		}
+	}
+}
+
+// allSessions 返回当前所有会话
+func (m *MCPServer) allSessions() []*MCPSession {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+	list := make([]*MCPSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		list = append(list, session)
+	}
+	return list
+}
+
+// SessionCount 获取当前会话数量
+func (m *MCPServer) SessionCount() int {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+	return len(m.sessions)
+}
+
+// registerClient 登记推送通道，同一ID已存在时返回false
+func (m *MCPServer) registerClient(id string) (chan []byte, bool) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	if _, exists := m.clients[id]; exists {
+		return nil, false
+	}
+	ch := make(chan []byte, 100)
+	m.clients[id] = ch
+	return ch, true
+}
+
+// unregisterClient 注销推送通道（通道可能已被 Stop/removeSession 关闭）
+func (m *MCPServer) unregisterClient(id string, ch chan []byte) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	if cur, ok := m.clients[id]; ok && cur == ch {
+		close(ch)
+		delete(m.clients, id)
+	}
+}
+
+// handleStreamableHTTP Streamable HTTP 传输端点（单一端点：POST消息、GET推送流、DELETE结束会话）
+func (m *MCPServer) handleStreamableHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		if _, ok := m.checkProtocolVersionHeader(w, r); !ok {
+			return
+		}
+	}
+	switch r.Method {
+	case "POST":
+		m.handleMCP(w, r)
+	case "GET":
+		m.handleStreamGET(w, r)
+	case "DELETE":
+		m.handleSessionDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStreamGET 为会话打开服务端推送的SSE流
+func (m *MCPServer) handleStreamGET(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "GET 请求必须接受 text/event-stream", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID := r.Header.Get(mcpSessionHeader)
+	if sessionID == "" {
+		http.Error(w, "缺少 "+mcpSessionHeader+" 请求头", http.StatusBadRequest)
+		return
+	}
+	if m.getSession(sessionID) == nil {
+		http.Error(w, "会话不存在或已过期", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持SSE", http.StatusInternalServerError)
+		return
+	}
+
+	messageChan, ok := m.registerClient(sessionID)
+	if !ok {
+		http.Error(w, "该会话已存在推送流", http.StatusConflict)
+		return
+	}
+	defer m.unregisterClient(sessionID, messageChan)
+
+	// 推送流为长连接，取消服务器的写超时
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set(mcpSessionHeader, sessionID)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messageChan:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(msg))
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// handleSessionDelete 客户端主动结束会话
+func (m *MCPServer) handleSessionDelete(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Header.Get(mcpSessionHeader)
+	if sessionID == "" {
+		http.Error(w, "缺少 "+mcpSessionHeader+" 请求头", http.StatusBadRequest)
+		return
+	}
+	if !m.removeSession(sessionID) {
+		http.Error(w, "会话不存在或已过期", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSessionNotFound 会话ID无效时按规范返回404
+func (m *MCPServer) writeSessionNotFound(w http.ResponseWriter, id interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    -32001,
+			Message: "会话不存在",
+			Data:    "会话不存在或已过期，请重新初始化",
+		},
+	})
+}
+
+// Version 返回会话协商的协议版本（未初始化时为空）
+func (s *MCPSession) Version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ProtocolVersion
+}
+
+// SupportsElicitation 客户端是否声明了 elicitation 能力（2025-06-18 起）
+func (s *MCPSession) SupportsElicitation() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ProtocolVersion != "" && !versionSupports(s.ProtocolVersion, featureElicitation) {
+		return false
+	}
+	_, ok := s.Capabilities["elicitation"]
+	return ok
+}
+
+// withSession 把当前会话放入请求上下文
+func withSession(ctx context.Context, session *MCPSession) context.Context {
+	if session == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+// sessionFromContext 读取请求上下文中的会话
+func sessionFromContext(ctx context.Context) *MCPSession {
+	session, _ := ctx.Value(sessionKey).(*MCPSession)
+	return session
+}
+
+// hasClient 会话是否有打开的SSE通道
+func (m *MCPServer) hasClient(id string) bool {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+	_, ok := m.clients[id]
+	return ok
+}
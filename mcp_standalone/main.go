@@ -4,268 +4,559 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-// 独立的MCP服务器 - 通过stdio与Cursor通信，快速启动
+// 独立的MCP桥接程序 - 通过stdio与Cursor通信，把消息原样转发给SunnyNet主程序
+// 主程序的地址与令牌从 ~/Sunny/mcp_runtime.json 发现；通过 /mcp/sse 接收服务端通知，
+// 连接断开后按退避时间重连，并用客户端原来的 initialize 参数重新初始化会话
 
-func main() {
-	reader := bufio.NewReader(os.Stdin)
+// 默认的服务地址（主程序未写入发现文件时使用）
+const defaultMCPURL = "http://127.0.0.1:29999/mcp"
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
-		}
+// 重连退避时间
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+// 请求在主程序未连接时等待重连的最长时间
+const connectWait = 5 * time.Second
 
-		var request map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &request); err != nil {
-			continue
-		}
+// 支持的MCP协议版本（最新的在前），与主程序一致
+var supportedVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
 
-		// 检查是否是通知消息（没有id的请求不需要响应）
-		id := request["id"]
-		method, _ := request["method"].(string)
-		
-		// 通知消息不需要响应
-		if id == nil || strings.HasPrefix(method, "notifications/") {
-			handleNotification(request)
-			continue
+// negotiateVersion 协商协议版本，与主程序的 negotiateVersion（mcp_negotiate.go）一致：
+// 支持客户端请求的版本时使用该版本，其他格式正确的版本回复最新版本，版本号格式不正确时返回空
+func negotiateVersion(requested string) string {
+	for _, v := range supportedVersions {
+		if v == requested {
+			return v
 		}
+	}
+	if _, err := time.Parse("2006-01-02", requested); err == nil {
+		return supportedVersions[0]
+	}
+	return ""
+}
+
+// errUnauthorized 访问令牌无效
+var errUnauthorized = errors.New("访问令牌无效，请设置环境变量 SUNNYNET_MCP_TOKEN 或检查 ~/Sunny/Config.json")
+
+// runtimeInfo 主程序写入的发现文件
+type runtimeInfo struct {
+	PID    int    `json:"pid"`
+	URL    string `json:"url"`
+	SSEURL string `json:"sseUrl"`
+	Token  string `json:"token"`
+}
 
-		response := handleRequest(request)
-		respBytes, _ := json.Marshal(response)
-		fmt.Println(string(respBytes))
+// discover 返回主程序的SSE端点与访问令牌
+// 优先级：环境变量 SUNNYNET_MCP_URL / SUNNYNET_MCP_TOKEN > 发现文件 > 默认地址与 ~/Sunny/Config.json 中的令牌
+func discover() (sseURL, token string) {
+	mcpURL := defaultMCPURL
+	homeDir, _ := os.UserHomeDir()
+	if homeDir != "" {
+		var info runtimeInfo
+		bs, err := os.ReadFile(filepath.Join(homeDir, "Sunny", "mcp_runtime.json"))
+		if err == nil && json.Unmarshal(bs, &info) == nil && info.URL != "" {
+			mcpURL = info.URL
+			sseURL = info.SSEURL
+			token = info.Token
+		}
+	}
+	if u := os.Getenv("SUNNYNET_MCP_URL"); u != "" {
+		mcpURL = u
+		sseURL = ""
+	}
+	if sseURL == "" {
+		sseURL = strings.TrimSuffix(mcpURL, "/") + "/sse"
 	}
+	if t := os.Getenv("SUNNYNET_MCP_TOKEN"); t != "" {
+		token = t
+	} else if token == "" && homeDir != "" {
+		token = configToken(homeDir)
+	}
+	return sseURL, token
 }
 
-func handleNotification(request map[string]interface{}) {
-	// 通知消息不需要响应，静默处理
+// configToken 读取配置文件中的访问令牌
+func configToken(homeDir string) string {
+	bs, err := os.ReadFile(filepath.Join(homeDir, "Sunny", "Config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		MCP struct {
+			Token string `json:"Token"`
+		} `json:"MCP"`
+	}
+	if json.Unmarshal(bs, &config) != nil {
+		return ""
+	}
+	return config.MCP.Token
 }
 
-func handleRequest(request map[string]interface{}) map[string]interface{} {
-	method, _ := request["method"].(string)
-	id := request["id"]
-	params, _ := request["params"].(map[string]interface{})
+// bridge 与主程序的连接状态
+type bridge struct {
+	mu       sync.Mutex
+	endpoint string        // 当前会话的消息端点，未连接时为空
+	token    string        // 当前连接使用的令牌
+	ready    chan struct{} // 连接可用时关闭，断开后替换为新的通道
+	conn     int           // 连接序号，过期连接的回调不再修改状态
 
-	switch method {
-	case "initialize":
-		return successResponse(id, map[string]interface{}{
-			"protocolVersion": "2024-11-05",
-			"capabilities": map[string]interface{}{
-				"tools": map[string]interface{}{"listChanged": true},
-			},
-			"serverInfo": map[string]interface{}{
-				"name":    "SunnyNet-MCP",
-				"version": "1.0.0",
-			},
-		})
+	initParams      map[string]interface{} // 客户端的 initialize 参数，重连后用于重新初始化
+	protocolVersion string                 // 与客户端协商的协议版本
 
-	case "initialized", "notifications/initialized":
-		return successResponse(id, map[string]interface{}{})
+	outMu   sync.Mutex
+	pending sync.WaitGroup // 转发中的消息，输入结束后等待它们完成
+	http    *http.Client
+}
 
-	case "ping":
-		return successResponse(id, map[string]interface{}{})
+func newBridge() *bridge {
+	return &bridge{ready: make(chan struct{}), http: &http.Client{}}
+}
 
-	case "tools/list":
-		return successResponse(id, map[string]interface{}{
-			"tools": getToolsList(),
-		})
+// emit 向客户端输出一行消息
+func (b *bridge) emit(message []byte) {
+	var buf bytes.Buffer
+	if json.Compact(&buf, message) != nil {
+		return
+	}
+	buf.WriteByte('\n')
+	b.outMu.Lock()
+	os.Stdout.Write(buf.Bytes())
+	b.outMu.Unlock()
+}
 
-	case "tools/call":
-		return handleToolCall(id, params)
+func (b *bridge) emitValue(v interface{}) {
+	bs, _ := json.Marshal(v)
+	b.emit(bs)
+}
 
+// logf 诊断信息输出到标准错误，标准输出只用于MCP消息
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "[sunnynet-mcp] "+format+"\n", args...)
+}
+
+// setEndpoint 第conn次连接建立（endpoint非空）或断开（endpoint为空）
+func (b *bridge) setEndpoint(conn int, endpoint, token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if conn != b.conn {
+		return
+	}
+	b.endpoint = endpoint
+	b.token = token
+	if endpoint == "" {
+		// 断开后该连接上还未完成的初始化不再生效
+		b.conn++
+		b.ready = make(chan struct{})
+		return
+	}
+	select {
+	case <-b.ready:
 	default:
-		return errorResponse(id, -32601, "Method not found", method)
+		close(b.ready)
 	}
 }
 
-func successResponse(id interface{}, result interface{}) map[string]interface{} {
-	return map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      id,
-		"result":  result,
+// waitEndpoint 等待连接可用
+func (b *bridge) waitEndpoint(timeout time.Duration) (string, string, error) {
+	b.mu.Lock()
+	endpoint, token, ready := b.endpoint, b.token, b.ready
+	b.mu.Unlock()
+	if endpoint != "" {
+		return endpoint, token, nil
+	}
+	select {
+	case <-ready:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.endpoint == "" {
+			return "", "", errors.New("SunnyNet 主程序连接已断开")
+		}
+		return b.endpoint, b.token, nil
+	case <-time.After(timeout):
+		return "", "", errors.New("无法连接 SunnyNet 主程序，请确认已在界面中启动MCP服务")
 	}
 }
 
-func errorResponse(id interface{}, code int, message string, data interface{}) map[string]interface{} {
-	return map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      id,
-		"error": map[string]interface{}{
-			"code":    code,
-			"message": message,
-			"data":    data,
-		},
+// run 保持与主程序的SSE连接，断开后按指数退避重连
+func (b *bridge) run() {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := b.connect()
+		// 连接保持过一段时间说明不是持续失败，重新从最短退避开始
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		logf("与 SunnyNet 的连接断开: %v，%s 后重连", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
 }
 
-func toolResult(text string, isError bool) map[string]interface{} {
-	return map[string]interface{}{
-		"content": []map[string]interface{}{
-			{"type": "text", "text": text},
-		},
-		"isError": isError,
+// connect 建立SSE连接并持续读取服务端消息，直到连接断开
+func (b *bridge) connect() error {
+	sseURL, token := discover()
+	req, err := http.NewRequest("GET", sseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("SSE连接失败: %s", resp.Status)
+	}
+
+	b.mu.Lock()
+	b.conn++
+	conn := b.conn
+	b.mu.Unlock()
+	connected := false
+	defer func() {
+		if connected {
+			b.setEndpoint(conn, "", "")
+		}
+	}()
+	return readEvents(resp.Body, func(event, data string) {
+		switch event {
+		case "endpoint":
+			if connected {
+				return
+			}
+			connected = true
+			go b.onConnected(conn, data, token, resp.Body)
+		case "message", "":
+			// 响应以HTTP响应为准，SSE中只转发通知与服务端请求
+			var msg struct {
+				Method string `json:"method"`
+			}
+			if json.Unmarshal([]byte(data), &msg) == nil && msg.Method != "" {
+				b.emit([]byte(data))
+			}
+		}
+	})
 }
 
-func getToolsList() []map[string]interface{} {
-	return []map[string]interface{}{
-		tool("proxy_start", "启动SunnyNet代理服务", nil),
-		tool("proxy_stop", "停止SunnyNet代理服务", nil),
-		tool("proxy_set_port", "设置代理端口号", map[string]interface{}{
-			"port": prop("integer", "代理端口号 (1-65535)"),
-		}, "port"),
-		tool("proxy_get_status", "获取代理服务状态", nil),
-		tool("request_list", "获取已捕获的HTTP请求列表", map[string]interface{}{
-			"limit":  prop("integer", "返回的最大数量，默认100"),
-			"offset": prop("integer", "偏移量，用于分页"),
-		}),
-		tool("request_get", "获取指定请求的详细信息", map[string]interface{}{
-			"theology": prop("integer", "请求的唯一ID"),
-		}, "theology"),
-		tool("config_get", "获取SunnyNet当前配置信息", nil),
-		tool("cert_install", "安装CA证书到系统信任列表", nil),
-		tool("cert_export", "导出CA证书到指定路径", map[string]interface{}{
-			"path": prop("string", "证书导出路径"),
-		}, "path"),
-		tool("process_list", "获取当前系统运行的进程列表", nil),
-		tool("process_add_name", "添加要拦截的进程名", map[string]interface{}{
-			"name": prop("string", "进程名称"),
-		}, "name"),
-		tool("process_remove_name", "移除已添加的拦截进程名", map[string]interface{}{
-			"name": prop("string", "进程名称"),
-		}, "name"),
-		tool("request_modify_header", "修改指定请求的请求头", map[string]interface{}{
-			"theology": prop("integer", "请求ID"),
-			"key":      prop("string", "请求头名称"),
-			"value":    prop("string", "请求头值"),
-		}, "theology", "key", "value"),
-		tool("request_modify_body", "修改指定请求的请求体", map[string]interface{}{
-			"theology": prop("integer", "请求ID"),
-			"body":     prop("string", "新的请求体"),
-		}, "theology", "body"),
-		tool("response_modify_header", "修改指定请求的响应头", map[string]interface{}{
-			"theology": prop("integer", "请求ID"),
-			"key":      prop("string", "响应头名称"),
-			"value":    prop("string", "响应头值"),
-		}, "theology", "key", "value"),
-		tool("response_modify_body", "修改指定请求的响应体", map[string]interface{}{
-			"theology": prop("integer", "请求ID"),
-			"body":     prop("string", "新的响应体"),
-		}, "theology", "body"),
-		tool("request_block", "阻断/拦截指定的请求", map[string]interface{}{
-			"theology": prop("integer", "请求ID"),
-		}, "theology"),
-		tool("request_release_all", "放行所有被拦截的请求", nil),
-		tool("decrypt_packet", "解密单个数据包", map[string]interface{}{
-			"data": prop("string", "数据包的十六进制字符串"),
-		}, "data"),
-		tool("parse_protobuf", "解析Protobuf数据", map[string]interface{}{
-			"data": prop("string", "Protobuf数据的十六进制字符串"),
-		}, "data"),
-		tool("crypto_config_get", "获取当前加密配置", nil),
-		tool("crypto_config_set", "设置加密配置", map[string]interface{}{
-			"name":        prop("string", "配置名称"),
-			"aes_key":     prop("string", "AES密钥"),
-			"aes_iv":      prop("string", "AES IV"),
-			"header_size": prop("integer", "头部大小"),
-		}, "name", "aes_key", "aes_iv"),
-		tool("crypto_config_list", "列出所有加密配置", nil),
-		tool("decrypt_tcp_flow", "解密TCP连接数据流", map[string]interface{}{
-			"theology": prop("integer", "TCP连接ID"),
-		}, "theology"),
-		// 替换规则类
-		tool("replace_rules_list", "列出当前所有替换规则", nil),
-		tool("replace_rules_add", "添加新的替换规则", map[string]interface{}{
-			"type":   prop("string", "替换类型：Base64、HEX、String(UTF8)、String(GBK)、响应文件"),
-			"source": prop("string", "源内容（要匹配的内容）"),
-			"target": prop("string", "替换内容"),
-		}, "type", "source", "target"),
-		tool("replace_rules_remove", "删除指定的替换规则", map[string]interface{}{
-			"hash": prop("string", "规则的唯一标识Hash"),
-		}, "hash"),
-		tool("replace_rules_clear", "清空所有替换规则", nil),
+// onConnected 新会话建立后，客户端已初始化过时先重新初始化，再开放给请求使用；
+// 重新初始化失败时关闭SSE连接 stream，由 run 重新连接
+func (b *bridge) onConnected(conn int, endpoint, token string, stream io.Closer) {
+	b.mu.Lock()
+	params := b.initParams
+	b.mu.Unlock()
+	if params == nil {
+		b.setEndpoint(conn, endpoint, token)
+		logf("已连接 SunnyNet: %s", endpoint)
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": "bridge-init", "method": "initialize", "params": params})
+	if _, err := b.post(endpoint, token, body, false, nil); err != nil {
+		logf("重新初始化失败: %v，重新连接", err)
+		_ = stream.Close()
+		return
+	}
+	body, _ = json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/initialized"})
+	_, _ = b.post(endpoint, token, body, false, nil)
+	b.setEndpoint(conn, endpoint, token)
+	logf("已重新连接 SunnyNet: %s", endpoint)
+	// 主程序可能已重启，通知客户端重新获取列表
+	for _, method := range []string{"notifications/tools/list_changed", "notifications/resources/list_changed", "notifications/prompts/list_changed"} {
+		b.emitValue(map[string]interface{}{"jsonrpc": "2.0", "method": method})
 	}
 }
 
-func tool(name, desc string, props map[string]interface{}, required ...string) map[string]interface{} {
-	if props == nil {
-		props = map[string]interface{}{}
+// readEvents 解析SSE事件流
+func readEvents(r io.Reader, handle func(event, data string)) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("连接被关闭")
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				handle(event, strings.Join(data, "\n"))
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// 心跳
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
 	}
-	if required == nil {
-		required = []string{}
+}
+
+// post 向会话的消息端点发送消息；响应为SSE流时边读边转发（进度通知、服务端请求与最终响应）
+// emit 为nil时只返回最后一条消息
+func (b *bridge) post(endpoint, token string, body []byte, withVersion bool, emit func([]byte)) ([]byte, error) {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	return map[string]interface{}{
-		"name":        name,
-		"description": desc,
-		"inputSchema": map[string]interface{}{
-			"type":       "object",
-			"properties": props,
-			"required":   required,
-		},
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if withVersion {
+		b.mu.Lock()
+		version := b.protocolVersion
+		b.mu.Unlock()
+		if version != "" {
+			req.Header.Set("MCP-Protocol-Version", version)
+		}
+	}
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, nil
 	}
-}
 
-func prop(typ, desc string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":        typ,
-		"description": desc,
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var last []byte
+		err = readEvents(resp.Body, func(_, data string) {
+			last = []byte(data)
+			if emit != nil {
+				emit(last)
+			}
+		})
+		if last != nil {
+			return last, nil
+		}
+		return nil, err
 	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	respBody = bytes.TrimSpace(respBody)
+	// 错误状态码也可能带有JSON-RPC错误（例如不支持的协议版本），原样转发
+	if !json.Valid(respBody) {
+		return nil, fmt.Errorf("主程序返回 %s", resp.Status)
+	}
+	if emit != nil {
+		emit(respBody)
+	}
+	return respBody, nil
 }
 
-func handleToolCall(id interface{}, params map[string]interface{}) map[string]interface{} {
-	name, _ := params["name"].(string)
-	args, _ := params["arguments"].(map[string]interface{})
-	if args == nil {
-		args = map[string]interface{}{}
+// forward 把客户端消息转发给主程序，转发失败时返回错误
+func (b *bridge) forward(body []byte) error {
+	endpoint, token, err := b.waitEndpoint(connectWait)
+	if err != nil {
+		return err
 	}
+	_, err = b.post(endpoint, token, body, true, b.emit)
+	return err
+}
+
+func main() {
+	b := newBridge()
+	go b.run()
 
-	result := callSunnyNetAPI(name, args)
-	return successResponse(id, toolResult(result, false))
+	reader := bufio.NewReaderSize(os.Stdin, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			b.handleLine(trimmed)
+		}
+		if err != nil {
+			break
+		}
+	}
+	b.pending.Wait()
 }
 
-func callSunnyNetAPI(toolName string, args map[string]interface{}) string {
-	body := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  "tools/call",
-		"params": map[string]interface{}{
-			"name":      toolName,
-			"arguments": args,
-		},
+// handleLine 处理一行输入：initialize 同步处理，其他消息并发转发，
+// 以便工具执行期间仍能转发取消通知与 elicitation 响应
+func (b *bridge) handleLine(line []byte) {
+	if !json.Valid(line) {
+		b.emitValue(errorResponse(nil, -32700, "Parse error", "无效的JSON"))
+		return
 	}
+	if line[0] == '[' {
+		b.pending.Add(1)
+		go func(line []byte) {
+			defer b.pending.Done()
+			b.forwardBatch(line)
+		}(append([]byte(nil), line...))
+		return
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal(line, &request); err != nil {
+		b.emitValue(errorResponse(nil, -32600, "Invalid Request", "消息必须是JSON对象"))
+		return
+	}
+	if request["method"] == "initialize" {
+		b.handleInitialize(request, line)
+		return
+	}
+	b.pending.Add(1)
+	go func(line []byte) {
+		defer b.pending.Done()
+		b.handleMessage(request, line)
+	}(append([]byte(nil), line...))
+}
 
-	jsonBody, _ := json.Marshal(body)
-	resp, err := http.Post("http://127.0.0.1:29999/mcp", "application/json", bytes.NewReader(jsonBody))
-	if err != nil {
-		return fmt.Sprintf(`{"error": "连接SunnyNet失败: %v"}`, err)
+// handleInitialize 记录客户端参数后转发；主程序暂不可用时由桥接程序应答，连接后自动初始化
+func (b *bridge) handleInitialize(request map[string]interface{}, line []byte) {
+	id := request["id"]
+	params, _ := request["params"].(map[string]interface{})
+	requested, _ := params["protocolVersion"].(string)
+	version := negotiateVersion(requested)
+	if version == "" {
+		b.emitValue(errorResponse(id, -32602, "Unsupported protocol version", map[string]interface{}{
+			"supported": supportedVersions,
+			"requested": requested,
+		}))
+		return
 	}
-	defer resp.Body.Close()
+	b.mu.Lock()
+	b.protocolVersion = version
+	b.mu.Unlock()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	// 应答之后才记录参数：之后建立的连接都需要先用这些参数重新初始化
+	err := b.forward(line)
+	b.mu.Lock()
+	b.initParams = params
+	b.mu.Unlock()
+	if err == nil {
+		return
+	}
+	logf("主程序不可用，由桥接程序应答 initialize: %v", err)
+	capabilities := map[string]interface{}{
+		"tools":     map[string]interface{}{"listChanged": true},
+		"resources": map[string]interface{}{"subscribe": true, "listChanged": true},
+		"prompts":   map[string]interface{}{"listChanged": true},
+		"logging":   map[string]interface{}{},
+	}
+	if version >= "2025-03-26" {
+		capabilities["completions"] = map[string]interface{}{}
+	}
+	b.emitValue(successResponse(id, map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities":    capabilities,
+		"serverInfo": map[string]interface{}{
+			"name":    "SunnyNet-MCP",
+			"version": "1.0.0",
+		},
+	}))
+}
 
-	var result map[string]interface{}
-	json.Unmarshal(respBody, &result)
+// handleMessage 转发单条消息；请求转发失败时回复错误（tools/call 以 isError 结果回复）
+func (b *bridge) handleMessage(request map[string]interface{}, line []byte) {
+	err := b.forward(line)
+	if err == nil {
+		return
+	}
+	id, hasID := request["id"]
+	method, _ := request["method"].(string)
+	if !hasID || method == "" {
+		// 通知与客户端响应无法回复
+		logf("转发 %s 失败: %v", method, err)
+		return
+	}
+	switch method {
+	case "ping":
+		b.emitValue(successResponse(id, map[string]interface{}{}))
+	case "tools/call":
+		b.emitValue(successResponse(id, toolResult("连接SunnyNet失败: "+err.Error(), true)))
+	default:
+		b.emitValue(errorResponse(id, -32603, "Internal error", "连接SunnyNet失败: "+err.Error()))
+	}
+}
 
-	if res, ok := result["result"].(map[string]interface{}); ok {
-		if content, ok := res["content"].([]interface{}); ok && len(content) > 0 {
-			if first, ok := content[0].(map[string]interface{}); ok {
-				if text, ok := first["text"].(string); ok {
-					return text
-				}
-			}
+// forwardBatch 转发批量消息，失败时为其中每个请求回复错误
+func (b *bridge) forwardBatch(line []byte) {
+	err := b.forward(line)
+	if err == nil {
+		return
+	}
+	var items []map[string]interface{}
+	_ = json.Unmarshal(line, &items)
+	responses := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		id, hasID := item["id"]
+		method, _ := item["method"].(string)
+		if !hasID || method == "" {
+			continue
 		}
+		if method == "tools/call" {
+			responses = append(responses, successResponse(id, toolResult("连接SunnyNet失败: "+err.Error(), true)))
+		} else {
+			responses = append(responses, errorResponse(id, -32603, "Internal error", "连接SunnyNet失败: "+err.Error()))
+		}
+	}
+	if len(responses) > 0 {
+		b.emitValue(responses)
+	}
+}
+
+func successResponse(id interface{}, result interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"result":  result,
+	}
+}
+
+func errorResponse(id interface{}, code int, message string, data interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"data":    data,
+		},
 	}
+}
 
-	return string(respBody)
+func toolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]interface{}{
+			{"type": "text", "text": text},
+		},
+		"isError": isError,
+	}
 }
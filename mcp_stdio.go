@@ -9,13 +9,74 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 // stdio模式的MCP服务器
 // 通过标准输入/输出与Cursor通信
 
+// SunnyNet MCP 服务地址，可通过环境变量 SUNNYNET_MCP_URL 覆盖
+var mcpURL = "http://127.0.0.1:29999/mcp"
+
+// 访问令牌，优先使用环境变量 SUNNYNET_MCP_TOKEN，否则读取 ~/Sunny/Config.json
+var mcpToken string
+
+// 与客户端协商的协议版本，转发请求时通过 MCP-Protocol-Version 头告知主程序
+var protocolVersion string
+
+func loadBridgeConfig() {
+	if u := os.Getenv("SUNNYNET_MCP_URL"); u != "" {
+		mcpURL = u
+	}
+	mcpToken = os.Getenv("SUNNYNET_MCP_TOKEN")
+	if mcpToken != "" {
+		return
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	bs, err := os.ReadFile(filepath.Join(homeDir, "Sunny", "Config.json"))
+	if err != nil {
+		return
+	}
+	var config struct {
+		MCP struct {
+			Token string `json:"Token"`
+		} `json:"MCP"`
+	}
+	if json.Unmarshal(bs, &config) == nil {
+		mcpToken = config.MCP.Token
+	}
+}
+
+// postMCP 向主程序发送请求（携带访问令牌）
+func postMCP(body string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", mcpURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if mcpToken != "" {
+		req.Header.Set("Authorization", "Bearer "+mcpToken)
+	}
+	if protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", protocolVersion)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("访问令牌无效，请设置环境变量 SUNNYNET_MCP_TOKEN")
+	}
+	return resp, nil
+}
+
 func main() {
+	loadBridgeConfig()
 	reader := bufio.NewReader(os.Stdin)
 	
 	for {
@@ -33,32 +94,118 @@ func main() {
 			continue
 		}
 		
-		// 解析请求
-		var request map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &request); err != nil {
-			continue
+		if out := handleStdioLine([]byte(line)); out != nil {
+			fmt.Println(string(out))
 		}
-		
-		// 处理请求
-		response := handleStdioRequest(request)
-		
-		// 输出响应
-		respBytes, _ := json.Marshal(response)
-		fmt.Println(string(respBytes))
 	}
 }
 
+// handleStdioLine 处理一行输入（单条消息或批量数组），不需要输出时返回nil
+func handleStdioLine(line []byte) []byte {
+	if !json.Valid(line) {
+		return marshalStdio(stdioError(nil, -32700, "Parse error"))
+	}
+	if line[0] != '[' {
+		response := handleStdioMessage(line)
+		if response == nil {
+			return nil
+		}
+		return marshalStdio(response)
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(line, &items); err != nil {
+		return marshalStdio(stdioError(nil, -32700, "Parse error"))
+	}
+	if len(items) == 0 {
+		return marshalStdio(stdioError(nil, -32600, "Invalid Request"))
+	}
+	responses := make([]map[string]interface{}, 0, len(items))
+	for _, raw := range items {
+		if response := handleStdioMessage(raw); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	// 批量中全部是通知时不输出
+	if len(responses) == 0 {
+		return nil
+	}
+	return marshalStdio(responses)
+}
+
+func marshalStdio(v interface{}) []byte {
+	bs, _ := json.Marshal(v)
+	return bs
+}
+
+func stdioError(id interface{}, code int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	}
+}
+
+// handleStdioMessage 处理单条消息，通知和客户端响应返回nil
+func handleStdioMessage(raw []byte) map[string]interface{} {
+	var request map[string]interface{}
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return stdioError(nil, -32600, "Invalid Request")
+	}
+	id, hasID := request["id"]
+	method, _ := request["method"].(string)
+	if request["jsonrpc"] != "2.0" {
+		return stdioError(id, -32600, "Invalid Request")
+	}
+	if method == "" {
+		// 客户端对服务端请求的响应，不需要回复
+		_, hasResult := request["result"]
+		_, hasError := request["error"]
+		if hasID && (hasResult || hasError) {
+			return nil
+		}
+		return stdioError(id, -32600, "Invalid Request")
+	}
+	// 通知（没有id）不需要响应
+	if !hasID {
+		return nil
+	}
+	return handleStdioRequest(request)
+}
+
 func handleStdioRequest(request map[string]interface{}) map[string]interface{} {
 	method, _ := request["method"].(string)
 	id := request["id"]
 	
 	switch method {
 	case "initialize":
+		params, _ := request["params"].(map[string]interface{})
+		requested, _ := params["protocolVersion"].(string)
+		// 与主程序使用同一协商逻辑（mcp_negotiate.go）
+		version, ok := negotiateVersion(requested)
+		if !ok {
+			return map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      id,
+				"error": map[string]interface{}{
+					"code":    -32602,
+					"message": "Unsupported protocol version",
+					"data": map[string]interface{}{
+						"supported": mcpSupportedVersions,
+						"requested": requested,
+					},
+				},
+			}
+		}
+		protocolVersion = version
 		return map[string]interface{}{
 			"jsonrpc": "2.0",
 			"id":      id,
 			"result": map[string]interface{}{
-				"protocolVersion": "2024-11-05",
+				"protocolVersion": version,
 				"capabilities": map[string]interface{}{
 					"tools": map[string]interface{}{
 						"listChanged": true,
@@ -71,7 +218,7 @@ func handleStdioRequest(request map[string]interface{}) map[string]interface{} {
 			},
 		}
 	
-	case "initialized":
+	case "initialized", "notifications/initialized":
 		return map[string]interface{}{
 			"jsonrpc": "2.0",
 			"id":      id,
@@ -118,8 +265,7 @@ func handleStdioRequest(request map[string]interface{}) map[string]interface{} {
 }
 
 func getToolsFromMain() []interface{} {
-	resp, err := http.Post("http://127.0.0.1:29999/mcp", "application/json", 
-		strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{}}`))
+	resp, err := postMCP(`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{}}`)
 	if err != nil {
 		return []interface{}{}
 	}
@@ -144,8 +290,7 @@ func callToolOnMain(params map[string]interface{}) map[string]interface{} {
 		"params":  params,
 	})
 	
-	resp, err := http.Post("http://127.0.0.1:29999/mcp", "application/json", 
-		strings.NewReader(string(body)))
+	resp, err := postMCP(string(body))
 	if err != nil {
 		return map[string]interface{}{
 			"content": []map[string]interface{}{
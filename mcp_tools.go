@@ -1,15 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"changeme/CommAnd"
 	"changeme/MapHash"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/qtgolang/SunnyNet/public"
@@ -21,23 +24,13 @@ type MCPTool struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	InputSchema map[string]interface{} `json:"inputSchema"`
+	// OutputSchema 结果的JSON Schema，设置后结果同时以 structuredContent 返回
+	OutputSchema map[string]interface{} `json:"outputSchema,omitempty"`
+	// Annotations 行为提示（只读/破坏性），同时决定只读模式下是否允许调用
+	Annotations *MCPToolAnnotations `json:"annotations,omitempty"`
 }
 
-// 工具列表缓存，使用 sync.Once 确保只构建一次
-var (
-	cachedTools []MCPTool
-	toolsOnce   sync.Once
-)
-
-// GetToolsList 返回所有MCP工具定义（使用缓存）
-func GetToolsList() []MCPTool {
-	toolsOnce.Do(func() {
-		cachedTools = buildToolsList()
-	})
-	return cachedTools
-}
-
-// buildToolsList 构建工具列表（只在首次调用时执行）
+// buildToolsList 构建内置工具列表（注册表初始化时执行一次）
 func buildToolsList() []MCPTool {
 	return []MCPTool{
 		// ============ 代理控制类 (4个) ============
@@ -85,7 +78,7 @@ func buildToolsList() []MCPTool {
 			},
 		},
 
-		// ============ 请求拦截类 (8个) ============
+		// ============ 请求拦截类 (11个) ============
 		{
 			Name:        "request_list",
 			Description: "获取已捕获的HTTP请求列表",
@@ -102,13 +95,30 @@ func buildToolsList() []MCPTool {
 						"description": "偏移量，用于分页",
 						"default":     0,
 					},
+					"host": map[string]interface{}{
+						"type":        "string",
+						"description": "只返回该主机名的请求（不区分大小写）",
+					},
+					"status": map[string]interface{}{
+						"type":        "integer",
+						"description": "只返回该响应状态码的请求",
+					},
+					"method": map[string]interface{}{
+						"type":        "string",
+						"description": "只返回该请求方式的请求，如 GET、POST",
+					},
+					"after": map[string]interface{}{
+						"type":        "integer",
+						"description": "只返回ID大于该值的请求，用于持续获取新请求",
+					},
 				},
 				"required": []string{},
 			},
+			OutputSchema: outputSchemaOf(RequestListResult{}),
 		},
 		{
 			Name:        "request_get",
-			Description: "获取指定请求的详细信息，包括请求头、请求体、响应头、响应体等",
+			Description: "获取指定请求的详细信息，包括请求头、请求体、响应头、响应体与各阶段耗时（timing）等",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -119,6 +129,39 @@ func buildToolsList() []MCPTool {
 				},
 				"required": []string{"theology"},
 			},
+			OutputSchema: outputSchemaOf(RequestDetail{}),
+		},
+		{
+			Name:        "request_search",
+			Description: "在所有已捕获的会话中搜索内容（URL、请求/响应头与Body、Socket数据），返回匹配的请求ID。支持进度通知与取消",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"value": map[string]interface{}{
+						"type":        "string",
+						"description": "要搜索的内容",
+					},
+					"type": map[string]interface{}{
+						"type":        "string",
+						"description": "内容类型，默认UTF8",
+						"enum":        []string{"UTF8", "GBK", "Hex", "Base64", "pb", "整数4", "整数8", "浮点数4", "浮点数8"},
+						"default":     "UTF8",
+					},
+					"range": map[string]interface{}{
+						"type":        "string",
+						"description": "搜索范围，默认全部",
+						"enum":        []string{"全部", "HTTP请求", "HTTP响应", "socketSend", "socketRec", "socketAll"},
+						"default":     "全部",
+					},
+					"case_sensitive": map[string]interface{}{
+						"type":        "boolean",
+						"description": "是否区分大小写，默认不区分",
+						"default":     false,
+					},
+				},
+				"required": []string{"value"},
+			},
+			OutputSchema: outputSchemaOf(RequestSearchResult{}),
 		},
 		{
 			Name:        "request_modify_header",
@@ -223,6 +266,47 @@ func buildToolsList() []MCPTool {
 				"required":   []string{},
 			},
 		},
+		{
+			Name:        "request_pin",
+			Description: "固定或取消固定请求，固定的请求在 unpinned 淘汰策略下不会因存储上限被淘汰",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"theology": map[string]interface{}{
+						"type":        "integer",
+						"description": "请求的唯一ID (Theology)",
+					},
+					"pinned": map[string]interface{}{
+						"type":        "boolean",
+						"description": "true 固定，false 取消固定，默认 true",
+					},
+				},
+				"required": []string{"theology"},
+			},
+		},
+		{
+			Name:        "session_replay",
+			Description: "重放 TCP（含 TLS）、UDP 或 Websocket 会话：经本地代理重新连接目标，按顺序发送记录中客户端发出的数据，重放产生的新会话会被捕获并关联到原会话",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"theology": map[string]interface{}{
+						"type":        "integer",
+						"description": "要重放的会话ID (Theology)",
+					},
+					"keepTiming": map[string]interface{}{
+						"type":        "boolean",
+						"description": "按记录中的间隔发送，默认 false 连续发送",
+					},
+					"waitMs": map[string]interface{}{
+						"type":        "integer",
+						"description": "发送完成后等待响应的毫秒数，默认 1000",
+					},
+				},
+				"required": []string{"theology"},
+			},
+			OutputSchema: outputSchemaOf(MapHash.ReplayResult{}),
+		},
 
 		// ============ 证书管理类 (2个) ============
 		{
@@ -288,7 +372,7 @@ func buildToolsList() []MCPTool {
 			},
 		},
 
-		// ============ 配置类 (1个) ============
+		// ============ 配置类 (4个) ============
 		{
 			Name:        "config_get",
 			Description: "获取SunnyNet当前配置信息",
@@ -298,6 +382,68 @@ func buildToolsList() []MCPTool {
 				"required":   []string{},
 			},
 		},
+		{
+			Name:        "profile_apply",
+			Description: "原子地应用启动配置文件（JSON），包括端口、上游代理、强制TCP、禁用UDP/TCP/缓存、替换规则、HOSTS规则、请求证书、脚本、加密配置和进程过滤；任何一项无效时不做任何修改",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "配置文件路径，文件中的相对路径按配置文件所在目录解析",
+					},
+					"profile": map[string]interface{}{
+						"type":        "object",
+						"description": "直接提供的配置内容（与 path 二选一），格式同配置文件",
+					},
+				},
+				"required": []string{},
+			},
+		},
+		{
+			Name:        "capture_set_limits",
+			Description: "设置抓包存储上限（会话数、总字节数、保留时长）与淘汰策略，超出后从最早的会话开始淘汰；0 表示不限制，当前用量见 proxy_get_status",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"max_flows": map[string]interface{}{
+						"type":        "integer",
+						"description": "最多保留的会话数",
+						"minimum":     0,
+					},
+					"max_bytes": map[string]interface{}{
+						"type":        "integer",
+						"description": "请求体、响应体与Socket数据的总字节数上限",
+						"minimum":     0,
+					},
+					"max_age": map[string]interface{}{
+						"type":        "integer",
+						"description": "会话保留时长（秒）",
+						"minimum":     0,
+					},
+					"policy": map[string]interface{}{
+						"type":        "string",
+						"description": "淘汰策略：oldest 淘汰最早的会话；unpinned 只淘汰未固定的会话；bodies 只丢弃数据体保留会话信息（会话数超限时仍淘汰会话）",
+						"enum":        []string{MapHash.EvictOldest, MapHash.EvictUnpinned, MapHash.EvictBodies},
+					},
+				},
+				"required": []string{},
+			},
+		},
+		{
+			Name:        "profile_export",
+			Description: "把当前设置导出为启动配置文件内容（不含进程过滤与证书密码）",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{
+						"type":        "string",
+						"description": "配置名称",
+					},
+				},
+				"required": []string{},
+			},
+		},
 
 		// ============ 解密分析类 (6个) ============
 		{
@@ -313,6 +459,7 @@ func buildToolsList() []MCPTool {
 				},
 				"required": []string{"data"},
 			},
+			OutputSchema: outputSchemaOf(DecryptPacketResult{}),
 		},
 		{
 			Name:        "parse_protobuf",
@@ -386,6 +533,7 @@ func buildToolsList() []MCPTool {
 				},
 				"required": []string{"theology"},
 			},
+			OutputSchema: outputSchemaOf(DecryptTcpFlowResult{}),
 		},
 
 		// ============ 替换规则类 (4个) ============
@@ -444,11 +592,123 @@ func buildToolsList() []MCPTool {
 				"required":   []string{},
 			},
 		},
+
+		// ============ 导入导出类 (3个) ============
+		{
+			Name:        "har_export",
+			Description: "把HTTP与Websocket会话导出为HAR 1.2（含请求/响应头、Cookie、查询参数、请求体、响应体、耗时与Websocket消息），可在浏览器开发者工具、Charles等工具中打开",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"theologies": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]interface{}{"type": "integer"},
+						"description": "要导出的会话ID，不填则导出全部",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "HAR文件保存路径，不填则直接在结果中返回HAR内容",
+					},
+				},
+				"required": []string{},
+			},
+		},
+		{
+			Name:        "har_import",
+			Description: "导入HAR文件中的会话到捕获列表，返回新会话的ID",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "HAR文件路径",
+					},
+					"har": map[string]interface{}{
+						"type":        "object",
+						"description": "直接提供的HAR内容（与 path 二选一）",
+					},
+				},
+				"required": []string{},
+			},
+		},
+
+		{
+			Name:        "pcap_export",
+			Description: "把TCP、UDP与Websocket会话导出为PCAP-NG，按会话地址构造IP/TCP/UDP头，每段Socket数据一个数据包，可用Wireshark分析",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"theologies": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]interface{}{"type": "integer"},
+						"description": "要导出的会话ID，不填则导出全部",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "PCAP-NG文件保存路径，不填则在结果中以base64返回文件内容",
+					},
+					"decrypted": map[string]interface{}{
+						"type":        "boolean",
+						"description": "同时导出TLS会话（TLS-TCP、wss）解密后的明文，443端口改为80以便Wireshark按HTTP/Websocket解析",
+						"default":     false,
+					},
+				},
+				"required": []string{},
+			},
+		},
+
+		// ============ 审计类 (1个) ============
+		{
+			Name:        "audit_log_query",
+			Description: "查询MCP工具调用的审计日志（最新的在前），可按工具、会话、会话ID、时间范围过滤，用于还原AI对流量做过的操作",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"tool": map[string]interface{}{
+						"type":        "string",
+						"description": "工具名",
+					},
+					"session": map[string]interface{}{
+						"type":        "string",
+						"description": "客户端会话ID",
+					},
+					"theology": map[string]interface{}{
+						"type":        "integer",
+						"description": "只返回涉及该会话ID的调用",
+					},
+					"since": map[string]interface{}{
+						"type":        "string",
+						"description": "开始时间（RFC3339，如 2024-01-02T15:04:05+08:00）",
+					},
+					"until": map[string]interface{}{
+						"type":        "string",
+						"description": "结束时间（RFC3339）",
+					},
+					"errors_only": map[string]interface{}{
+						"type":        "boolean",
+						"description": "只返回失败的调用",
+						"default":     false,
+					},
+					"limit": map[string]interface{}{
+						"type":        "integer",
+						"description": "返回的最大数量，默认50，最大1000",
+						"default":     50,
+					},
+					"offset": map[string]interface{}{
+						"type":        "integer",
+						"description": "偏移量，用于分页",
+						"default":     0,
+					},
+				},
+				"required": []string{},
+			},
+			OutputSchema: outputSchemaOf(AuditQueryResult{}),
+		},
 	}
 }
 
-// CallTool 调用指定的MCP工具
-func CallTool(name string, args map[string]interface{}) (interface{}, error) {
+// callBuiltinTool 执行内置工具（参数已按 inputSchema 校验）
+func callBuiltinTool(ctx context.Context, name string, args map[string]interface{}) (interface{}, error) {
 	switch name {
 	// ============ 代理控制类 ============
 	case "proxy_start":
@@ -474,13 +734,31 @@ func CallTool(name string, args map[string]interface{}) (interface{}, error) {
 		if o, ok := args["offset"].(float64); ok {
 			offset = int(o)
 		}
-		return toolRequestList(limit, offset)
+		filter := RequestFilter{}
+		filter.Host, _ = args["host"].(string)
+		filter.Method, _ = args["method"].(string)
+		if v, ok := args["status"].(float64); ok {
+			filter.Status = int(v)
+		}
+		if v, ok := args["after"].(float64); ok {
+			filter.After = int(v)
+		}
+		return toolRequestList(limit, offset, filter)
 	case "request_get":
 		theology, ok := args["theology"].(float64)
 		if !ok {
 			return nil, errors.New("参数 theology 必须是整数")
 		}
 		return toolRequestGet(int(theology))
+	case "request_search":
+		value, ok := args["value"].(string)
+		if !ok || value == "" {
+			return nil, errors.New("参数 value 必须是非空字符串")
+		}
+		findType, _ := args["type"].(string)
+		searchRange, _ := args["range"].(string)
+		caseSensitive, _ := args["case_sensitive"].(bool)
+		return toolRequestSearch(ctx, value, findType, searchRange, caseSensitive)
 	case "request_modify_header":
 		theology, ok := args["theology"].(float64)
 		if !ok {
@@ -537,6 +815,24 @@ func CallTool(name string, args map[string]interface{}) (interface{}, error) {
 		return toolRequestBlock(int(theology))
 	case "request_release_all":
 		return toolRequestReleaseAll()
+	case "request_pin":
+		theology, ok := args["theology"].(float64)
+		if !ok {
+			return nil, errors.New("参数 theology 必须是整数")
+		}
+		pinned, ok := args["pinned"].(bool)
+		if !ok {
+			pinned = true
+		}
+		return toolRequestPin(int(theology), pinned)
+	case "session_replay":
+		theology, ok := args["theology"].(float64)
+		if !ok {
+			return nil, errors.New("参数 theology 必须是整数")
+		}
+		keepTiming, _ := args["keepTiming"].(bool)
+		waitMs, _ := args["waitMs"].(float64)
+		return toolSessionReplay(ctx, int(theology), keepTiming, int(waitMs))
 
 	// ============ 证书管理类 ============
 	case "cert_install":
@@ -567,6 +863,14 @@ func CallTool(name string, args map[string]interface{}) (interface{}, error) {
 	// ============ 配置类 ============
 	case "config_get":
 		return toolConfigGet()
+	case "capture_set_limits":
+		return toolCaptureSetLimits(args)
+	case "profile_apply":
+		path, _ := args["path"].(string)
+		return toolProfileApply(path, args["profile"])
+	case "profile_export":
+		name, _ := args["name"].(string)
+		return ExportProfile(name), nil
 
 	// ============ 解密分析类 ============
 	case "decrypt_packet":
@@ -608,7 +912,7 @@ func CallTool(name string, args map[string]interface{}) (interface{}, error) {
 		if !ok {
 			return nil, errors.New("参数 theology 必须是整数")
 		}
-		return toolDecryptTcpFlow(int(theology))
+		return toolDecryptTcpFlow(ctx, int(theology))
 
 	// ============ 替换规则类 ============
 	case "replace_rules_list":
@@ -633,6 +937,44 @@ func CallTool(name string, args map[string]interface{}) (interface{}, error) {
 	case "replace_rules_clear":
 		return toolReplaceRulesClear()
 
+	// ============ 导入导出类 ============
+	case "har_export":
+		path, _ := args["path"].(string)
+		return toolHarExport(ctx, path, theologyList(args["theologies"]))
+	case "har_import":
+		path, _ := args["path"].(string)
+		return toolHarImport(path, args["har"])
+	case "pcap_export":
+		path, _ := args["path"].(string)
+		decrypted, _ := args["decrypted"].(bool)
+		return toolPcapExport(ctx, path, theologyList(args["theologies"]), decrypted)
+
+	// ============ 审计类 ============
+	case "audit_log_query":
+		q := AuditQuery{}
+		q.Tool, _ = args["tool"].(string)
+		q.Session, _ = args["session"].(string)
+		q.ErrorsOnly, _ = args["errors_only"].(bool)
+		if t, ok := args["theology"].(float64); ok {
+			q.Theology = int(t)
+		}
+		if l, ok := args["limit"].(float64); ok {
+			q.Limit = int(l)
+		}
+		if o, ok := args["offset"].(float64); ok {
+			q.Offset = int(o)
+		}
+		for key, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if s, _ := args[key].(string); s != "" {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					return nil, fmt.Errorf("参数 %s 必须是RFC3339时间: %v", key, err)
+				}
+				*dst = t
+			}
+		}
+		return toolAuditLogQuery(ctx, q)
+
 	default:
 		return nil, fmt.Errorf("未知的工具: %s", name)
 	}
@@ -648,6 +990,7 @@ func toolProxyStart() (interface{}, error) {
 
 	err := app.App.Start().Error
 	if err != nil {
+		MCPLog("error", "proxy", "代理服务启动失败: "+err.Error())
 		return map[string]interface{}{
 			"success": false,
 			"error":   err.Error(),
@@ -664,6 +1007,7 @@ func toolProxyStart() (interface{}, error) {
 // toolProxyStop 停止代理服务
 func toolProxyStop() (interface{}, error) {
 	if app == nil || app.App == nil {
+		MCPLog("error", "proxy", "代理服务停止失败: SunnyNet实例未初始化")
 		return nil, errors.New("SunnyNet实例未初始化")
 	}
 
@@ -691,6 +1035,7 @@ func toolProxySetPort(port int) (interface{}, error) {
 	err := app.App.Start().Error
 
 	if err != nil {
+		MCPLog("error", "proxy", fmt.Sprintf("代理服务在端口 %d 上重启失败: %s", port, err.Error()))
 		return map[string]interface{}{
 			"success": false,
 			"error":   err.Error(),
@@ -719,6 +1064,7 @@ func toolProxyGetStatus() (interface{}, error) {
 			"disableTCP":     GlobalConfig.DisableTCP,
 			"disableCache":   GlobalConfig.DisableCache,
 			"authentication": GlobalConfig.Authentication,
+			"capture":        HashMap.Usage(),
 		}, nil
 	}
 
@@ -736,6 +1082,7 @@ func toolProxyGetStatus() (interface{}, error) {
 		"disableCache":   GlobalConfig.DisableCache,
 		"authentication": GlobalConfig.Authentication,
 		"globalProxy":    GlobalConfig.GlobalProxy,
+		"capture":        HashMap.Usage(),
 	}, nil
 }
 
@@ -755,14 +1102,41 @@ type RequestInfo struct {
 	Notes      string `json:"notes"`
 }
 
+// RequestFilter 请求列表的过滤条件（零值表示不过滤）
+type RequestFilter struct {
+	Host   string
+	Status int
+	Method string
+	After  int
+}
+
+// match 判断请求是否符合条件
+func (f *RequestFilter) match(theology int, h *MapHash.Request) bool {
+	if h == nil || !h.Display || theology <= f.After {
+		return false
+	}
+	if f.Host != "" && !strings.EqualFold(requestHostOf(h.URL), f.Host) {
+		return false
+	}
+	if f.Status != 0 && h.Response.StateCode != f.Status {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(h.Method, f.Method) {
+		return false
+	}
+	return true
+}
+
 // toolRequestList 获取请求列表
-func toolRequestList(limit, offset int) (interface{}, error) {
-	var requests []RequestInfo
+func toolRequestList(limit, offset int, filter RequestFilter) (interface{}, error) {
+	requests := make([]RequestInfo, 0)
 	var keys []int
 
-	// 收集所有请求ID
-	HashMap.Search(func(theology int, _ int, _ *MapHash.Request) {
-		keys = append(keys, theology)
+	// 收集符合条件的请求ID
+	HashMap.SearchMeta(func(theology int, h *MapHash.Request) {
+		if filter.match(theology, h) {
+			keys = append(keys, theology)
+		}
 	})
 
 	// 排序
@@ -774,6 +1148,7 @@ func toolRequestList(limit, offset int) (interface{}, error) {
 	}
 
 	// 应用分页
+	total := len(keys)
 	start := offset
 	end := offset + limit
 	if start > len(keys) {
@@ -801,16 +1176,32 @@ func toolRequestList(limit, offset int) (interface{}, error) {
 				Notes:      h.Notes,
 			})
 		}
+		HashMap.Release(h)
 	}
 
-	return map[string]interface{}{
-		"total":    len(keys),
-		"offset":   offset,
-		"limit":    limit,
-		"requests": requests,
+	return RequestListResult{
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+		Requests: requests,
 	}, nil
 }
 
+// RequestListResult 请求列表
+type RequestListResult struct {
+	Total    int           `json:"total"`
+	Offset   int           `json:"offset"`
+	Limit    int           `json:"limit"`
+	Requests []RequestInfo `json:"requests"`
+}
+
+// RequestSearchResult 搜索结果
+type RequestSearchResult struct {
+	Success  bool  `json:"success"`
+	Theology []int `json:"theology"`
+	Total    int   `json:"total"`
+}
+
 // RequestDetail 请求详细信息
 type RequestDetail struct {
 	Theology int    `json:"theology"`
@@ -829,32 +1220,79 @@ type RequestDetail struct {
 		BodyB64    string              `json:"bodyBase64"`
 		Error      bool                `json:"error"`
 	} `json:"response"`
-	ClientIP string `json:"clientIP"`
-	PID      string `json:"pid"`
-	SendTime string `json:"sendTime"`
-	RecTime  string `json:"recTime"`
-	Way      string `json:"way"`
-	Notes    string `json:"notes"`
+	ClientIP      string         `json:"clientIP"`
+	PID           string         `json:"pid"`
+	SendTime      string         `json:"sendTime"`
+	RecTime       string         `json:"recTime"`
+	Way           string         `json:"way"`
+	Notes         string         `json:"notes"`
+	Pinned        bool           `json:"pinned"`
+	BodiesEvicted bool           `json:"bodiesEvicted,omitempty"` //数据体已因抓包存储上限被丢弃
+	Timing        *RequestTiming `json:"timing,omitempty"`
+	ReplayOf      int            `json:"replayOf,omitempty"` //由哪个会话重放产生
+}
+
+// RequestTiming 会话计时，各时间点为相对 start 的毫秒数，未记录的时间点（如 HTTP 会话的连接与首字节）不输出
+type RequestTiming struct {
+	Start     string                `json:"start"` //RFC3339 格式的开始时间
+	Connect   *float64              `json:"connect,omitempty"`
+	TLS       *float64              `json:"tls,omitempty"`
+	Sent      *float64              `json:"sent,omitempty"`
+	FirstByte *float64              `json:"firstByte,omitempty"`
+	LastByte  *float64              `json:"lastByte,omitempty"`
+	Phases    []MapHash.TimingPhase `json:"phases"` //瀑布图各阶段
+}
+
+// requestTimingOf 把会话计时转换为毫秒
+func requestTimingOf(t *MapHash.Timing) *RequestTiming {
+	if t == nil {
+		return nil
+	}
+	ms := func(us int64) *float64 {
+		if us < 0 {
+			return nil
+		}
+		v := float64(us) / 1000
+		return &v
+	}
+	phases := t.Phases()
+	if phases == nil {
+		phases = []MapHash.TimingPhase{}
+	}
+	return &RequestTiming{
+		Start:     t.Start.Format(time.RFC3339Nano),
+		Connect:   ms(t.Connect),
+		TLS:       ms(t.TLS),
+		Sent:      ms(t.Sent),
+		FirstByte: ms(t.FirstByte),
+		LastByte:  ms(t.LastByte),
+		Phases:    phases,
+	}
 }
 
 // toolRequestGet 获取请求详情
 func toolRequestGet(theology int) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
 
 	detail := RequestDetail{
-		Theology: theology,
-		Method:   h.Method,
-		URL:      h.URL,
-		Proto:    h.Proto,
-		ClientIP: h.ClientIP,
-		PID:      h.PID,
-		SendTime: h.SendTime,
-		RecTime:  h.RecTime,
-		Way:      h.Way,
-		Notes:    h.Notes,
+		Theology:      theology,
+		Method:        h.Method,
+		URL:           h.URL,
+		Proto:         h.Proto,
+		ClientIP:      h.ClientIP,
+		PID:           h.PID,
+		SendTime:      h.SendTime,
+		RecTime:       h.RecTime,
+		Way:           h.Way,
+		Notes:         h.Notes,
+		Pinned:        h.Pinned,
+		BodiesEvicted: h.BodiesEvicted,
+		Timing:        requestTimingOf(h.Timing),
+		ReplayOf:      h.ReplayOf,
 	}
 
 	// 请求信息
@@ -872,9 +1310,61 @@ func toolRequestGet(theology int) (interface{}, error) {
 	return detail, nil
 }
 
+// toolRequestSearch 全部会话搜索（与界面的查找共用搜索逻辑但不修改界面的搜索标记，CancelSearch 也会取消该搜索）
+func toolRequestSearch(ctx context.Context, value, findType, searchRange string, caseSensitive bool) (interface{}, error) {
+	if findType == "" {
+		findType = "UTF8"
+	}
+	if searchRange == "" {
+		searchRange = "全部"
+	}
+	options := ""
+	if !caseSensitive {
+		options = "不区分大小写"
+	}
+	var findErr string
+	// 搜索回调在持有抓包锁时执行，进度通过队列在锁外发送
+	report, stopProgress := asyncProgress(ctx, 100)
+	obj := &FindValue{
+		Value:   value,
+		Options: options,
+		Type:    findType,
+		Range:   searchRange,
+		Private: true,
+		OnProgress: func(percentage int) {
+			report(float64(percentage))
+		},
+		OnError: func(msg string) {
+			findErr = msg
+		},
+	}
+	res, _ := obj.FindContext(ctx).(*SearchResult)
+	stopProgress()
+	if findErr != "" {
+		return nil, errors.New(findErr)
+	}
+	if obj.Cancelled {
+		return nil, errors.New("搜索已取消")
+	}
+	if res == nil {
+		return nil, fmt.Errorf("不支持的搜索类型: %s", findType)
+	}
+	ids := res.SearchResult
+	if ids == nil {
+		ids = []int{}
+	}
+	sort.Ints(ids)
+	return RequestSearchResult{
+		Success:  true,
+		Theology: ids,
+		Total:    len(ids),
+	}, nil
+}
+
 // toolRequestModifyHeader 修改请求头
 func toolRequestModifyHeader(theology int, key, value string) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -907,6 +1397,7 @@ func toolRequestModifyHeader(theology int, key, value string) (interface{}, erro
 // toolRequestModifyBody 修改请求体
 func toolRequestModifyBody(theology int, body string) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -929,6 +1420,7 @@ func toolRequestModifyBody(theology int, body string) (interface{}, error) {
 // toolResponseModifyHeader 修改响应头
 func toolResponseModifyHeader(theology int, key, value string) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -961,6 +1453,7 @@ func toolResponseModifyHeader(theology int, key, value string) (interface{}, err
 // toolResponseModifyBody 修改响应体
 func toolResponseModifyBody(theology int, body string) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -983,6 +1476,7 @@ func toolResponseModifyBody(theology int, body string) (interface{}, error) {
 // toolRequestBlock 阻断请求
 func toolRequestBlock(theology int) (interface{}, error) {
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("请求 %d 不存在", theology)
 	}
@@ -1008,6 +1502,35 @@ func toolRequestReleaseAll() (interface{}, error) {
 	}, nil
 }
 
+// toolRequestPin 固定或取消固定请求
+func toolRequestPin(theology int, pinned bool) (interface{}, error) {
+	if !HashMap.SetPinned(theology, pinned) {
+		return nil, fmt.Errorf("请求 %d 不存在", theology)
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"theology": theology,
+		"pinned":   pinned,
+	}, nil
+}
+
+// toolSessionReplay 重放 TCP、UDP 或 Websocket 会话
+func toolSessionReplay(ctx context.Context, theology int, keepTiming bool, waitMs int) (interface{}, error) {
+	if app == nil || app.App == nil {
+		return nil, errors.New("SunnyNet实例未初始化")
+	}
+	if GlobalConfig.Authentication {
+		return nil, errors.New("身份验证模式下无法重放，请先关闭身份验证模式")
+	}
+	if waitMs < 0 || waitMs > 60000 {
+		return nil, errors.New("参数 waitMs 必须在 0 到 60000 之间")
+	}
+	return HashMap.Replay(ctx, theology, app.App.Port(), MapHash.ReplayOptions{
+		KeepTiming: keepTiming,
+		Wait:       time.Duration(waitMs) * time.Millisecond,
+	})
+}
+
 // ============ 证书管理类工具实现 ============
 
 // toolCertInstall 安装默认证书
@@ -1119,6 +1642,66 @@ func toolConfigGet() (interface{}, error) {
 	}, nil
 }
 
+// toolCaptureSetLimits 设置抓包存储上限，未提供的参数保持当前值
+func toolCaptureSetLimits(args map[string]interface{}) (interface{}, error) {
+	l := GlobalConfig.CaptureLimits
+	if v, ok := args["max_flows"].(float64); ok {
+		l.MaxFlows = int(v)
+	}
+	if v, ok := args["max_bytes"].(float64); ok {
+		l.MaxBytes = int64(v)
+	}
+	if v, ok := args["max_age"].(float64); ok {
+		l.MaxAge = int(v)
+	}
+	if v, ok := args["policy"].(string); ok && v != "" {
+		l.Policy = v
+	}
+	if err := validateCaptureLimits(l); err != nil {
+		return nil, err
+	}
+	if err := GlobalConfig.SaveCaptureLimits(l); err != nil {
+		return nil, fmt.Errorf("保存配置失败: %v", err)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"limits":  l,
+	}, nil
+}
+
+// validateCaptureLimits 检查抓包存储上限
+func validateCaptureLimits(l MapHash.Limits) error {
+	if l.MaxFlows < 0 || l.MaxBytes < 0 || l.MaxAge < 0 {
+		return errors.New("存储上限不能为负数")
+	}
+	switch l.Policy {
+	case MapHash.EvictOldest, MapHash.EvictUnpinned, MapHash.EvictBodies:
+		return nil
+	}
+	return fmt.Errorf("未知的淘汰策略: %s", l.Policy)
+}
+
+// toolProfileApply 应用配置文件，path 与 profile 二选一
+func toolProfileApply(path string, inline interface{}) (interface{}, error) {
+	var p *Profile
+	var err error
+	switch {
+	case path != "" && inline != nil:
+		return nil, errors.New("参数 path 与 profile 只能提供一个")
+	case path != "":
+		p, err = LoadProfile(path)
+	case inline != nil:
+		bs, _ := json.Marshal(inline)
+		p, err = ParseProfile(bs)
+	default:
+		return nil, errors.New("必须提供参数 path 或 profile")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ApplyProfile(p)
+}
+
 // ============ 解密分析类工具实现 ============
 
 // toolDecryptPacket 解密单个数据包
@@ -1136,23 +1719,34 @@ func toolDecryptPacket(dataHex string) (interface{}, error) {
 	// 解析数据包
 	result, err := cryptoAnalyzer.ParsePacket(data)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-			"rawHex":  dataHex,
+		return DecryptPacketResult{
+			Success: false,
+			Error:   err.Error(),
+			RawHex:  dataHex,
 		}, nil
 	}
 
-	return map[string]interface{}{
-		"success":      true,
-		"header":       result.Header,
-		"rawHex":       result.RawHex,
-		"payloadHex":   result.PayloadHex,
-		"decryptedHex": result.DecryptedHex,
-		"protobufTree": result.ProtobufTree,
+	return DecryptPacketResult{
+		Success:      true,
+		Header:       &result.Header,
+		RawHex:       result.RawHex,
+		PayloadHex:   result.PayloadHex,
+		DecryptedHex: result.DecryptedHex,
+		ProtobufTree: result.ProtobufTree,
 	}, nil
 }
 
+// DecryptPacketResult 单个数据包的解密结果（DecryptedPacket 的MCP输出形式）
+type DecryptPacketResult struct {
+	Success      bool          `json:"success"`
+	Error        string        `json:"error,omitempty"`
+	Header       *PacketHeader `json:"header,omitempty"`
+	RawHex       string        `json:"rawHex"`
+	PayloadHex   string        `json:"payloadHex,omitempty"`
+	DecryptedHex string        `json:"decryptedHex,omitempty"`
+	ProtobufTree string        `json:"protobufTree,omitempty"`
+}
+
 // toolParseProtobuf 解析Protobuf数据
 func toolParseProtobuf(dataHex string) (interface{}, error) {
 	if cryptoAnalyzer == nil {
@@ -1277,13 +1871,14 @@ func toolCryptoConfigList() (interface{}, error) {
 }
 
 // toolDecryptTcpFlow 解密TCP数据流
-func toolDecryptTcpFlow(theology int) (interface{}, error) {
+func toolDecryptTcpFlow(ctx context.Context, theology int) (interface{}, error) {
 	if cryptoAnalyzer == nil {
 		return nil, errors.New("加密分析器未初始化")
 	}
 
 	// 获取TCP请求
 	h := HashMap.GetRequest(theology)
+	defer HashMap.Release(h)
 	if h == nil {
 		return nil, fmt.Errorf("TCP连接 %d 不存在", theology)
 	}
@@ -1296,53 +1891,83 @@ func toolDecryptTcpFlow(theology int) (interface{}, error) {
 	// 获取Socket数据
 	socketData := h.SocketData
 	if socketData == nil || len(socketData) == 0 {
-		return map[string]interface{}{
-			"success":  true,
-			"theology": theology,
-			"packets":  []interface{}{},
-			"total":    0,
-			"message":  "没有捕获到数据包",
+		return DecryptTcpFlowResult{
+			Success:  true,
+			Theology: theology,
+			Packets:  []TcpFlowPacket{},
+			Total:    0,
+			Message:  "没有捕获到数据包",
 		}, nil
 	}
 
 	// 解密每个数据包
-	packets := make([]map[string]interface{}, 0, len(socketData))
+	packets := make([]TcpFlowPacket, 0, len(socketData))
 	for i, sd := range socketData {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.New("解密已取消")
+		}
+		ReportProgress(ctx, float64(i+1), float64(len(socketData)), "")
 		if sd == nil || sd.Body == nil || len(sd.Body) == 0 {
 			continue
 		}
 
-		packet := map[string]interface{}{
-			"index":     i,
-			"direction": sd.Info.Ico,
-			"time":      sd.Info.Time,
-			"length":    sd.Info.Length,
-			"rawHex":    bytesToHexString(sd.Body),
+		packet := TcpFlowPacket{
+			Index:  i,
+			RawHex: bytesToHexString(sd.Body),
+		}
+		if sd.Info != nil {
+			packet.Direction = sd.Info.Ico
+			packet.Time = sd.Info.Time
+			packet.Length = sd.Info.Length
 		}
 
 		// 尝试解密
 		decrypted, err := cryptoAnalyzer.ParsePacket(sd.Body)
 		if err != nil {
-			packet["decryptError"] = err.Error()
+			packet.DecryptError = err.Error()
 		} else {
-			packet["header"] = decrypted.Header
-			packet["decryptedHex"] = decrypted.DecryptedHex
-			packet["protobufTree"] = decrypted.ProtobufTree
+			packet.Header = &decrypted.Header
+			packet.DecryptedHex = decrypted.DecryptedHex
+			packet.ProtobufTree = decrypted.ProtobufTree
 		}
 
 		packets = append(packets, packet)
 	}
 
-	return map[string]interface{}{
-		"success":  true,
-		"theology": theology,
-		"url":      h.URL,
-		"way":      h.Way,
-		"packets":  packets,
-		"total":    len(packets),
+	return DecryptTcpFlowResult{
+		Success:  true,
+		Theology: theology,
+		URL:      h.URL,
+		Way:      h.Way,
+		Packets:  packets,
+		Total:    len(packets),
 	}, nil
 }
 
+// DecryptTcpFlowResult TCP数据流解密结果
+type DecryptTcpFlowResult struct {
+	Success  bool            `json:"success"`
+	Theology int             `json:"theology"`
+	URL      string          `json:"url,omitempty"`
+	Way      string          `json:"way,omitempty"`
+	Packets  []TcpFlowPacket `json:"packets"`
+	Total    int             `json:"total"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// TcpFlowPacket TCP数据流中单个数据包的解密结果
+type TcpFlowPacket struct {
+	Index        int           `json:"index"`
+	Direction    string        `json:"direction"`
+	Time         string        `json:"time"`
+	Length       int           `json:"length"`
+	RawHex       string        `json:"rawHex"`
+	Header       *PacketHeader `json:"header,omitempty"`
+	DecryptedHex string        `json:"decryptedHex,omitempty"`
+	ProtobufTree string        `json:"protobufTree,omitempty"`
+	DecryptError string        `json:"decryptError,omitempty"`
+}
+
 // ============ 辅助函数 ============
 
 // hexStringToBytes 将十六进制字符串转换为字节数组
@@ -1543,6 +2168,119 @@ func reloadReplaceRules() {
 
 // reloadReplaceRulesLocked 重新加载替换规则（调用前需已获取锁）
 func reloadReplaceRulesLocked() {
-	// 重新构建内部规则列表
-	// 这里简化处理，实际应用中可能需要调用 ReplaceRulesEvent
+	_ReplaceRules, _ = buildReplaceRules(GlobalConfig.ReplaceRules)
+}
+
+// ============ 导入导出类工具实现 ============
+
+// theologyList 把数组参数转换为会话ID列表
+func theologyList(v interface{}) []int {
+	var list []int
+	if arr, ok := v.([]interface{}); ok {
+		for _, item := range arr {
+			if t, ok := item.(float64); ok {
+				list = append(list, int(t))
+			}
+		}
+	}
+	return list
+}
+
+// toolHarExport 导出HAR，未指定路径时直接返回HAR内容
+func toolHarExport(ctx context.Context, path string, theologies []int) (interface{}, error) {
+	all := len(theologies) == 0
+	// 转换在持有抓包锁时进行，进度通过队列在锁外发送
+	report, stopProgress := asyncProgress(ctx, 100)
+	onProgress := func(done, total int) { report(float64(done) * 100 / float64(total)) }
+	if path == "" {
+		h, n, err := HashMap.ExportHARContext(ctx, all, theologies, strconv.Itoa(Version), onProgress)
+		stopProgress()
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, errors.New("没有可导出的HTTP或Websocket会话")
+		}
+		return map[string]interface{}{
+			"success": true,
+			"count":   n,
+			"har":     h,
+		}, nil
+	}
+	n, err := exportHARFile(ctx, path, all, theologies, onProgress)
+	stopProgress()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success": true,
+		"count":   n,
+		"path":    path,
+	}, nil
+}
+
+// toolHarImport 从文件或直接提供的内容导入HAR
+func toolHarImport(path string, inline interface{}) (interface{}, error) {
+	var keys []int
+	var err error
+	switch {
+	case path != "" && inline != nil:
+		return nil, errors.New("参数 path 与 har 只能提供一个")
+	case path != "":
+		keys, err = importHARFile(path)
+	case inline != nil:
+		bs, e := json.Marshal(inline)
+		if e != nil {
+			return nil, e
+		}
+		keys, err = importHARData(bs)
+	default:
+		return nil, errors.New("需要提供参数 path 或 har")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"count":      len(keys),
+		"theologies": keys,
+	}, nil
+}
+
+// toolPcapExport 导出PCAP-NG，未指定路径时以base64返回文件内容
+func toolPcapExport(ctx context.Context, path string, theologies []int, decrypted bool) (interface{}, error) {
+	all := len(theologies) == 0
+	// 构造数据包在持有抓包锁时进行，进度通过队列在锁外发送
+	report, stopProgress := asyncProgress(ctx, 100)
+	onProgress := func(done, total int) { report(float64(done) * 100 / float64(total)) }
+	if path == "" {
+		var buf bytes.Buffer
+		res, err := HashMap.ExportPcapNGContext(ctx, &buf, all, theologies, decrypted, onProgress)
+		stopProgress()
+		if err != nil {
+			return nil, err
+		}
+		if res.Sessions < 1 {
+			return nil, errors.New("没有可导出的TCP、UDP或Websocket会话")
+		}
+		return map[string]interface{}{
+			"success":  true,
+			"sessions": res.Sessions,
+			"packets":  res.Packets,
+			"skipped":  res.Skipped,
+			"pcapng":   base64.StdEncoding.EncodeToString(buf.Bytes()),
+		}, nil
+	}
+	res, err := exportPcapFile(ctx, path, all, theologies, decrypted, onProgress)
+	stopProgress()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"sessions": res.Sessions,
+		"packets":  res.Packets,
+		"skipped":  res.Skipped,
+		"path":     path,
+	}, nil
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// mcpStdioSessionID 标准输入输出传输只有一个客户端，使用固定的会话ID
+const mcpStdioSessionID = "stdio"
+
+// mcpStdioMaxConcurrent 标准输入输出传输同时处理的请求数上限
+const mcpStdioMaxConcurrent = 16
+
+// ServeStdio 通过标准输入输出提供MCP服务（每行一条JSON-RPC消息，可以是批量），直到输入结束或 ctx 取消（正常退出，返回 nil）
+// 请求并发处理，以便工具执行期间仍能收到取消通知与 elicitation 响应
+func (m *MCPServer) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	m.mu.Lock()
+	if m.running {
+		m.mu.Unlock()
+		return fmt.Errorf("MCP服务器已在运行")
+	}
+	m.stopNotify = make(chan struct{})
+	go m.resourceNotifyLoop(m.stopNotify)
+	m.running = true
+	m.mu.Unlock()
+	defer m.Stop()
+
+	var writeMu sync.Mutex
+	write := func(message []byte) bool {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := out.Write(append(message, '\n'))
+		return err == nil
+	}
+
+	// 广播类通知（日志、列表变化、资源更新）经推送通道写出
+	session := m.createSession(mcpStdioSessionID)
+	ch, _ := m.registerClient(session.ID)
+	go func() {
+		for message := range ch {
+			write(message)
+		}
+	}()
+
+	send := clientSender(write)
+	base := withNotifySink(ctx, func(message []byte) { send(message) })
+	base = withClientSender(base, send)
+	base = withSession(base, session)
+
+	writeResponse := func(payload interface{}) {
+		data, _ := json.Marshal(payload)
+		write(data)
+	}
+
+	// 在单独的 goroutine 中读取输入，ctx 取消时不必等到下一行输入才退出
+	lines := make(chan []byte)
+	var readErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), mcpMaxRequestBody)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			select {
+			case lines <- append([]byte(nil), line...):
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = scanner.Err()
+	}()
+
+	handle := func(messages []parsedMessage, batch bool) {
+		responses := make([]JSONRPCResponse, 0, len(messages))
+		for _, msg := range messages {
+			if response := m.handleMessage(base, session, msg); response != nil {
+				responses = append(responses, *response)
+			}
+		}
+		if len(responses) == 0 {
+			return
+		}
+		if batch {
+			writeResponse(responses)
+		} else {
+			writeResponse(responses[0])
+		}
+	}
+
+	// 同时处理的请求数有上限；通知与客户端响应（取消、elicitation 结果）直接处理，不占用名额，
+	// 以免名额全部被等待客户端响应的工具占满
+	sem := make(chan struct{}, mcpStdioMaxConcurrent)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				return readErr
+			}
+			line = l
+		}
+		messages, batch, err := parseJSONRPCBody(line)
+		if err != nil {
+			writeResponse(JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32700, Message: "解析错误", Data: err.Error()}})
+			continue
+		}
+		if batch && len(messages) == 0 {
+			writeResponse(JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32600, Message: "无效的请求", Data: "批量请求不能为空"}})
+			continue
+		}
+		session.touch()
+		if !hasRequest(messages) {
+			handle(messages, batch)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			handle(messages, batch)
+		}()
+	}
+}
+
+// hasRequest 是否包含需要响应的请求（无效的消息也需要回复错误）
+func hasRequest(messages []parsedMessage) bool {
+	for _, msg := range messages {
+		if msg.Invalid != nil || (!msg.Notification && !msg.IsResponse) {
+			return true
+		}
+	}
+	return false
+}
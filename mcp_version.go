@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Streamable HTTP 在初始化之后的请求中携带协议版本的请求头（2025-06-18起）
+const mcpProtocolVersionHeader = "MCP-Protocol-Version"
+
+// 各功能最早出现的协议版本
+const (
+	featureToolAnnotations   = "2025-03-26" // tools/list 中的 annotations
+	featureCompletions       = "2025-03-26" // completions 能力
+	featureProgressMessage   = "2025-03-26" // notifications/progress 中的 message
+	featureStructuredContent = "2025-06-18" // outputSchema 与 structuredContent
+	featureElicitation       = "2025-06-18" // elicitation/create
+)
+
+// negotiateProtocolVersion 协商协议版本（见 negotiateVersion），版本号格式不正确时返回规范要求的错误
+func negotiateProtocolVersion(requested string) (string, *JSONRPCError) {
+	if version, ok := negotiateVersion(requested); ok {
+		return version, nil
+	}
+	return "", &JSONRPCError{
+		Code:    -32602,
+		Message: "Unsupported protocol version",
+		Data: map[string]interface{}{
+			"supported": mcpSupportedVersions,
+			"requested": requested,
+		},
+	}
+}
+
+// versionSupports 判断协议版本是否包含某个功能（版本号为日期，可直接按字符串比较）
+func versionSupports(version, feature string) bool {
+	if version == "" {
+		version = mcpLatestVersion
+	}
+	return version >= feature
+}
+
+// withProtocolVersion 记录无会话请求在请求头中声明的协议版本
+func withProtocolVersion(ctx context.Context, version string) context.Context {
+	if version == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, protocolVersionKey, version)
+}
+
+// protocolVersionOf 当前请求使用的协议版本：优先取会话协商的版本，其次是请求头，默认最新版本
+func protocolVersionOf(ctx context.Context) string {
+	if session := sessionFromContext(ctx); session != nil {
+		if v := session.Version(); v != "" {
+			return v
+		}
+	}
+	if v, _ := ctx.Value(protocolVersionKey).(string); v != "" {
+		return v
+	}
+	return mcpLatestVersion
+}
+
+// checkProtocolVersionHeader 校验请求头中的协议版本，不支持时按规范返回400
+func (m *MCPServer) checkProtocolVersionHeader(w http.ResponseWriter, r *http.Request) (string, bool) {
+	version := r.Header.Get(mcpProtocolVersionHeader)
+	if version == "" || isSupportedVersion(version) {
+		return version, true
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    -32600,
+			Message: "Unsupported protocol version",
+			Data: map[string]interface{}{
+				"supported": mcpSupportedVersions,
+				"requested": version,
+			},
+		},
+	})
+	return "", false
+}
+
+// toolsForVersion 按协议版本去掉客户端不认识的字段
+func toolsForVersion(tools []MCPTool, version string) []MCPTool {
+	annotations := versionSupports(version, featureToolAnnotations)
+	outputSchema := versionSupports(version, featureStructuredContent)
+	if annotations && outputSchema {
+		return tools
+	}
+	list := make([]MCPTool, len(tools))
+	for i, t := range tools {
+		if !annotations {
+			t.Annotations = nil
+		}
+		if !outputSchema {
+			t.OutputSchema = nil
+		}
+		list[i] = t
+	}
+	return list
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"changeme/MapHash"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+)
+
+// exportPcapFile 把选中的 TCP、UDP 与 Websocket 会话导出为 PCAP-NG 文件
+// decrypted 为 true 时 TLS 会话也写入捕获到的明文
+func exportPcapFile(ctx context.Context, Path string, All bool, TheologyArray []int, decrypted bool, onProgress func(done, total int)) (*MapHash.PcapResult, error) {
+	if Path == "" {
+		return nil, errors.New("文件路径不能为空")
+	}
+	SetStatusText("正在导出PCAP-NG文件")
+	f, err := os.Create(Path)
+	if err != nil {
+		SetStatusText("保存PCAP-NG文件失败：" + err.Error())
+		return nil, err
+	}
+	res, err := HashMap.ExportPcapNGContext(ctx, f, All, TheologyArray, decrypted, onProgress)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil && res.Sessions < 1 {
+		err = errors.New("没有可导出的TCP、UDP或Websocket会话")
+	}
+	if err != nil {
+		_ = os.Remove(Path)
+		SetStatusText("导出PCAP-NG文件失败：" + err.Error())
+		return nil, err
+	}
+	SetStatusText("已导出 " + strconv.Itoa(res.Sessions) + " 个会话（" + strconv.Itoa(res.Packets) + " 个数据包）到PCAP-NG文件：" + Path)
+	return res, nil
+}
@@ -4,12 +4,12 @@ import (
 	"changeme/CommAnd"
 	"changeme/MapHash"
 	"changeme/Resource"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/qtgolang/SunnyNet/Api"
-	"github.com/qtgolang/SunnyNet/SunnyNet"
 	"github.com/qtgolang/SunnyNet/public"
 	"github.com/qtgolang/SunnyNet/src/protobuf/JSON"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -17,7 +17,6 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -99,95 +98,72 @@ func event(command string, args *JSON.SyJson) any {
 			TheologyArray = append(TheologyArray, getInt(args.GetData("Data["+strconv.Itoa(i)+"]")))
 		}
 		return saveToFile(Path, ALL, TheologyArray)
+	case "追加保存文件":
+		var TheologyArray []int
+		Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
+		if Path == "" {
+			return false
+		}
+		ALL := args.GetData("ALL") == "true"
+		for i := 0; i < args.GetNum("Data"); i++ {
+			TheologyArray = append(TheologyArray, getInt(args.GetData("Data["+strconv.Itoa(i)+"]")))
+		}
+		return HashMap.AppendToFile(Path, ALL, TheologyArray, SetStatusText)
+	case "导出HAR文件":
+		var TheologyArray []int
+		Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
+		if Path == "" {
+			return false
+		}
+		if !strings.HasSuffix(strings.ToLower(Path), ".har") {
+			Path += ".har"
+		}
+		ALL := args.GetData("ALL") == "true"
+		for i := 0; i < args.GetNum("Data"); i++ {
+			TheologyArray = append(TheologyArray, getInt(args.GetData("Data["+strconv.Itoa(i)+"]")))
+		}
+		_, e := exportHARFile(context.Background(), Path, ALL, TheologyArray, nil)
+		return e == nil
+	case "导出PCAPNG文件":
+		var TheologyArray []int
+		Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
+		if Path == "" {
+			return false
+		}
+		if !strings.HasSuffix(strings.ToLower(Path), ".pcapng") {
+			Path += ".pcapng"
+		}
+		ALL := args.GetData("ALL") == "true"
+		Decrypted := args.GetData("Decrypted") == "true"
+		for i := 0; i < args.GetNum("Data"); i++ {
+			TheologyArray = append(TheologyArray, getInt(args.GetData("Data["+strconv.Itoa(i)+"]")))
+		}
+		_, e := exportPcapFile(context.Background(), Path, ALL, TheologyArray, Decrypted, nil)
+		return e == nil
+	case "导入HAR文件":
+		_, e := importHARFile(strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\"))
+		return e == nil
 	case "打开记录文件":
-		var OpenData []*MapHash.Request
-		{
-			Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
-			if Path == "" {
-				return false
-			}
-			SetStatusText("正在读取文件:" + Path)
-			bs, e := os.ReadFile(Path)
-			if e != nil {
-				SetStatusText("读取文件失败:" + e.Error())
-				return false
-			}
-			DATA := MapHash.BrUnCompress(bs)
-			e = json.Unmarshal(DATA, &OpenData)
-			if e != nil {
-				SetStatusText("解密文件失败:" + e.Error())
-				return false
-			}
+		Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
+		if Path == "" {
+			return false
+		}
+		if strings.HasSuffix(strings.ToLower(Path), ".har") {
+			_, e := importHARFile(Path)
+			return e == nil
+		}
+		SetStatusText("正在读取文件:" + Path)
+		keys, list, e := HashMap.LoadFile(Path)
+		if e != nil {
+			SetStatusText("读取文件失败:" + e.Error())
+			return false
 		}
-		max := strconv.Itoa(len(OpenData))
-		SetStatusText("正在导入记录:0/" + max)
 		var OpenFileListInfo []ListInfo
-		for index, v := range OpenData {
-			if v != nil {
-				SetStatusText("正在导入记录:" + strconv.Itoa(index) + "/" + max)
-				Theology := HashMap.CreateUniqueID()
-				HashMap.SetRequest(Theology, v)
-				State := strconv.Itoa(v.Response.StateCode)
-				if !strings.Contains(strings.ToUpper(v.URL), "HTTP") {
-					State = "已断开"
-				}
-				ResponseType := ""
-				Method := v.Method
-				Ico := "websocket_close"
-				ResponseLen := ""
-				if v.Way == "Websocket" {
-					Method = "Websocket"
-					ResponseType = "Websocket"
-					ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
-				} else if v.Way == "UDP" {
-					Method = "UDP"
-					ResponseType = "UDP"
-					ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
-				} else if strings.Contains(strings.ToUpper(Method), "TCP") {
-					ResponseLen = strconv.Itoa(v.SendNum) + "/" + strconv.Itoa(v.RecNum)
-					ResponseType = Method
-				} else {
-					if v.Response.Header != nil {
-						_a := v.Response.Header["Content-Type"]
-						if len(_a) > 0 {
-							ResponseType = _a[0]
-						} else {
-							_a = v.Response.Header["content-type"]
-							if len(_a) > 0 {
-								ResponseType = _a[0]
-							}
-						}
-						if ResponseType != "" {
-							array := strings.Split(ResponseType+";", ";")
-							if len(array) > 0 {
-								ResponseType = array[0]
-							}
-						}
-					}
-					Ico = UpdateIco(v, ResponseType)
-					ResponseLen = strconv.Itoa(len(v.Response.Body))
-				}
-				tmp := ListInfo{
-					MessageId: -1,
-					Theology:  Theology,
-					State:     State,
-					URL:       v.URL,
-					ClientIP:  v.ClientIP,
-					PID:       v.PID,
-					Method:    Method,
-					Ico:       Ico,
-					Len:       ResponseLen,
-					Type:      ResponseType,
-					SendTime:  v.SendTime,
-					RecTime:   v.RecTime,
-					Notes:     v.Notes,
-				}
-				tmp.Color.TagColor = v.Color.TagColor
-				tmp.Color.Search = v.Color.Search
-				OpenFileListInfo = append(OpenFileListInfo, tmp)
-			}
+		for i, Theology := range keys {
+			OpenFileListInfo = append(OpenFileListInfo, RequestListInfo(Theology, list[i]))
+			NotifyFlowAdded(Theology)
 		}
-		SetStatusText("导入完成: " + max + " 条记录")
+		SetStatusText("导入完成: " + strconv.Itoa(len(keys)) + " 条记录")
 		if len(OpenFileListInfo) > 0 {
 			CallJs("插入列表", OpenFileListInfo)
 		}
@@ -196,6 +172,7 @@ func event(command string, args *JSON.SyJson) any {
 		Theology := getInt(args.GetData("Theology"))
 		Data := args.GetData("Data")
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h != nil {
 			h.Notes = Data
 			return true
@@ -222,6 +199,7 @@ func event(command string, args *JSON.SyJson) any {
 				if h != nil {
 					h.Color.TagColor = ""
 				}
+				HashMap.Release(h)
 			}
 			return true
 		}
@@ -232,6 +210,7 @@ func event(command string, args *JSON.SyJson) any {
 			if h != nil {
 				h.Color.TagColor = TagColor
 			}
+			HashMap.Release(h)
 		}
 		return false
 	case "重发请求":
@@ -337,6 +316,8 @@ func event(command string, args *JSON.SyJson) any {
 		_ = GlobalConfig.saveToFile()
 		_TmpLock.Unlock()
 		app.App.CancelIEProxy()
+		closeAutosave()
+		HashMap.CloseStore()
 		os.Exit(0)
 		return nil
 	case "获取内网IP":
@@ -360,138 +341,11 @@ func event(command string, args *JSON.SyJson) any {
 			return nil
 		}
 		go lanZouUpdate()
-		app.App = SunnyNet.NewSunny()
-		app.App.DisableTCP(DisableTCP)
-		app.App.SetPort(GlobalConfig.Port)
-		//app.App.MustTcp(true)
-		app.App.SetGoCallback(HttpCallback, TcpCallback, WSCallback, UdpCallback)
-		err := app.App.Start().Error
-		errStr := ""
-		if err != nil {
-			errStr = err.Error()
-		}
-		app.App.Socket5VerifyUser(GlobalConfig.Authentication)
-		for k, v := range GlobalConfig.AuthenticationUserInfo {
-			app.App.Socket5AddUser(k, v)
-			_TmpLock.Lock()
-			SocketAuthentication = append(SocketAuthentication, k)
-			_TmpLock.Unlock()
-		}
-		//强制走TCP
-		{
-			app.App.MustTcp(GlobalConfig.MustTcp.Open)
-			_ = app.App.SetMustTcpRegexp(GlobalConfig.MustTcp.Rules, true)
-		}
-		//证书选择
-		{
-			if !GlobalConfig.Cert.Default {
-				id := Api.CreateCertificate()
-				defer Api.RemoveCertificate(id)
-				CaFilePath := GlobalConfig.Cert.CaPath
-				KeyFilePath := GlobalConfig.Cert.KeyPath
-				if !Api.LoadX509KeyPair(id, CaFilePath, KeyFilePath) {
-					bs1, e := os.ReadFile(CaFilePath)
-					if e != nil {
-						GlobalConfig.Cert.Default = true
-						_ = GlobalConfig.saveToFile()
-					} else {
-						bs2, e1 := os.ReadFile(KeyFilePath)
-						if e1 != nil {
-							GlobalConfig.Cert.Default = true
-							_ = GlobalConfig.saveToFile()
-						} else {
-							if !Api.LoadX509Certificate(id, "", string(bs1), string(bs2)) {
-								GlobalConfig.Cert.Default = true
-								_ = GlobalConfig.saveToFile()
-							} else {
-								app.App.Error = nil
-								e2 := app.App.SetCert(id).Error
-								if e2 != nil {
-									GlobalConfig.Cert.Default = true
-									_ = GlobalConfig.saveToFile()
-								}
-							}
-						}
-					}
-				} else {
-					app.App.Error = nil
-					e2 := app.App.SetCert(id).Error
-					if e2 != nil {
-						GlobalConfig.Cert.Default = true
-						_ = GlobalConfig.saveToFile()
-					}
-				}
-			}
-		}
-		//替换规则
-		{
-			var _Rules []ReplaceRules
-			for i := 0; i < len(GlobalConfig.ReplaceRules); i++ {
-				v := GlobalConfig.ReplaceRules[i]
-				_Type := v.Type
-				_source := v.Src
-				_target := v.Dest
-				if _source == "" {
-					continue
-				}
-				if _Type == "Base64" {
-					bs1, e := base64.StdEncoding.DecodeString(_source)
-					if e != nil {
-						continue
-					}
-					bs2, e := base64.StdEncoding.DecodeString(_target)
-					if e != nil {
-						continue
-					}
-					_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: bs1, target: bs2})
-				} else if _Type == "HEX" {
-					bs1, e := hex.DecodeString(_source)
-					if e != nil {
-						continue
-					}
-					bs2, e := hex.DecodeString(_target)
-					if e != nil {
-						continue
-					}
-					_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: bs1, target: bs2})
-				} else if _Type == "String(UTF8)" {
-					_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: []byte(_source), target: []byte(_target)})
-				} else if _Type == "String(GBK)" {
-					_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_Bytes, source: Utf8ToGBK([]byte(_source)), target: Utf8ToGBK([]byte(_target))})
-				} else if _Type == "响应文件" {
-					bs1, e := os.ReadFile(_target)
-					if e != nil {
-						continue
-					}
-					_Rules = append(_Rules, ReplaceRules{Type: ReplaceRulesType_File, source: []byte(_source), target: bs1})
-				}
-			}
-			_ReplaceRules = _Rules
-		}
-		//Hosts规则
-		{
-			var _Rules []HostsRules
-			for i := 0; i < len(GlobalConfig.HostsRules); i++ {
-				v := GlobalConfig.HostsRules[i]
-				_source := v.Src
-				_target := v.Dest
-				regex, e := regexp.Compile(_source)
-				if _source == "" || _target == "" || e != nil {
-					continue
-				}
-				_Rules = append(_Rules, HostsRules{regex: regex, target: _target})
-			}
-			_HostsRules = _Rules
-		}
-		//请求证书规则初始化
-		{
-			var _Rules []ConfigRequestCertManager
-			for _, v := range GlobalConfig.RequestCertManager {
-				_Rules = append(_Rules, v)
-			}
-			GlobalConfig.RequestCertManager = make(map[int]ConfigRequestCertManager)
-			for i := 0; i < len(_Rules); i++ {
-				GlobalConfig.RequestCertManager[Api.CreateCertificate()] = _Rules[i]
+		go InsertStoredList()
+		errStr := startSunnyCore()
+		if errStr == "" {
+			if e := applyStartupProfile(""); e != nil {
+				CallJs("弹出错误提示", e.Error())
 			}
 		}
 		//11111111111111111
@@ -511,6 +365,7 @@ func event(command string, args *JSON.SyJson) any {
 		configLock.Lock()
 		GlobalConfig = _GlobalConfig
 		configLock.Unlock()
+		reloadConfigTools()
 		DisableTCP = GlobalConfig.DisableTCP
 		CallJs("加载配置", GlobalConfig)
 
@@ -558,6 +413,7 @@ func event(command string, args *JSON.SyJson) any {
 			return nil
 		}
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		Insert.Lock()
 		defer Insert.Unlock()
 		if len(h.SocketData) < Index {
@@ -578,6 +434,7 @@ func event(command string, args *JSON.SyJson) any {
 				return false
 			}
 			h := HashMap.GetRequest(Theology)
+			defer HashMap.Release(h)
 			if h == nil {
 				CallJs("弹出错误提示", "复制失败:请求可能失效")
 				return false
@@ -598,6 +455,7 @@ func event(command string, args *JSON.SyJson) any {
 		//复制所有HEX到剪辑版
 		case "AllHEX":
 			h := HashMap.GetRequest(Theology)
+			defer HashMap.Release(h)
 			if h == nil {
 				CallJs("弹出错误提示", "复制失败:请求可能失效")
 				return false
@@ -626,6 +484,7 @@ func event(command string, args *JSON.SyJson) any {
 		//复制所有发送数据HEX到剪辑版
 		case "sendHEX":
 			h := HashMap.GetRequest(Theology)
+			defer HashMap.Release(h)
 			if h == nil {
 				CallJs("弹出错误提示", "复制失败:请求可能失效")
 				return false
@@ -652,6 +511,7 @@ func event(command string, args *JSON.SyJson) any {
 		//复制所有接收数据HEX到剪辑版
 		case "recHEX":
 			h := HashMap.GetRequest(Theology)
+			defer HashMap.Release(h)
 			if h == nil {
 				CallJs("弹出错误提示", "复制失败:请求可能失效")
 				return false
@@ -725,6 +585,7 @@ func event(command string, args *JSON.SyJson) any {
 			}
 		}
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h == nil {
 			CallJs("弹出错误提示", "发送失败:请求可能失效")
 			return false
@@ -829,6 +690,7 @@ func event(command string, args *JSON.SyJson) any {
 	case "获取请求图片":
 		Theology := getInt(args.GetData("Theology"))
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h != nil {
 			return h.GetRequestImg()
 		}
@@ -837,6 +699,7 @@ func event(command string, args *JSON.SyJson) any {
 		Theology := getInt(args.GetData("Theology"))
 		IsRequest := getInt(args.GetData("IsRequest"))
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h != nil {
 			hm := make([]byte, 0)
 			if IsRequest == 2 {
@@ -878,6 +741,7 @@ func event(command string, args *JSON.SyJson) any {
 		Tabs := args.GetData("Tabs")
 		Coding := args.GetData("UTF8") == "true"
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h == nil {
 			CallJs("弹出错误提示", "修改数据失败:请求可能失效")
 			return false
@@ -1075,6 +939,7 @@ func event(command string, args *JSON.SyJson) any {
 					StateText: http.StatusText(h.Response.StateCode),
 					StateCode: h.Response.StateCode,
 					Break:     true,
+					Timing:    h.Timing.Phases(),
 				})
 			}()
 			switch Tabs {
@@ -1208,6 +1073,7 @@ func event(command string, args *JSON.SyJson) any {
 		Theology := getInt(args.GetData("Theology"))
 		NextBreak := getInt(args.GetData("NextBreak"))
 		h := HashMap.GetRequest(Theology)
+		defer HashMap.Release(h)
 		if h != nil {
 			h.Break = uint8(NextBreak)
 			h.Wait.Done()
@@ -1335,6 +1201,18 @@ func event(command string, args *JSON.SyJson) any {
 		}
 		CallJsAlert("安装结果：", CommAnd.InstallCert(bs))
 		return ""
+	case "应用配置文件":
+		Path := strings.ReplaceAll(args.GetData("Path"), "\\\\", "\\")
+		p, e := LoadProfile(Path)
+		if e == nil {
+			_, e = ApplyProfile(p)
+		}
+		if e != nil {
+			CallJs("弹出错误提示", e.Error())
+			return false
+		}
+		CallJs("弹出成功提示", "已应用配置文件")
+		return true
 	case "导入证书":
 		CaFilePath := args.GetData("CaFilePath")
 		KeyFilePath := args.GetData("KeyFilePath")
@@ -1428,6 +1306,7 @@ func event(command string, args *JSON.SyJson) any {
 			a.Ok = true
 			CallJs("启动状态", "")
 		} else {
+			MCPLog("error", "proxy", fmt.Sprintf("代理服务在端口 %d 上重启失败: %s", Port, app.App.Error.Error()))
 			a.Err = base64.StdEncoding.EncodeToString([]byte(app.App.Error.Error()))
 			CallJs("启动状态", a.Err)
 		}
@@ -1510,10 +1389,127 @@ func event(command string, args *JSON.SyJson) any {
 		}
 		return map[string]interface{}{"success": true}
 	case "获取MCP状态":
-		if mcpServer == nil {
-			return map[string]interface{}{"running": false, "port": 29999}
+		status := map[string]interface{}{
+			"running":        false,
+			"port":           29999,
+			"bindAddress":    GlobalConfig.MCP.BindAddress,
+			"allowedOrigins": GlobalConfig.MCP.AllowedOrigins,
+			"token":          GlobalConfig.GetMCPToken(),
+		}
+		if mcpServer != nil {
+			status["running"] = mcpServer.IsRunning()
+			status["port"] = mcpServer.GetPort()
+		}
+		return status
+	case "重置MCP令牌":
+		token, e := GlobalConfig.ResetMCPToken()
+		if e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		// 运行中的服务立即使用新令牌
+		if mcpServer != nil {
+			mcpServer.SetToken(token)
+		}
+		return map[string]interface{}{"success": true, "token": token}
+	case "保存MCP网络配置":
+		var origins []string
+		_ = json.Unmarshal([]byte(args.GetData("AllowedOrigins")), &origins)
+		if e := GlobalConfig.SaveMCPNetConfig(args.GetData("BindAddress"), origins); e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		return map[string]interface{}{"success": true, "bindAddress": GlobalConfig.MCP.BindAddress, "allowedOrigins": GlobalConfig.MCP.AllowedOrigins, "message": "重启MCP服务后生效"}
+	case "获取MCP提示词模板":
+		return map[string]interface{}{"success": true, "builtin": builtinPromptDefinitions(), "templates": userPromptTemplates()}
+	case "保存MCP提示词模板":
+		var t ConfigPromptTemplate
+		if e := json.Unmarshal([]byte(args.GetData("Data")), &t); e != nil {
+			return map[string]interface{}{"success": false, "error": "JSON解析失败: " + e.Error()}
+		}
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" || strings.TrimSpace(t.Template) == "" {
+			return map[string]interface{}{"success": false, "error": "名称和模板内容不能为空"}
+		}
+		if findBuiltinPrompt(t.Name) != nil {
+			return map[string]interface{}{"success": false, "error": "不能覆盖内置提示词: " + t.Name}
+		}
+		if t.Arguments == nil {
+			t.Arguments = make([]ConfigPromptArgument, 0)
+		}
+		if e := GlobalConfig.SavePromptTemplate(t); e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		notifyPromptsChanged()
+		return map[string]interface{}{"success": true, "template": t}
+	case "删除MCP提示词模板":
+		ok, e := GlobalConfig.DeletePromptTemplate(args.GetData("Name"))
+		if e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		if ok {
+			notifyPromptsChanged()
+		}
+		return map[string]interface{}{"success": ok}
+	case "获取MCP工具列表":
+		list := make([]map[string]interface{}, 0)
+		for _, t := range GetToolsList() {
+			t := t
+			list = append(list, map[string]interface{}{"name": t.Name, "description": t.Description, "source": toolSourceOf(t.Name), "annotations": t.Annotations, "policy": toolPolicyOf(&t)})
+		}
+		configLock.Lock()
+		custom := make([]ConfigMCPTool, len(GlobalConfig.CustomTools))
+		copy(custom, GlobalConfig.CustomTools)
+		configLock.Unlock()
+		return map[string]interface{}{"success": true, "tools": list, "custom": custom}
+	case "保存MCP自定义工具":
+		var t ConfigMCPTool
+		if e := json.Unmarshal([]byte(args.GetData("Data")), &t); e != nil {
+			return map[string]interface{}{"success": false, "error": "JSON解析失败: " + e.Error()}
 		}
-		return map[string]interface{}{"running": mcpServer.IsRunning(), "port": mcpServer.GetPort()}
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" {
+			return map[string]interface{}{"success": false, "error": "名称不能为空"}
+		}
+		if t.Tool == "" && t.ScriptFunc == "" {
+			return map[string]interface{}{"success": false, "error": "请指定要调用的工具或脚本函数"}
+		}
+		if source := toolSourceOf(t.Name); source != "" && source != toolSourceConfig {
+			return map[string]interface{}{"success": false, "error": "工具名称已被占用: " + t.Name}
+		}
+		if t.InputSchema != nil {
+			if typ, _ := t.InputSchema["type"].(string); typ != "object" {
+				return map[string]interface{}{"success": false, "error": "InputSchema 的 type 必须是 object"}
+			}
+		}
+		if e := checkConfigToolCycle(t); e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		if e := GlobalConfig.SaveCustomTool(t); e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		return map[string]interface{}{"success": true, "skipped": reloadConfigTools()}
+	case "删除MCP自定义工具":
+		ok, e := GlobalConfig.DeleteCustomTool(args.GetData("Name"))
+		if e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		if ok {
+			reloadConfigTools()
+		}
+		return map[string]interface{}{"success": ok}
+
+	case "保存MCP工具策略":
+		policy := make(map[string]string)
+		if data := args.GetData("ToolPolicy"); data != "" {
+			if e := json.Unmarshal([]byte(data), &policy); e != nil {
+				return map[string]interface{}{"success": false, "error": "JSON解析失败: " + e.Error()}
+			}
+		}
+		if e := GlobalConfig.SaveMCPToolPolicy(args.GetData("ReadOnly") == "true", policy); e != nil {
+			return map[string]interface{}{"success": false, "error": e.Error()}
+		}
+		return map[string]interface{}{"success": true}
+	case "MCP工具确认结果":
+		return ResolveToolConfirmation(getInt(args.GetData("Id")), args.GetData("Allow") == "true")
 
 	// ============ 解密分析类命令 ============
 	case "解密数据包":
@@ -1557,6 +1553,7 @@ func event(command string, args *JSON.SyJson) any {
 			return map[string]interface{}{"success": false, "error": "无效的Theology参数"}
 		}
 		h := HashMap.GetRequest(theology)
+		defer HashMap.Release(h)
 		if h == nil {
 			return map[string]interface{}{"success": false, "error": "请求不存在或已被删除"}
 		}
@@ -1688,6 +1685,7 @@ func event(command string, args *JSON.SyJson) any {
 			return map[string]interface{}{"success": false, "error": "无效的Theology参数"}
 		}
 		h := HashMap.GetRequest(theology)
+		defer HashMap.Release(h)
 		if h == nil {
 			return map[string]interface{}{"success": false, "error": "请求不存在或已被删除"}
 		}
@@ -1763,6 +1761,7 @@ func event(command string, args *JSON.SyJson) any {
 			return map[string]interface{}{"success": false, "error": "无效的Theology参数"}
 		}
 		h := HashMap.GetRequest(theology)
+		defer HashMap.Release(h)
 		if h == nil {
 			return map[string]interface{}{"success": false, "error": "请求不存在或已被删除"}
 		}
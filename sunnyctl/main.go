@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sunnyctl - 通过MCP HTTP接口查询和控制正在运行的SunnyNet
+// 每个子命令对应 buildToolsList 中的一个或几个工具，方便在脚本与CI中使用
+
+const usage = `用法: sunnyctl [全局参数] <命令> [参数]
+
+命令:
+  list     [--host H] [--status N] [--method M] [--limit N] [--offset N]   列出捕获的请求
+  get      <theology> [--body]                                             查看请求详情
+  export   --har|--pcap [--decrypted] [--file F] [--host H] [--status N]   导出为HAR或PCAP-NG
+  rules    list | add --type T --source S --target D | rm <hash> | clear   管理替换规则
+  proxy    start | stop | status | port <N>                                控制代理服务
+  tail     [--host H] [--status N] [--interval 1s]                         持续输出新请求
+  tools                                                                    列出可用的MCP工具
+  call     <tool> [JSON参数]                                               直接调用MCP工具
+
+全局参数:
+`
+
+// 默认的服务地址（主程序未写入发现文件时使用）
+const defaultMCPURL = "http://127.0.0.1:29999/mcp"
+
+// 请求时声明的协议版本
+const protocolVersion = "2025-06-18"
+
+var (
+	flagURL    = flag.String("url", "", "MCP服务地址（默认读取 ~/Sunny/mcp_runtime.json，或环境变量 SUNNYNET_MCP_URL）")
+	flagToken  = flag.String("token", "", "访问令牌（默认读取 ~/Sunny/mcp_runtime.json，或环境变量 SUNNYNET_MCP_TOKEN）")
+	flagOutput = flag.String("o", "table", "输出格式: table 或 json")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *flagOutput != "table" && *flagOutput != "json" {
+		fatalf("不支持的输出格式: %s", *flagOutput)
+	}
+
+	c := newClient()
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "list":
+		err = cmdList(c, args)
+	case "get":
+		err = cmdGet(c, args)
+	case "export":
+		err = cmdExport(c, args)
+	case "rules":
+		err = cmdRules(c, args)
+	case "proxy":
+		err = cmdProxy(c, args)
+	case "tail":
+		err = cmdTail(c, args)
+	case "tools":
+		err = cmdTools(c)
+	case "call":
+		err = cmdCall(c, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sunnyctl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// parseArgs 解析子命令参数，允许参数与位置参数交错（如 get 12 --body）
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			os.Exit(2)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// ============ MCP 客户端 ============
+
+// client MCP HTTP 客户端（无会话的纯POST调用）
+type client struct {
+	url   string
+	token string
+	http  *http.Client
+	seq   int
+}
+
+// newClient 按 参数 > 环境变量 > 发现文件 > 默认值 确定服务地址与令牌
+func newClient() *client {
+	c := &client{url: defaultMCPURL, http: &http.Client{Timeout: 5 * time.Minute}}
+	homeDir, _ := os.UserHomeDir()
+	if homeDir != "" {
+		var info struct {
+			URL   string `json:"url"`
+			Token string `json:"token"`
+		}
+		bs, err := os.ReadFile(filepath.Join(homeDir, "Sunny", "mcp_runtime.json"))
+		if err == nil && json.Unmarshal(bs, &info) == nil && info.URL != "" {
+			c.url = info.URL
+			c.token = info.Token
+		}
+	}
+	if u := os.Getenv("SUNNYNET_MCP_URL"); u != "" {
+		c.url = u
+	}
+	if t := os.Getenv("SUNNYNET_MCP_TOKEN"); t != "" {
+		c.token = t
+	}
+	if *flagURL != "" {
+		c.url = *flagURL
+	}
+	if *flagToken != "" {
+		c.token = *flagToken
+	}
+	return c
+}
+
+// rpc 发送一条JSON-RPC请求并返回 result
+func (c *client) rpc(method string, params interface{}) (json.RawMessage, error) {
+	c.seq++
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": c.seq, "method": method, "params": params})
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("MCP-Protocol-Version", protocolVersion)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("连接SunnyNet失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("访问令牌无效，请通过 -token 或环境变量 SUNNYNET_MCP_TOKEN 指定")
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int         `json:"code"`
+			Message string      `json:"message"`
+			Data    interface{} `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("无效的响应 (%s): %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if response.Error != nil {
+		if response.Error.Data != nil {
+			return nil, fmt.Errorf("%s: %v", response.Error.Message, response.Error.Data)
+		}
+		return nil, errors.New(response.Error.Message)
+	}
+	return response.Result, nil
+}
+
+// callTool 调用工具，工具执行失败（isError）时返回错误；结果为JSON时解码到 out
+func (c *client) callTool(name string, args map[string]interface{}, out interface{}) error {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	raw, err := c.rpc("tools/call", map[string]interface{}{"name": name, "arguments": args})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	text := ""
+	if len(result.Content) > 0 {
+		text = result.Content[0].Text
+	}
+	if result.IsError {
+		return fmt.Errorf("%s: %s", name, text)
+	}
+	if out == nil {
+		return nil
+	}
+	data := []byte(text)
+	if len(result.StructuredContent) > 0 && string(result.StructuredContent) != "null" {
+		data = result.StructuredContent
+	}
+	if p, ok := out.(*json.RawMessage); ok && !json.Valid(data) {
+		// 文本结果按JSON字符串返回
+		*p, _ = json.Marshal(text)
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// ============ 输出 ============
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// printTable 以对齐的表格输出
+func printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// ============ 请求 ============
+
+// requestInfo request_list 中的一条请求
+type requestInfo struct {
+	Theology   int    `json:"theology"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	ClientIP   string `json:"clientIP"`
+	PID        string `json:"pid"`
+	SendTime   string `json:"sendTime"`
+	RecTime    string `json:"recTime"`
+	Way        string `json:"way"`
+	Notes      string `json:"notes"`
+}
+
+type requestList struct {
+	Total    int           `json:"total"`
+	Offset   int           `json:"offset"`
+	Limit    int           `json:"limit"`
+	Requests []requestInfo `json:"requests"`
+}
+
+// filterFlags list、export、tail 共用的过滤参数
+type filterFlags struct {
+	host   *string
+	status *int
+	method *string
+}
+
+func addFilterFlags(fs *flag.FlagSet) filterFlags {
+	return filterFlags{
+		host:   fs.String("host", "", "只包含该主机名的请求"),
+		status: fs.Int("status", 0, "只包含该响应状态码的请求"),
+		method: fs.String("method", "", "只包含该请求方式的请求"),
+	}
+}
+
+func (f filterFlags) args() map[string]interface{} {
+	args := map[string]interface{}{}
+	if *f.host != "" {
+		args["host"] = *f.host
+	}
+	if *f.status != 0 {
+		args["status"] = *f.status
+	}
+	if *f.method != "" {
+		args["method"] = *f.method
+	}
+	return args
+}
+
+func requestRows(list []requestInfo) [][]string {
+	rows := make([][]string, 0, len(list))
+	for _, r := range list {
+		status := ""
+		if r.StatusCode != 0 {
+			status = strconv.Itoa(r.StatusCode)
+		}
+		rows = append(rows, []string{strconv.Itoa(r.Theology), r.Method, status, r.SendTime, r.PID, truncate(r.URL, 100)})
+	}
+	return rows
+}
+
+var requestHeader = []string{"ID", "方式", "状态", "时间", "进程", "地址"}
+
+func cmdList(c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	filter := addFilterFlags(fs)
+	limit := fs.Int("limit", 100, "返回的最大数量")
+	offset := fs.Int("offset", 0, "偏移量")
+	parseArgs(fs, args)
+
+	toolArgs := filter.args()
+	toolArgs["limit"] = *limit
+	toolArgs["offset"] = *offset
+	var list requestList
+	if err := c.callTool("request_list", toolArgs, &list); err != nil {
+		return err
+	}
+	if *flagOutput == "json" {
+		return printJSON(list)
+	}
+	printTable(requestHeader, requestRows(list.Requests))
+	fmt.Fprintf(os.Stderr, "共 %d 条，显示 %d 条\n", list.Total, len(list.Requests))
+	return nil
+}
+
+// requestDetail request_get 的结果
+type requestDetail struct {
+	Theology int    `json:"theology"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Proto    string `json:"proto"`
+	Request  struct {
+		Headers map[string][]string `json:"headers"`
+		Body    string              `json:"body"`
+		BodyB64 string              `json:"bodyBase64"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int                 `json:"statusCode"`
+		Headers    map[string][]string `json:"headers"`
+		Body       string              `json:"body"`
+		BodyB64    string              `json:"bodyBase64"`
+		Error      bool                `json:"error"`
+	} `json:"response"`
+	ClientIP string `json:"clientIP"`
+	PID      string `json:"pid"`
+	SendTime string `json:"sendTime"`
+	RecTime  string `json:"recTime"`
+	Way      string `json:"way"`
+	Notes    string `json:"notes"`
+}
+
+func (c *client) getRequest(theology int) (*requestDetail, error) {
+	var detail requestDetail
+	if err := c.callTool("request_get", map[string]interface{}{"theology": theology}, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+func cmdGet(c *client, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	withBody := fs.Bool("body", false, "同时输出请求体与响应体")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return errors.New("用法: get <theology> [--body]")
+	}
+	theology, err := strconv.Atoi(positional[0])
+	if err != nil {
+		return fmt.Errorf("无效的请求ID: %s", positional[0])
+	}
+	detail, err := c.getRequest(theology)
+	if err != nil {
+		return err
+	}
+	if !*withBody {
+		detail.Request.Body, detail.Request.BodyB64 = "", ""
+		detail.Response.Body, detail.Response.BodyB64 = "", ""
+	}
+	if *flagOutput == "json" {
+		return printJSON(detail)
+	}
+
+	fmt.Printf("%s %s %s\n", detail.Method, detail.URL, detail.Proto)
+	printHeaders(detail.Request.Headers)
+	if *withBody && detail.Request.Body != "" {
+		fmt.Printf("\n%s\n", detail.Request.Body)
+	}
+	fmt.Printf("\n%d\n", detail.Response.StatusCode)
+	printHeaders(detail.Response.Headers)
+	if *withBody && detail.Response.Body != "" {
+		fmt.Printf("\n%s\n", detail.Response.Body)
+	}
+	return nil
+}
+
+func printHeaders(headers map[string][]string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range headers[name] {
+			fmt.Printf("%s: %s\n", name, v)
+		}
+	}
+}
+
+// listAll 分页获取全部符合条件的请求（最新的在前）
+func (c *client) listAll(filter map[string]interface{}) ([]requestInfo, error) {
+	const pageSize = 500
+	all := make([]requestInfo, 0)
+	for offset := 0; ; offset += pageSize {
+		args := map[string]interface{}{"limit": pageSize, "offset": offset}
+		for k, v := range filter {
+			args[k] = v
+		}
+		var page requestList
+		if err := c.callTool("request_list", args, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Requests...)
+		if offset+pageSize >= page.Total {
+			return all, nil
+		}
+	}
+}
+
+// ============ 导出 ============
+
+func cmdExport(c *client, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	har := fs.Bool("har", false, "导出为 HAR 1.2")
+	pcap := fs.Bool("pcap", false, "导出 TCP、UDP 与 Websocket 会话为 PCAP-NG")
+	decrypted := fs.Bool("decrypted", false, "导出 PCAP-NG 时包含 TLS 会话解密后的明文")
+	file := fs.String("file", "", "输出文件（默认输出到标准输出）")
+	filter := addFilterFlags(fs)
+	parseArgs(fs, args)
+	if *har == *pcap {
+		return errors.New("请指定一种导出格式：--har 或 --pcap")
+	}
+
+	list, err := c.listAll(filter.args())
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return errors.New("没有符合条件的请求")
+	}
+	// 按时间先后排列
+	theologies := make([]int, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		theologies = append(theologies, list[i].Theology)
+	}
+	var doc bytes.Buffer
+	summary := ""
+	if *har {
+		var res struct {
+			Count int             `json:"count"`
+			HAR   json.RawMessage `json:"har"`
+		}
+		if err := c.callTool("har_export", map[string]interface{}{"theologies": theologies}, &res); err != nil {
+			return err
+		}
+		if err := json.Indent(&doc, res.HAR, "", "  "); err != nil {
+			return err
+		}
+		doc.WriteByte('\n')
+		summary = fmt.Sprintf("%d 条请求", res.Count)
+	} else {
+		var res struct {
+			Sessions int    `json:"sessions"`
+			Packets  int    `json:"packets"`
+			PcapNG   string `json:"pcapng"`
+		}
+		if err := c.callTool("pcap_export", map[string]interface{}{"theologies": theologies, "decrypted": *decrypted}, &res); err != nil {
+			return err
+		}
+		bs, err := base64.StdEncoding.DecodeString(res.PcapNG)
+		if err != nil {
+			return err
+		}
+		doc.Write(bs)
+		summary = fmt.Sprintf("%d 个会话（%d 个数据包）", res.Sessions, res.Packets)
+	}
+
+	out := os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := doc.WriteTo(out); err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Fprintf(os.Stderr, "已导出 %s 到 %s\n", summary, *file)
+	}
+	return nil
+}
+
+// ============ 替换规则 ============
+
+func cmdRules(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: rules list | add --type T --source S --target D | rm <hash> | clear")
+	}
+	switch args[0] {
+	case "list":
+		var result struct {
+			Rules []struct {
+				Type string `json:"Type"`
+				Src  string `json:"Src"`
+				Dest string `json:"Dest"`
+				Hash string `json:"Hash"`
+			} `json:"rules"`
+			Total int `json:"total"`
+		}
+		if err := c.callTool("replace_rules_list", nil, &result); err != nil {
+			return err
+		}
+		if *flagOutput == "json" {
+			return printJSON(result)
+		}
+		rows := make([][]string, 0, len(result.Rules))
+		for _, r := range result.Rules {
+			rows = append(rows, []string{r.Hash, r.Type, truncate(r.Src, 40), truncate(r.Dest, 40)})
+		}
+		printTable([]string{"Hash", "类型", "源内容", "替换内容"}, rows)
+		return nil
+	case "add":
+		fs := flag.NewFlagSet("rules add", flag.ExitOnError)
+		ruleType := fs.String("type", "String(UTF8)", "替换类型: Base64、HEX、String(UTF8)、String(GBK)、响应文件")
+		source := fs.String("source", "", "要匹配的内容")
+		target := fs.String("target", "", "替换内容（响应文件类型为文件路径）")
+		parseArgs(fs, args[1:])
+		return c.callAndPrint("replace_rules_add", map[string]interface{}{"type": *ruleType, "source": *source, "target": *target})
+	case "rm", "remove":
+		if len(args) != 2 {
+			return errors.New("用法: rules rm <hash>")
+		}
+		return c.callAndPrint("replace_rules_remove", map[string]interface{}{"hash": args[1]})
+	case "clear":
+		return c.callAndPrint("replace_rules_clear", nil)
+	}
+	return fmt.Errorf("未知的 rules 子命令: %s", args[0])
+}
+
+// ============ 代理控制 ============
+
+func cmdProxy(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: proxy start | stop | status | port <N>")
+	}
+	switch args[0] {
+	case "start":
+		return c.callAndPrint("proxy_start", nil)
+	case "stop":
+		return c.callAndPrint("proxy_stop", nil)
+	case "status":
+		return c.callAndPrint("proxy_get_status", nil)
+	case "port":
+		if len(args) != 2 {
+			return errors.New("用法: proxy port <N>")
+		}
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("无效的端口: %s", args[1])
+		}
+		return c.callAndPrint("proxy_set_port", map[string]interface{}{"port": port})
+	}
+	return fmt.Errorf("未知的 proxy 子命令: %s", args[0])
+}
+
+// callAndPrint 调用工具并输出结果：JSON格式原样输出，表格格式输出为 键  值 两列
+func (c *client) callAndPrint(name string, args map[string]interface{}) error {
+	var result json.RawMessage
+	if err := c.callTool(name, args, &result); err != nil {
+		return err
+	}
+	var obj map[string]interface{}
+	if *flagOutput == "json" || json.Unmarshal(result, &obj) != nil {
+		var v interface{}
+		_ = json.Unmarshal(result, &v)
+		return printJSON(v)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		v := obj[k]
+		text, ok := v.(string)
+		if !ok {
+			bs, _ := json.Marshal(v)
+			text = string(bs)
+		}
+		rows = append(rows, []string{k, truncate(text, 120)})
+	}
+	printTable([]string{"字段", "值"}, rows)
+	return nil
+}
+
+// ============ 持续输出 ============
+
+func cmdTail(c *client, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	filter := addFilterFlags(fs)
+	interval := fs.Duration("interval", time.Second, "查询新请求的间隔")
+	parseArgs(fs, args)
+
+	// 从当前最新的请求之后开始
+	after := 0
+	var latest requestList
+	if err := c.callTool("request_list", map[string]interface{}{"limit": 1}, &latest); err != nil {
+		return err
+	}
+	if len(latest.Requests) > 0 {
+		after = latest.Requests[0].Theology
+	}
+	// 表格格式逐行输出，使用固定列宽
+	const tailFormat = "%-8s %-7s %-4s %-12s %s\n"
+	if *flagOutput == "table" {
+		fmt.Printf(tailFormat, "ID", "方式", "状态", "时间", "地址")
+	}
+	for {
+		toolArgs := filter.args()
+		toolArgs["after"] = after
+		toolArgs["limit"] = 1000
+		var page requestList
+		if err := c.callTool("request_list", toolArgs, &page); err != nil {
+			fmt.Fprintf(os.Stderr, "sunnyctl: %v\n", err)
+		}
+		// 按时间先后输出
+		for i := len(page.Requests) - 1; i >= 0; i-- {
+			r := page.Requests[i]
+			if r.Theology > after {
+				after = r.Theology
+			}
+			if *flagOutput == "json" {
+				bs, _ := json.Marshal(r)
+				fmt.Println(string(bs))
+			} else {
+				row := requestRows([]requestInfo{r})[0]
+				fmt.Printf(tailFormat, row[0], row[1], row[2], row[3], row[5])
+			}
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// ============ 通用 ============
+
+func cmdTools(c *client) error {
+	raw, err := c.rpc("tools/list", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Tools []struct {
+			Name        string                 `json:"name"`
+			Description string                 `json:"description"`
+			InputSchema map[string]interface{} `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	if *flagOutput == "json" {
+		return printJSON(result.Tools)
+	}
+	rows := make([][]string, 0, len(result.Tools))
+	for _, t := range result.Tools {
+		rows = append(rows, []string{t.Name, truncate(t.Description, 80)})
+	}
+	printTable([]string{"工具", "说明"}, rows)
+	return nil
+}
+
+func cmdCall(c *client, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("用法: call <tool> [JSON参数]")
+	}
+	toolArgs := map[string]interface{}{}
+	if len(args) == 2 {
+		if err := json.Unmarshal([]byte(args[1]), &toolArgs); err != nil {
+			return fmt.Errorf("参数必须是JSON对象: %v", err)
+		}
+	}
+	var result json.RawMessage
+	if err := c.callTool(args[0], toolArgs, &result); err != nil {
+		return err
+	}
+	var v interface{}
+	_ = json.Unmarshal(result, &v)
+	return printJSON(v)
+}